@@ -0,0 +1,118 @@
+package goscreenmonit
+
+import (
+	"image"
+	"sync"
+	"time"
+)
+
+// Window over which the effective upload rate is measured
+const effectiveFPSWindow = 10 * time.Second
+
+// Decides which captures are uploaded and measures the effective upload rate. With an idle
+// rate set, captures are only uploaded when enough of the screen changed since the last
+// upload, or at the idle rate while the screen is static
+type ActivityMonitor struct {
+	mutex     sync.Mutex
+	idleFPS   float64
+	threshold float64
+	tileSize  int
+	last      []*image.RGBA
+	lastSent  time.Time
+	sent      []time.Time
+}
+
+// Create an activity monitor that uploads every capture
+func NewActivityMonitor() *ActivityMonitor {
+	return &ActivityMonitor{tileSize: DefaultTileSize}
+}
+
+// Only upload captures where more than threshold (0-1) of the screen tiles changed,
+// falling back to idleFPS while the screen is static. An idle rate of zero uploads every capture
+func (mon *ActivityMonitor) SetAdaptive(idleFPS, threshold float64) {
+	mon.mutex.Lock()
+	defer mon.mutex.Unlock()
+	mon.idleFPS = idleFPS
+	mon.threshold = threshold
+	mon.last = nil
+}
+
+// Check if a capture should be uploaded
+func (mon *ActivityMonitor) ShouldUpload(frames []*image.RGBA, now time.Time) bool {
+	mon.mutex.Lock()
+	defer mon.mutex.Unlock()
+
+	if mon.idleFPS <= 0 || mon.last == nil {
+		return true
+	}
+
+	// Keep a static screen alive at the idle rate
+	if now.Sub(mon.lastSent) >= time.Duration(float64(time.Second)/mon.idleFPS) {
+		return true
+	}
+
+	return changedFraction(mon.last, frames, mon.tileSize) > mon.threshold
+}
+
+// Record an uploaded capture as the reference for change detection
+func (mon *ActivityMonitor) Uploaded(frames []*image.RGBA, now time.Time) {
+	mon.mutex.Lock()
+	defer mon.mutex.Unlock()
+
+	mon.last = frames
+	mon.lastSent = now
+	mon.sent = append(mon.sent, now)
+	mon.trim(now)
+}
+
+// Get the uploads per second over the last measurement window
+func (mon *ActivityMonitor) EffectiveFPS(now time.Time) float64 {
+	mon.mutex.Lock()
+	defer mon.mutex.Unlock()
+
+	mon.trim(now)
+	if len(mon.sent) == 0 {
+		return 0
+	}
+
+	// Measure from the first upload when the window isn't full yet
+	span := effectiveFPSWindow
+	if elapsed := now.Sub(mon.sent[0]); elapsed < span {
+		span = elapsed
+	}
+	if span < time.Second {
+		span = time.Second
+	}
+	return float64(len(mon.sent)) / span.Seconds()
+}
+
+// Drop upload times older than the measurement window
+func (mon *ActivityMonitor) trim(now time.Time) {
+	cutoff := now.Add(-effectiveFPSWindow)
+	drop := 0
+	for drop < len(mon.sent) && mon.sent[drop].Before(cutoff) {
+		drop++
+	}
+	mon.sent = mon.sent[drop:]
+}
+
+// Get the fraction of tiles that changed across all displays, a layout change counts as a full change
+func changedFraction(prev, next []*image.RGBA, size int) float64 {
+	if !sameLayout(prev, next) {
+		return 1
+	}
+
+	total, changed := 0, 0
+	for i, img := range next {
+		if img == nil {
+			continue
+		}
+		bounds := img.Bounds()
+		total += ((bounds.Dx() + size - 1) / size) * ((bounds.Dy() + size - 1) / size)
+		changed += len(changedTiles(prev[i], img, size))
+	}
+	if total == 0 {
+		return 0
+	}
+	return float64(changed) / float64(total)
+}
@@ -0,0 +1,141 @@
+package goscreenmonit
+
+import (
+	"image"
+	"math"
+	"testing"
+	"time"
+)
+
+// Clock advanced by hand, for driving a synthetic capturer
+type fakeClock struct {
+	now time.Time
+}
+
+func (clock *fakeClock) Now() time.Time {
+	return clock.now
+}
+
+// Create a synthetic capturer whose pattern moves on a fake clock
+func newClockedCapturer(clock *fakeClock, changeRate float64) *SyntheticCapturer {
+	c := NewSyntheticCapturer(2, 320, 200, changeRate)
+	c.start = clock.now
+	c.now = clock.Now
+	return c
+}
+
+// Capture every synthetic display
+func captureAll(t *testing.T, c *SyntheticCapturer) []*image.RGBA {
+	t.Helper()
+	frames := make([]*image.RGBA, c.ScreenCount())
+	for i := range frames {
+		img, err := c.Capture(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		frames[i] = img
+	}
+	return frames
+}
+
+// Capture at fps for a duration, returning how many captures the monitor let through
+func runCaptures(t *testing.T, mon *ActivityMonitor, c *SyntheticCapturer, clock *fakeClock, fps int, duration time.Duration) int {
+	t.Helper()
+	uploads := 0
+	interval := time.Second / time.Duration(fps)
+	for end := clock.now.Add(duration); clock.now.Before(end); clock.now = clock.now.Add(interval) {
+		frames := captureAll(t, c)
+		if mon.ShouldUpload(frames, clock.now) {
+			mon.Uploaded(frames, clock.now)
+			uploads++
+		}
+	}
+	return uploads
+}
+
+func TestSyntheticFrameFollowsClock(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	c := newClockedCapturer(clock, 4)
+
+	if f := c.Frame(); f != 0 {
+		t.Fatalf("frame %d at start, want 0", f)
+	}
+	clock.now = clock.now.Add(2500 * time.Millisecond)
+	if f := c.Frame(); f != 10 {
+		t.Fatalf("frame %d after 2.5s at 4 changes per second, want 10", f)
+	}
+
+	// The same frame renders the same pixels
+	a, b := captureAll(t, c), captureAll(t, c)
+	if changedFraction(a, b, DefaultTileSize) != 0 {
+		t.Error("identical frames rendered differently")
+	}
+
+	// A static pattern never changes
+	static := newClockedCapturer(clock, 0)
+	clock.now = clock.now.Add(time.Hour)
+	if f := static.Frame(); f != 0 {
+		t.Errorf("static capturer at frame %d, want 0", f)
+	}
+}
+
+func TestAdaptiveStaticScreenUsesIdleRate(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	c := newClockedCapturer(clock, 0)
+	mon := NewActivityMonitor()
+	mon.SetAdaptive(1, 0.01)
+
+	// The first capture is always sent, then one per idle interval
+	uploads := runCaptures(t, mon, c, clock, 10, 30*time.Second)
+	if uploads < 30 || uploads > 31 {
+		t.Errorf("%d uploads of a static screen over 30s at 1 idle fps, want 30-31", uploads)
+	}
+	if fps := mon.EffectiveFPS(clock.now); math.Abs(fps-1) > 0.15 {
+		t.Errorf("effective fps %.2f on a static screen, want about 1", fps)
+	}
+}
+
+func TestAdaptiveMovingScreenUsesCaptureRate(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	c := newClockedCapturer(clock, 10)
+	mon := NewActivityMonitor()
+	mon.SetAdaptive(1, 0.01)
+
+	// The pattern moves on every capture so every capture is sent
+	uploads := runCaptures(t, mon, c, clock, 10, 30*time.Second)
+	if uploads != 300 {
+		t.Errorf("%d uploads of a moving screen over 30s at 10 fps, want 300", uploads)
+	}
+	if fps := mon.EffectiveFPS(clock.now); math.Abs(fps-10) > 0.5 {
+		t.Errorf("effective fps %.2f on a moving screen, want about 10", fps)
+	}
+}
+
+func TestAdaptiveDropsToIdleRateWhenScreenStops(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	c := newClockedCapturer(clock, 10)
+	mon := NewActivityMonitor()
+	mon.SetAdaptive(1, 0.01)
+
+	runCaptures(t, mon, c, clock, 10, 10*time.Second)
+	if fps := mon.EffectiveFPS(clock.now); fps < 9 {
+		t.Fatalf("effective fps %.2f while moving, want about 10", fps)
+	}
+
+	// Freeze the pattern, after a full measurement window only idle uploads remain
+	c.ChangeRate = 0
+	runCaptures(t, mon, c, clock, 10, 2*effectiveFPSWindow)
+	if fps := mon.EffectiveFPS(clock.now); math.Abs(fps-1) > 0.15 {
+		t.Errorf("effective fps %.2f after the screen stopped, want about 1", fps)
+	}
+}
+
+func TestAdaptiveDisabledUploadsEverything(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	c := newClockedCapturer(clock, 0)
+	mon := NewActivityMonitor()
+
+	if uploads := runCaptures(t, mon, c, clock, 5, 10*time.Second); uploads != 50 {
+		t.Errorf("%d uploads without adaptive mode over 10s at 5 fps, want 50", uploads)
+	}
+}
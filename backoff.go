@@ -0,0 +1,48 @@
+package goscreenmonit
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Exponential backoff with jitter, so agents that lost the same server don't retry in lockstep
+type Backoff struct {
+	Min     time.Duration
+	Max     time.Duration
+	attempt int
+	rand    *rand.Rand
+}
+
+// Create a backoff starting at min and doubling up to max
+func NewBackoff(min, max time.Duration) *Backoff {
+	return &Backoff{
+		Min:  min,
+		Max:  max,
+		rand: rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// Get the wait before the next attempt, between half and all of the current delay
+func (backoff *Backoff) Next() time.Duration {
+	delay := backoff.Min
+	for i := 0; i < backoff.attempt && delay < backoff.Max; i++ {
+		delay *= 2
+	}
+	if delay > backoff.Max {
+		delay = backoff.Max
+	}
+	backoff.attempt++
+
+	half := delay / 2
+	return half + time.Duration(backoff.rand.Int63n(int64(delay-half)+1))
+}
+
+// Get how many waits were handed out since the last reset
+func (backoff *Backoff) Attempt() int {
+	return backoff.attempt
+}
+
+// Start over from the minimum delay
+func (backoff *Backoff) Reset() {
+	backoff.attempt = 0
+}
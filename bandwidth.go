@@ -0,0 +1,230 @@
+package goscreenmonit
+
+import (
+	"context"
+	"image"
+	"log"
+	"sync"
+	"time"
+)
+
+// How often bandwidth usage is measured and the quality level reconsidered
+const bandwidthWindow = 2 * time.Second
+
+// Usage above this fraction of the limit steps quality down, below the lower fraction steps it up
+const (
+	bandwidthHighWater = 0.9
+	bandwidthLowWater  = 0.5
+)
+
+// Weight of the newest sample in the send latency average
+const latencySmoothing = 0.2
+
+// Limits a byte rate, letting single messages larger than the burst through by going into debt
+type TokenBucket struct {
+	mutex  sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// Create a full token bucket refilling at rate bytes per second up to burst bytes
+func NewTokenBucket(rate, burst int64) *TokenBucket {
+	return &TokenBucket{
+		rate:   float64(rate),
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// Take n bytes from the bucket, returning how long to wait before sending them
+func (bucket *TokenBucket) Reserve(n int, now time.Time) time.Duration {
+	bucket.mutex.Lock()
+	defer bucket.mutex.Unlock()
+
+	// Refill for the time since the last reservation
+	bucket.tokens += now.Sub(bucket.last).Seconds() * bucket.rate
+	if bucket.tokens > bucket.burst {
+		bucket.tokens = bucket.burst
+	}
+	bucket.last = now
+
+	bucket.tokens -= float64(n)
+	if bucket.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-bucket.tokens / bucket.rate * float64(time.Second))
+}
+
+// One step of trading image quality for bandwidth, each value is a fraction of the configured setting
+type QualityLevel struct {
+	Scale   float64
+	Quality float64
+	FPS     float64
+}
+
+// Quality levels from best to cheapest: lower jpeg quality first, then frame rate, then resolution
+var QualityLevels = []QualityLevel{
+	{Scale: 1, Quality: 1, FPS: 1},
+	{Scale: 1, Quality: 0.8, FPS: 1},
+	{Scale: 1, Quality: 0.6, FPS: 1},
+	{Scale: 1, Quality: 0.6, FPS: 0.5},
+	{Scale: 0.75, Quality: 0.6, FPS: 0.5},
+	{Scale: 0.5, Quality: 0.5, FPS: 0.5},
+	{Scale: 0.5, Quality: 0.5, FPS: 0.25},
+}
+
+// Keeps uploads under a bandwidth limit with a token bucket and by stepping through quality
+// levels, while measuring send throughput and latency
+type BandwidthController struct {
+	mutex       sync.Mutex
+	limit       int64
+	bucket      *TokenBucket
+	level       int
+	windowStart time.Time
+	windowBytes int64
+	throughput  float64
+	latency     time.Duration
+}
+
+// Create a controller capping uploads at limit bytes per second, zero only measures
+func NewBandwidthController(limit int64) *BandwidthController {
+	ctl := &BandwidthController{
+		limit:       limit,
+		windowStart: time.Now(),
+	}
+	if limit > 0 {
+		ctl.bucket = NewTokenBucket(limit, limit)
+	}
+	return ctl
+}
+
+// Get the bytes per second limit, zero is unlimited
+func (ctl *BandwidthController) Limit() int64 {
+	return ctl.limit
+}
+
+// Get the quality level currently in use
+func (ctl *BandwidthController) Level() QualityLevel {
+	ctl.mutex.Lock()
+	defer ctl.mutex.Unlock()
+	return QualityLevels[ctl.level]
+}
+
+// Block until a message of n bytes fits in the bandwidth limit or the context is cancelled
+func (ctl *BandwidthController) Wait(ctx context.Context, n int) error {
+	if ctl.bucket == nil {
+		return nil
+	}
+	wait := ctl.bucket.Reserve(n, time.Now())
+	if wait <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// Record a sent message and how long the send took, adjusting the quality level once per window
+func (ctl *BandwidthController) Sent(n int, took time.Duration, now time.Time) {
+	ctl.mutex.Lock()
+	defer ctl.mutex.Unlock()
+
+	ctl.windowBytes += int64(n)
+	if ctl.latency == 0 {
+		ctl.latency = took
+	} else {
+		ctl.latency = time.Duration(latencySmoothing*float64(took) + (1-latencySmoothing)*float64(ctl.latency))
+	}
+
+	elapsed := now.Sub(ctl.windowStart)
+	if elapsed < bandwidthWindow {
+		return
+	}
+	ctl.throughput = float64(ctl.windowBytes) / elapsed.Seconds()
+	ctl.windowStart = now
+	ctl.windowBytes = 0
+
+	if ctl.limit <= 0 {
+		return
+	}
+
+	// Step down while near the limit, back up once there is plenty of headroom
+	level := ctl.level
+	if ctl.throughput > bandwidthHighWater*float64(ctl.limit) && level < len(QualityLevels)-1 {
+		level++
+	} else if ctl.throughput < bandwidthLowWater*float64(ctl.limit) && level > 0 {
+		level--
+	}
+	if level != ctl.level {
+		log.Printf("Upload rate %.0f B/s with limit %d B/s, quality level %d -> %d\n", ctl.throughput, ctl.limit, ctl.level, level)
+		ctl.level = level
+	}
+}
+
+// Get the measured upload bytes per second and average send latency
+func (ctl *BandwidthController) Stats() (float64, time.Duration) {
+	ctl.mutex.Lock()
+	defer ctl.mutex.Unlock()
+	return ctl.throughput, ctl.latency
+}
+
+// Downscale an image by averaging the source pixels under each destination pixel
+func scaleRGBA(img *image.RGBA, scale float64) *image.RGBA {
+	if img == nil || scale >= 1 {
+		return img
+	}
+
+	src := img.Bounds()
+	width := int(float64(src.Dx()) * scale)
+	height := int(float64(src.Dy()) * scale)
+	if width < 1 {
+		width = 1
+	}
+	if height < 1 {
+		height = 1
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	for y := 0; y < height; y++ {
+		sy0 := src.Min.Y + y*src.Dy()/height
+		sy1 := src.Min.Y + (y+1)*src.Dy()/height
+		if sy1 <= sy0 {
+			sy1 = sy0 + 1
+		}
+		for x := 0; x < width; x++ {
+			sx0 := src.Min.X + x*src.Dx()/width
+			sx1 := src.Min.X + (x+1)*src.Dx()/width
+			if sx1 <= sx0 {
+				sx1 = sx0 + 1
+			}
+
+			var r, g, b, a, n uint32
+			for sy := sy0; sy < sy1; sy++ {
+				off := img.PixOffset(sx0, sy)
+				for sx := sx0; sx < sx1; sx++ {
+					r += uint32(img.Pix[off])
+					g += uint32(img.Pix[off+1])
+					b += uint32(img.Pix[off+2])
+					a += uint32(img.Pix[off+3])
+					off += 4
+					n++
+				}
+			}
+
+			doff := dst.PixOffset(x, y)
+			dst.Pix[doff] = uint8(r / n)
+			dst.Pix[doff+1] = uint8(g / n)
+			dst.Pix[doff+2] = uint8(b / n)
+			dst.Pix[doff+3] = uint8(a / n)
+		}
+	}
+	return dst
+}
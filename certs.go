@@ -0,0 +1,89 @@
+package goscreenmonit
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// Returned when a server certificate doesn't match any pinned fingerprint
+type PinMismatchError struct {
+	CertFingerprint string
+	SPKIFingerprint string
+}
+
+func (e *PinMismatchError) Error() string {
+	return fmt.Sprintf("server certificate doesn't match pinned fingerprints (cert %s, spki %s)", e.CertFingerprint, e.SPKIFingerprint)
+}
+
+// Load a pem encoded certificate bundle into a pool
+func loadCertPool(path string) (*x509.CertPool, error) {
+	pemdata, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pemdata) {
+		return nil, errors.New("no certificates found in " + path)
+	}
+	return pool, nil
+}
+
+// Get the hex sha256 fingerprint of a certificate's DER encoding
+func CertFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// Get the hex sha256 fingerprint of a certificate's public key info
+func SPKIFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return hex.EncodeToString(sum[:])
+}
+
+// Normalize a pin to lowercase hex without separators or prefixes
+func normalizePin(pin string) (string, error) {
+	pin = strings.ToLower(strings.TrimSpace(pin))
+	pin = strings.TrimPrefix(pin, "sha256:")
+	pin = strings.Replace(pin, ":", "", -1)
+	raw, err := hex.DecodeString(pin)
+	if err != nil || len(raw) != sha256.Size {
+		return "", fmt.Errorf("invalid sha256 pin: %q", pin)
+	}
+	return pin, nil
+}
+
+// Create a tls peer verification callback accepting certificates matching a pin
+func verifyPins(pins []string) func([][]byte, [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return errors.New("server presented no certificate")
+		}
+		leaf, err := x509.ParseCertificate(rawCerts[0])
+		if err != nil {
+			return err
+		}
+
+		// Accept either a certificate or public key fingerprint
+		certfp, spkifp := CertFingerprint(leaf), SPKIFingerprint(leaf)
+		for _, pin := range pins {
+			if pin == certfp || pin == spkifp {
+				return nil
+			}
+		}
+		return &PinMismatchError{CertFingerprint: certfp, SPKIFingerprint: spkifp}
+	}
+}
+
+// Check if a dial error was caused by the server failing certificate verification
+func isVerificationError(err error) bool {
+	var pinerr *PinMismatchError
+	var authErr x509.UnknownAuthorityError
+	var invalidErr x509.CertificateInvalidError
+	var hostErr x509.HostnameError
+	return errors.As(err, &pinerr) || errors.As(err, &authErr) || errors.As(err, &invalidErr) || errors.As(err, &hostErr)
+}
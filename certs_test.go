@@ -0,0 +1,261 @@
+package goscreenmonit
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Certificate and key generated for a test, with the paths they were written to
+type testCert struct {
+	cert     *x509.Certificate
+	key      *ecdsa.PrivateKey
+	certPath string
+	keyPath  string
+}
+
+// Generate a certificate signed by parent, or self signed when parent is nil, and write it to dir
+func generateCert(t *testing.T, dir, name string, parent *testCert, isCA bool) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		DNSNames:     []string{"localhost"},
+	}
+	if isCA {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+		template.ExtKeyUsage = nil
+		template.DNSNames = nil
+	}
+	signer, signerKey := template, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tc := &testCert{
+		cert:     cert,
+		key:      key,
+		certPath: filepath.Join(dir, name+".crt"),
+		keyPath:  filepath.Join(dir, name+".key"),
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	if err := ioutil.WriteFile(tc.certPath, certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(tc.keyPath, keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return tc
+}
+
+// CA, server and client certificates for mtls tests
+type testPKI struct {
+	dir      string
+	ca       *testCert
+	server   *testCert
+	client   *testCert
+	otherCA  *testCert
+	stranger *testCert
+}
+
+func newTestPKI(t *testing.T) *testPKI {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "gsm-certs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pki := &testPKI{dir: dir}
+	pki.ca = generateCert(t, dir, "ca", nil, true)
+	pki.server = generateCert(t, dir, "server", pki.ca, false)
+	pki.client = generateCert(t, dir, "agent1", pki.ca, false)
+	pki.otherCA = generateCert(t, dir, "otherca", nil, true)
+	pki.stranger = generateCert(t, dir, "stranger", pki.otherCA, false)
+	return pki
+}
+
+func (pki *testPKI) Close() {
+	os.RemoveAll(pki.dir)
+}
+
+// Result of a handshake between a server and an agent
+type handshakeResult struct {
+	serverErr error
+	clientErr error
+	identity  *CertIdentity
+}
+
+// Run a tls handshake between a server and session config over loopback tcp
+func handshake(t *testing.T, server *Server, session *Session) handshakeResult {
+	t.Helper()
+	serverConf, err := server.TLSConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", serverConf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	accepted := make(chan handshakeResult, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			accepted <- handshakeResult{serverErr: err}
+			return
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		err = conn.(*tls.Conn).Handshake()
+		accepted <- handshakeResult{serverErr: err, identity: connIdentity(conn)}
+	}()
+
+	clientConf := session.tlsConfig()
+	clientConf.ServerName = "localhost"
+	conn, err := tls.Dial("tcp", listener.Addr().String(), clientConf)
+	result := <-accepted
+	result.clientErr = err
+	if conn != nil {
+		conn.Close()
+	}
+	return result
+}
+
+// Create a session trusting the test CA, optionally presenting a client certificate
+func newTestSession(t *testing.T, pki *testPKI, client *testCert) *Session {
+	t.Helper()
+	session := NewSession("localhost:0", 1, Registration{}, nil)
+	if err := session.SetServerCA(pki.ca.certPath); err != nil {
+		t.Fatal(err)
+	}
+	if client != nil {
+		if err := session.SetClientCertificate(client.certPath, client.keyPath); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return session
+}
+
+// Create a server requiring client certificates from the test CA
+func newMTLSServer(pki *testPKI) *Server {
+	server := NewServer("127.0.0.1:0", pki.server.certPath, pki.server.keyPath)
+	server.RequireClientCerts(pki.ca.certPath)
+	return server
+}
+
+func TestMTLSValidClientCert(t *testing.T) {
+	pki := newTestPKI(t)
+	defer pki.Close()
+
+	result := handshake(t, newMTLSServer(pki), newTestSession(t, pki, pki.client))
+	if result.serverErr != nil || result.clientErr != nil {
+		t.Fatalf("handshake failed: server %v, client %v", result.serverErr, result.clientErr)
+	}
+	if result.identity == nil {
+		t.Fatal("no identity attached to a verified connection")
+	}
+	if result.identity.CommonName != "agent1" {
+		t.Errorf("identity common name %q, want agent1", result.identity.CommonName)
+	}
+	if result.identity.Fingerprint != CertFingerprint(pki.client.cert) {
+		t.Errorf("identity fingerprint %s, want %s", result.identity.Fingerprint, CertFingerprint(pki.client.cert))
+	}
+}
+
+func TestMTLSMissingClientCert(t *testing.T) {
+	pki := newTestPKI(t)
+	defer pki.Close()
+
+	result := handshake(t, newMTLSServer(pki), newTestSession(t, pki, nil))
+	if result.serverErr == nil {
+		t.Fatal("server accepted an agent without a client certificate")
+	}
+	if result.identity != nil {
+		t.Errorf("identity %+v attached without a client certificate", result.identity)
+	}
+}
+
+func TestMTLSWrongCAClientCert(t *testing.T) {
+	pki := newTestPKI(t)
+	defer pki.Close()
+
+	result := handshake(t, newMTLSServer(pki), newTestSession(t, pki, pki.stranger))
+	if result.serverErr == nil {
+		t.Fatal("server accepted a client certificate from another CA")
+	}
+	if result.identity != nil {
+		t.Errorf("identity %+v attached to an unverified certificate", result.identity)
+	}
+}
+
+func TestPinnedServerCert(t *testing.T) {
+	pki := newTestPKI(t)
+	defer pki.Close()
+	server := NewServer("127.0.0.1:0", pki.server.certPath, pki.server.keyPath)
+
+	// A matching pin is enough without a CA bundle, by certificate or public key
+	for _, pin := range []string{CertFingerprint(pki.server.cert), "sha256:" + SPKIFingerprint(pki.server.cert)} {
+		session := NewSession("localhost:0", 1, Registration{}, nil)
+		if err := session.PinServerCert([]string{pin}); err != nil {
+			t.Fatal(err)
+		}
+		if result := handshake(t, server, session); result.clientErr != nil {
+			t.Errorf("pin %s: handshake failed: %v", pin, result.clientErr)
+		}
+	}
+
+	// Any other pin is a verification failure
+	session := NewSession("localhost:0", 1, Registration{}, nil)
+	if err := session.PinServerCert([]string{CertFingerprint(pki.client.cert)}); err != nil {
+		t.Fatal(err)
+	}
+	result := handshake(t, server, session)
+	var pinerr *PinMismatchError
+	if !errors.As(result.clientErr, &pinerr) {
+		t.Fatalf("handshake error %v, want a pin mismatch", result.clientErr)
+	}
+	if !isVerificationError(result.clientErr) {
+		t.Error("pin mismatch is not reported as a verification error")
+	}
+	if pinerr.CertFingerprint != CertFingerprint(pki.server.cert) {
+		t.Errorf("mismatch reports fingerprint %s, want %s", pinerr.CertFingerprint, CertFingerprint(pki.server.cert))
+	}
+}
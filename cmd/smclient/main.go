@@ -1,10 +1,14 @@
 package main
 
 import (
+	"fmt"
 	"log"
 	"os"
 	"os/exec"
+	"strings"
+	"time"
 
+	"github.com/micaiahwallace/goscreenmonit"
 	"github.com/micaiahwallace/gowatchprog"
 )
 
@@ -28,7 +32,7 @@ func main() {
 		/**
 		Handle service installation in the AllUsers context
 		*/
-		prog, exeDir := makeCurrentProgram(os.Args[2:])
+		prog, exeDir := makeCurrentProgram(absFileArgs(os.Args[2:]))
 		log.Println("Installing service.")
 
 		// Create path and command to install with user context for each user that signs in
@@ -52,11 +56,16 @@ func main() {
 		/**
 		Handle service installation in the CurrentUser context
 		*/
-		prog, _ := makeCurrentProgram(os.Args[2:])
+		prog, _ := makeCurrentProgram(absFileArgs(os.Args[2:]))
 		prog.StartupContext = gowatchprog.CurrentUser
 		logToDataDir(prog, LOG_FILE)
 		log.Println("Installing service for user.")
 
+		// A reinstall starts over after the server refused the agent
+		if err := os.Remove(dataDirPath(prog, STOP_FILE)); err != nil && !os.IsNotExist(err) {
+			log.Printf("Unable to remove stop marker: %v\n", err)
+		}
+
 		// Register for startup
 		if serr := prog.RegisterStartup(); serr != nil {
 			log.Fatalf("Startup register failed: %v\n", serr)
@@ -80,6 +89,14 @@ func main() {
 			log.Fatalf("Uninstall failed: %v\n", err)
 		}
 
+	case "status":
+		/**
+		Print the connection state of the running agent
+		*/
+		prog, _ := makeCurrentProgram([]string{})
+		prog.StartupContext = gowatchprog.CurrentUser
+		printStatus(dataDirPath(prog, STATUS_FILE))
+
 	case "watch":
 		/**
 		Begin the watchdog to restart the service when it fails indefinitely
@@ -94,7 +111,7 @@ func main() {
 		prog.StartupContext = gowatchprog.CurrentUser
 		logToDataDir(prog, LOG_FILE)
 		log.Println("Gsm client running.")
-		run()
+		run(prog)
 		log.Println("Gsm client stopped.")
 	}
 }
@@ -104,6 +121,13 @@ func startWatchdog(args []string) {
 	prog, _ := makeCurrentProgram(args)
 	prog.StartupContext = gowatchprog.CurrentUser
 	logToDataDir(prog, LOG_FILE)
+
+	// Nothing to watch once the server refused the agent
+	stopPath := dataDirPath(prog, STOP_FILE)
+	if stoppedForGood(stopPath) {
+		log.Println("Agent was refused by the server, not starting watchdog.")
+		return
+	}
 	log.Println("Starting watchdog service.")
 
 	// Setup communication channels
@@ -118,6 +142,12 @@ func startWatchdog(args []string) {
 		select {
 		case msg := <-msgs:
 			log.Println(msg)
+
+			// The watchdog restarts the agent however it exited, so stop watching once it was refused
+			if stoppedForGood(stopPath) {
+				log.Println("Agent was refused by the server, stopping watchdog.")
+				complete = true
+			}
 		case err := <-errs:
 			log.Println(err)
 		case <-quit:
@@ -126,3 +156,28 @@ func startWatchdog(args []string) {
 	}
 	log.Println("Watchdog process quit")
 }
+
+// Print the connection state written by the running agent
+func printStatus(path string) {
+	status, err := goscreenmonit.LoadSessionStatus(path)
+	if err != nil {
+		fmt.Printf("No agent status available: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("State:       %s\n", status.State)
+	fmt.Printf("Server:      %s\n", status.Server)
+	fmt.Printf("Servers:     %s\n", strings.Join(status.Servers, ", "))
+	if status.State == goscreenmonit.StateRegistering || status.State.Online() {
+		fmt.Printf("Connected:   %s (%v)\n", status.ConnectedSince.Format(time.RFC3339), time.Since(status.ConnectedSince).Round(time.Second))
+		fmt.Printf("Failed over: %v\n", status.FailedOver)
+	}
+	if status.State == goscreenmonit.StateDisconnected && !status.RetryAt.IsZero() {
+		fmt.Printf("Attempt:     %d\n", status.Attempt)
+		fmt.Printf("Retry at:    %s\n", status.RetryAt.Format(time.RFC3339))
+	}
+	if status.LastError != "" {
+		fmt.Printf("Last error:  %s\n", status.LastError)
+	}
+	fmt.Printf("Updated:     %s\n", status.UpdatedAt.Format(time.RFC3339))
+}
@@ -1,22 +1,63 @@
 package main
 
 import (
+	"context"
 	"flag"
+	"fmt"
 	"log"
 	"os"
+	"os/signal"
 	"os/user"
 	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/micaiahwallace/goscreenmonit"
+	"github.com/micaiahwallace/gowatchprog"
 )
 
+const CREDENTIAL_FILE = "credential.json"
+const AGENT_ID_FILE = "agent-id"
+const STATUS_FILE = "status.json"
+const SPOOL_DIR = "spool"
+const STOP_FILE = "stopped.json"
+
 // Start running the program
-func run() {
+func run(prog *gowatchprog.Program) {
 
 	// Parse cli arguments
-	var server, fpsStr string
-	flag.StringVar(&server, "server", "127.0.0.1:3000", "Specify server address")
+	var server, fpsStr, certPath, keyPath, caPath, pins, token, capture, synSize string
+	var synDisplays, maxUpload, maxControl int
+	var synRate, idleFPS, changeThreshold float64
+	var adaptive bool
+	var maxBandwidth, spoolSize int64
+	var spoolFPS float64
+	var heartbeat, heartbeatTimeout, writeTimeout, spoolAge, failback time.Duration
+	flag.StringVar(&server, "server", "127.0.0.1:3000", "Specify server address or wss://host:port/agent websocket url, or a comma separated list in priority order to fail over between")
+	flag.DurationVar(&failback, "failback", goscreenmonit.DefaultFailbackInterval, "How often to check for a preferred server while failed over, backing off while it stays down, 0 disables failing back")
 	flag.StringVar(&fpsStr, "fps", "1", "Specify recording framerate")
+	flag.BoolVar(&adaptive, "adaptive", false, "Only upload frames when the screen changed, capturing at -fps")
+	flag.Float64Var(&idleFPS, "idlefps", 0.2, "Upload rate while the screen is static in adaptive mode")
+	flag.Float64Var(&changeThreshold, "changethreshold", 0.005, "Fraction of the screen (0-1) that must change before uploading in adaptive mode")
+	flag.Int64Var(&maxBandwidth, "maxbandwidth", 0, "Upload limit in bytes per second, lowering quality, frame rate and resolution to stay under it, 0 is unlimited")
+	flag.DurationVar(&heartbeat, "heartbeat", goscreenmonit.DefaultHeartbeatInterval, "Longest time between heartbeats, the server may ask for them more often")
+	flag.DurationVar(&heartbeatTimeout, "heartbeattimeout", goscreenmonit.DefaultHeartbeatTimeout, "Reconnect when the server is silent for longer than this")
+	flag.DurationVar(&writeTimeout, "writetimeout", goscreenmonit.DefaultWriteTimeout, "Reconnect when a write blocks longer than this")
+	flag.IntVar(&maxUpload, "maxupload", goscreenmonit.DefaultMaxUploadFrame, "Largest upload frame in bytes to send, bigger captures are dropped")
+	flag.IntVar(&maxControl, "maxcontrol", goscreenmonit.DefaultMaxControlFrame, "Largest frame in bytes accepted from the server before disconnecting")
+	flag.Int64Var(&spoolSize, "spoolsize", 0, "Keep up to this many bytes of captures on disk while disconnected and upload them after reconnecting, 0 disables spooling")
+	flag.DurationVar(&spoolAge, "spoolage", 24*time.Hour, "Drop spooled captures older than this, 0 keeps them until the size limit")
+	flag.Float64Var(&spoolFPS, "spoolfps", 0.2, "Capture rate while disconnected when spooling")
+	flag.StringVar(&certPath, "cert", "", "Specify client certificate file for mutual tls")
+	flag.StringVar(&keyPath, "key", "", "Specify client private key file for mutual tls")
+	flag.StringVar(&caPath, "ca", "", "Verify the server against this CA bundle instead of the system roots")
+	flag.StringVar(&pins, "pin", "", "Comma separated sha256 fingerprints of the server certificate or public key")
+	flag.StringVar(&token, "token", "", "Specify enrollment token used on first connect")
+	flag.StringVar(&capture, "capture", "screen", "Specify capture source: screen or synthetic")
+	flag.IntVar(&synDisplays, "syndisplays", 1, "Number of synthetic displays")
+	flag.StringVar(&synSize, "synsize", "1280x720", "Resolution of synthetic displays")
+	flag.Float64Var(&synRate, "synrate", 1, "Synthetic pattern changes per second, 0 for a static image")
 	flag.Parse()
 
 	// Get framerate int
@@ -27,6 +68,7 @@ func run() {
 
 	// Log current settings
 	log.Println("Current configuration:")
+	log.Printf("Agent version: %v (protocol v%d)\n", goscreenmonit.AgentVersion, goscreenmonit.ProtocolVersion)
 	log.Printf("FPS: %v\n", fpsStr)
 	log.Printf("Server: %v\n", server)
 
@@ -36,21 +78,133 @@ func run() {
 		log.Fatalf("System information cannot be retreived: %v\n", syserr)
 	}
 
+	// Get the persistent agent id
+	agentID, iderr := loadAgentID(dataDirPath(prog, AGENT_ID_FILE))
+	if iderr != nil {
+		log.Fatalf("Agent id cannot be loaded: %v\n", iderr)
+	}
+	log.Printf("Agent ID: %v\n", agentID)
+
 	// Create server registration data
 	registration := goscreenmonit.Registration{
-		Host: hostName,
-		User: userName,
+		AgentID: agentID,
+		Host:    hostName,
+		User:    userName,
 	}
 
 	// Create and start a new session
-	session := goscreenmonit.NewSession(server, fps, registration)
-	quit := make(chan int)
-	session.Start(quit)
+	// Create the capture source
+	var capturer goscreenmonit.Capturer
+	switch capture {
+	case "screen":
+		capturer = goscreenmonit.ScreenCapturer{}
+	case "synthetic":
+		var width, height int
+		if _, err := fmt.Sscanf(synSize, "%dx%d", &width, &height); err != nil || width <= 0 || height <= 0 {
+			log.Fatalf("Please specify a valid synthetic resolution such as 1280x720")
+		}
+		capturer = goscreenmonit.NewSyntheticCapturer(synDisplays, width, height, synRate)
+		log.Printf("Synthetic capture: %d displays at %s, %v changes per second\n", synDisplays, synSize, synRate)
+	default:
+		log.Fatalf("Invalid capture source: %s\n", capture)
+	}
+
+	servers := strings.Split(server, ",")
+	session := goscreenmonit.NewSession(servers[0], fps, registration, capturer)
+	session.SetServers(servers)
+	session.SetStatusFile(dataDirPath(prog, STATUS_FILE))
+	if failback < 0 {
+		log.Fatalln("Please specify a failback interval of 0 or more")
+	}
+	session.SetFailbackInterval(failback)
+	if adaptive {
+		if idleFPS <= 0 || changeThreshold < 0 || changeThreshold >= 1 {
+			log.Fatalln("Please specify an idle fps above 0 and a change threshold from 0 to 1")
+		}
+		session.SetAdaptive(idleFPS, changeThreshold)
+		log.Printf("Adaptive frame rate: idle %v fps, change threshold %v\n", idleFPS, changeThreshold)
+	}
+	if heartbeat <= 0 || heartbeatTimeout <= heartbeat {
+		log.Fatalln("Please specify a heartbeat above 0 and a heartbeat timeout longer than it")
+	}
+	session.SetHeartbeat(heartbeat, heartbeatTimeout)
+	session.SetWriteTimeout(writeTimeout)
+	if maxUpload <= 0 || maxControl <= 0 {
+		log.Fatalln("Please specify frame size limits above 0")
+	}
+	session.SetMaxFrameSize(maxUpload, maxControl)
+	if spoolSize > 0 {
+		spool, err := goscreenmonit.NewSpool(dataDirPath(prog, SPOOL_DIR), spoolSize, spoolAge)
+		if err != nil {
+			log.Fatalf("Unable to open spool: %v\n", err)
+		}
+		if err := session.SetSpool(spool, spoolFPS); err != nil {
+			log.Fatalf("Please specify a valid spool fps: %v\n", err)
+		}
+		log.Printf("Spooling up to %d bytes at %v fps while disconnected\n", spoolSize, spoolFPS)
+	}
+	if maxBandwidth > 0 {
+		session.SetBandwidthLimit(maxBandwidth)
+		log.Printf("Bandwidth limit: %d bytes per second\n", maxBandwidth)
+	}
+	if certPath != "" || keyPath != "" {
+		if err := session.SetClientCertificate(certPath, keyPath); err != nil {
+			log.Fatalf("Unable to load client certificate: %v\n", err)
+		}
+		log.Printf("Client certificate: %v\n", certPath)
+	}
+	if err := session.SetEnrollment(token, dataDirPath(prog, CREDENTIAL_FILE)); err != nil {
+		log.Fatalf("Unable to load agent credential: %v\n", err)
+	}
+	if caPath != "" {
+		if err := session.SetServerCA(caPath); err != nil {
+			log.Fatalf("Unable to load server CA bundle: %v\n", err)
+		}
+		log.Printf("Server CA: %v\n", caPath)
+	}
+	if pins != "" {
+		if err := session.PinServerCert(strings.Split(pins, ",")); err != nil {
+			log.Fatalf("Unable to parse server pin: %v\n", err)
+		}
+		log.Printf("Server pins: %v\n", pins)
+	}
+
+	// Stay stopped after the server refused this agent, until it is reinstalled
+	stopPath := dataDirPath(prog, STOP_FILE)
+	if marker, err := readStopMarker(stopPath); err != nil {
+		log.Printf("Unable to read stop marker: %v\n", err)
+	} else if marker != nil {
+		log.Printf("Agent was refused by the server at %s (%s), not starting. Reinstall or remove %s to start again.\n", marker.StoppedAt.Format(time.RFC3339), marker.Reason, stopPath)
+		os.Exit(marker.ExitCode)
+	}
+
+	session.SubscribeState(func(change goscreenmonit.StateChange) {
+		log.Printf("Session %v -> %v\n", change.From, change.To)
+	})
+	if err := session.Start(context.Background()); err != nil {
+		log.Fatalf("Unable to start session: %v\n", err)
+	}
 	log.Println("Client agent running.")
 
-	// Check for quit signal
-	code := <-quit
+	// Stop cleanly on a termination signal, or when the server ends the session
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	select {
+	case sig := <-signals:
+		log.Printf("Received %v, stopping.\n", sig)
+		session.Stop()
+	case <-session.Done():
+	}
+	code := session.ExitCode()
 	log.Printf("Received quit signal: %d\n", code)
+
+	// A refused agent would be refused again, so keep the watchdog from restarting it. Agents
+	// quitting for an upgrade or any other failure may start again.
+	if code == goscreenmonit.ExitRefused {
+		if err := writeStopMarker(stopPath, code, session.Status().LastError); err != nil {
+			log.Printf("Unable to write stop marker: %v\n", err)
+		}
+	}
 	os.Exit(code)
 }
 
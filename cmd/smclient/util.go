@@ -1,7 +1,11 @@
 package main
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
@@ -38,9 +42,90 @@ func makeCurrentProgram(args []string) (*gowatchprog.Program, string) {
 	return prog, exePath
 }
 
+// Flags whose values are file paths that must survive relocation on install
+var fileFlags = []string{"ca", "cert", "key"}
+
 // Create a file path with arguments appended
 func createPathWithArgs(path string, args []string) string {
-	return fmt.Sprintf(`"%s" %s`, path, strings.Join(args, " "))
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		if strings.ContainsAny(arg, " \t\"") {
+			arg = fmt.Sprintf(`"%s"`, strings.Replace(arg, `"`, `\"`, -1))
+		}
+		quoted[i] = arg
+	}
+	return fmt.Sprintf(`"%s" %s`, path, strings.Join(quoted, " "))
+}
+
+// Convert relative file flag values to absolute paths so installed copies still find them
+func absFileArgs(args []string) []string {
+	result := make([]string, len(args))
+	copy(result, args)
+
+	for i := 0; i < len(result); i++ {
+		name := strings.TrimLeft(result[i], "-")
+		if name == result[i] {
+			continue
+		}
+
+		// Handle both -flag=value and -flag value forms
+		if eq := strings.Index(name, "="); eq >= 0 {
+			if isFileFlag(name[:eq]) {
+				result[i] = "-" + name[:eq] + "=" + absPath(name[eq+1:])
+			}
+		} else if isFileFlag(name) && i+1 < len(result) {
+			result[i+1] = absPath(result[i+1])
+			i++
+		}
+	}
+
+	return result
+}
+
+// Check if a flag name holds a file path
+func isFileFlag(name string) bool {
+	for _, f := range fileFlags {
+		if f == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Get an absolute path, falling back to the original on error
+func absPath(path string) string {
+	if path == "" {
+		return path
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		log.Printf("unable to resolve path %s. %v\n", path, err)
+		return path
+	}
+	return abs
+}
+
+// Load the persistent agent id, generating and storing one on first run
+func loadAgentID(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err == nil && len(strings.TrimSpace(string(data))) > 0 {
+		return strings.TrimSpace(string(data)), nil
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+
+	// Generate a random id
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	id := hex.EncodeToString(b)
+
+	if err := ioutil.WriteFile(path, []byte(id), 0644); err != nil {
+		return "", err
+	}
+	return id, nil
 }
 
 // Get path to current executable and its directory
@@ -88,9 +173,55 @@ func logToFile(filename string) {
 
 // Set log file based on program data directory
 func logToDataDir(p *gowatchprog.Program, fileName string) {
+	logToFile(dataDirPath(p, fileName))
+}
+
+// Get the path to a file in the program data directory, falling back to the exe directory
+func dataDirPath(p *gowatchprog.Program, fileName string) string {
 	dir, err := p.DataDirectory(true)
 	if err != nil {
 		log.Printf("unable to get or create data directory. %v", err)
+		if dir, _, err = currentExePath(); err != nil {
+			log.Println(err)
+		}
+	}
+	return filepath.Join(dir, fileName)
+}
+
+// Written when the server refuses the agent, so neither it nor the watchdog keep retrying
+type stopMarker struct {
+	ExitCode  int       `json:"exitCode"`
+	Reason    string    `json:"reason"`
+	StoppedAt time.Time `json:"stoppedAt"`
+}
+
+// Record why the agent stopped for good
+func writeStopMarker(path string, code int, reason string) error {
+	data, err := json.MarshalIndent(&stopMarker{ExitCode: code, Reason: reason, StoppedAt: time.Now()}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+// Read the stop marker, nil when the agent may run
+func readStopMarker(path string) (*stopMarker, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
 	}
-	logToFile(filepath.Join(dir, fileName))
+	if err != nil {
+		return nil, err
+	}
+	marker := &stopMarker{}
+	if err := json.Unmarshal(data, marker); err != nil {
+		return nil, err
+	}
+	return marker, nil
+}
+
+// Check if the agent stopped for good
+func stoppedForGood(path string) bool {
+	marker, _ := readStopMarker(path)
+	return marker != nil
 }
@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/micaiahwallace/goscreenmonit"
 )
@@ -12,28 +14,130 @@ import (
 func main() {
 
 	// Parse cli arguments
-	var maddress, waddress, certPath, keyPath string
+	var maddress, waddress, shared, certPath, keyPath, clientCAPath, enrollPath, duplicates, codecs string
+	var viewerQueue, quality, maxUpload, maxControl int
+	var onDemand bool
+	var backgroundFPS float64
+	var viewerStall, retainAge, gcInterval, heartbeat, heartbeatTimeout, writeTimeout time.Duration
+	var recordDir string
+	var retainSize int64
 	flag.StringVar(&maddress, "mserver", "127.0.0.1:3000", "Specify listening address for monitor server")
 	flag.StringVar(&waddress, "wserver", "127.0.0.1:8080", "Specify listening address for web server")
+	flag.StringVar(&shared, "listen", "", "Serve agents and the web server on this one address instead of -mserver and -wserver")
 	flag.StringVar(&certPath, "cert", "server.crt", "Specify certificate file")
 	flag.StringVar(&keyPath, "key", "server.key", "Specify private key file")
+	flag.StringVar(&clientCAPath, "clientca", "", "Require agent client certificates signed by this CA bundle (mtls)")
+	flag.StringVar(&enrollPath, "enroll", "", "Require agent enrollment, storing tokens and credentials in this file")
+	flag.StringVar(&duplicates, "duplicate", "reject", "Policy when a connected agent id registers again: replace or reject")
+	flag.StringVar(&codecs, "codecs", strings.Join(goscreenmonit.DefaultCodecPreference, ","), "Image codecs offered to agents in order of preference: png, jpeg, raw.zstd")
+	flag.IntVar(&quality, "quality", goscreenmonit.DefaultImageQuality, "Image quality 1-100 for lossy codecs")
+	flag.BoolVar(&onDemand, "ondemand", false, "Only stream from agents while a viewer is watching them")
+	flag.Float64Var(&backgroundFPS, "backgroundfps", 0, "Frame rate agents sample at while nobody watches in on-demand mode, 0 idles them")
+	flag.DurationVar(&heartbeat, "heartbeat", goscreenmonit.DefaultHeartbeatInterval, "How often agents are asked to send a heartbeat")
+	flag.DurationVar(&heartbeatTimeout, "heartbeattimeout", goscreenmonit.DefaultHeartbeatTimeout, "Disconnect agents silent for longer than this")
+	flag.DurationVar(&writeTimeout, "writetimeout", goscreenmonit.DefaultWriteTimeout, "Disconnect agents whose writes block longer than this")
+	flag.IntVar(&maxUpload, "maxupload", goscreenmonit.DefaultMaxUploadFrame, "Disconnect agents sending frames larger than this many bytes")
+	flag.IntVar(&maxControl, "maxcontrol", goscreenmonit.DefaultMaxControlFrame, "Largest frame in bytes sent to agents")
+	flag.IntVar(&viewerQueue, "viewerqueue", goscreenmonit.DefaultViewerQueue, "Frames queued per viewer before the oldest is dropped")
+	flag.DurationVar(&viewerStall, "viewerstall", goscreenmonit.DefaultViewerStall, "Disconnect viewers whose writes block longer than this")
+	flag.StringVar(&recordDir, "record", "", "Record every received frame to this directory")
+	flag.DurationVar(&retainAge, "retainage", 7*24*time.Hour, "Delete recorded frames older than this, 0 keeps forever")
+	flag.Int64Var(&retainSize, "retainsize", 1<<30, "Maximum recorded bytes per agent, 0 is unlimited")
+	flag.DurationVar(&gcInterval, "gcinterval", 5*time.Minute, "How often to apply recording retention")
 	flag.Parse()
 
 	// Display settings
 	fmt.Println("Current configuration:")
-	fmt.Println("Monitor server: ", maddress)
-	fmt.Println("Web server: ", waddress)
+	if shared != "" {
+		fmt.Println("Shared server: ", shared)
+	} else {
+		fmt.Println("Monitor server: ", maddress)
+		fmt.Println("Web server: ", waddress)
+	}
 	fmt.Println("Cert: ", certPath)
 	fmt.Println("Key: ", keyPath)
+	fmt.Println("Client CA: ", clientCAPath)
+	fmt.Println("Enrollment store: ", enrollPath)
+	fmt.Println("Duplicate agents: ", duplicates)
+	fmt.Println("Recording: ", recordDir)
+	fmt.Println("Codecs: ", codecs)
 
 	// Create a new monitor server and start it
 	server := goscreenmonit.NewServer(maddress, certPath, keyPath)
+	if clientCAPath != "" {
+		server.RequireClientCerts(clientCAPath)
+	}
+	if err := server.SetCodecs(strings.Split(codecs, ","), quality); err != nil {
+		log.Fatalf("Invalid codecs: %v\n", err)
+	}
+	if onDemand {
+		server.SetOnDemand(backgroundFPS)
+		log.Printf("On-demand streaming, background sampling at %v fps\n", backgroundFPS)
+	}
+	if heartbeat <= 0 || heartbeatTimeout <= heartbeat {
+		log.Fatalln("Please specify a heartbeat above 0 and a heartbeat timeout longer than it")
+	}
+	server.SetHeartbeat(heartbeat, heartbeatTimeout)
+	server.SetWriteTimeout(writeTimeout)
+	if maxUpload <= 0 || maxControl <= 0 {
+		log.Fatalln("Please specify frame size limits above 0")
+	}
+	server.SetMaxFrameSize(maxUpload, maxControl)
+	switch duplicates {
+	case "replace":
+		server.SetDuplicatePolicy(goscreenmonit.DuplicateReplace)
+	case "reject":
+		server.SetDuplicatePolicy(goscreenmonit.DuplicateReject)
+	default:
+		log.Fatalf("Invalid duplicate policy: %s\n", duplicates)
+	}
+	if enrollPath != "" {
+		store, err := goscreenmonit.OpenEnrollmentStore(enrollPath)
+		if err != nil {
+			log.Fatalf("Unable to open enrollment store: %v\n", err)
+		}
+		server.RequireEnrollment(store)
+	}
+	var frameStore *goscreenmonit.FrameStore
+	if recordDir != "" {
+		store, err := goscreenmonit.NewFrameStore(recordDir, goscreenmonit.RetentionPolicy{
+			MaxAge:        retainAge,
+			MaxAgentBytes: retainSize,
+			GCInterval:    gcInterval,
+		})
+		if err != nil {
+			log.Fatalf("Unable to open recording store: %v\n", err)
+		}
+		store.Start(server)
+		frameStore = store
+		log.Printf("Recording frames to %s (retain %v, %d bytes per agent)\n", recordDir, retainAge, retainSize)
+	}
+
+	// Create a new webserver
+	webServer := goscreenmonit.NewWebServer(waddress, certPath, keyPath, server)
+	webServer.SetViewerLimits(viewerQueue, viewerStall)
+	if frameStore != nil {
+		webServer.SetFrameStore(frameStore)
+	}
+
+	// Split one port between agents and the web server
+	if shared != "" {
+		portMux, err := goscreenmonit.ListenShared(shared, server)
+		if err != nil {
+			log.Fatalf("Unable to listen on %s: %v\n", shared, err)
+		}
+		server.SetListener(portMux.AgentListener())
+		webServer.SetListener(portMux.WebListener())
+		go func() {
+			log.Printf("Shared listener stopped: %v\n", portMux.Serve())
+		}()
+		maddress, waddress = shared, shared
+	}
+
+	// Start the monitor and web servers
 	quit := make(chan int)
 	server.Start(quit)
 	log.Println("Monitor server running.", maddress)
-
-	// Create a new webserver and starts it
-	webServer := goscreenmonit.NewWebServer(waddress, certPath, keyPath, server)
 	go webServer.Start()
 	log.Println("Web server is running.", waddress)
 
@@ -0,0 +1,405 @@
+package goscreenmonit
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"runtime"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/micaiahwallace/goscreenmonit/uploadpb"
+)
+
+// Quality used by lossy codecs when none was negotiated
+const DefaultImageQuality = 75
+
+// Codec names in the order the server prefers them by default
+var DefaultCodecPreference = []string{"png", "jpeg", "raw.zstd"}
+
+// Largest image accepted from agents, a little over an 8K display
+const MaxImagePixels = 8192 * 4608
+
+// Largest decompressed image payload, the raw pixels of the largest image
+const maxDecodedImage = rawHeaderSize + MaxImagePixels*4
+
+// Returned for images over MaxImagePixels, checked before any pixels are decoded
+var ErrImageTooLarge = errors.New("image too large")
+
+// Encodes captured images to and from one wire format
+type Codec interface {
+
+	// Name advertised during registration
+	Name() string
+
+	// Format tagged on each encoded image
+	Format() uploadpb.ImageFormat
+
+	// Encode an image, quality is 1-100 and ignored by lossless codecs
+	Encode(img image.Image, quality int) ([]byte, error)
+
+	// Decode an encoded image
+	Decode(data []byte) (image.Image, error)
+}
+
+var (
+	codecMutex  sync.RWMutex
+	codecs      = make(map[uploadpb.ImageFormat]Codec)
+	codecByName = make(map[string]Codec)
+	codecOrder  = make([]string, 0)
+)
+
+func init() {
+	RegisterCodec(pngZlibCodec{})
+	RegisterCodec(pngCodec{})
+	RegisterCodec(jpegCodec{})
+	RegisterCodec(rawZstdCodec{})
+}
+
+// Add a codec to the registry, replacing any codec for the same format
+func RegisterCodec(codec Codec) {
+	codecMutex.Lock()
+	defer codecMutex.Unlock()
+	if _, ok := codecByName[codec.Name()]; !ok {
+		codecOrder = append(codecOrder, codec.Name())
+	}
+	codecs[codec.Format()] = codec
+	codecByName[codec.Name()] = codec
+}
+
+// Get the codec for an image format
+func GetCodec(format uploadpb.ImageFormat) (Codec, error) {
+	codecMutex.RLock()
+	defer codecMutex.RUnlock()
+	codec, ok := codecs[format]
+	if !ok {
+		return nil, fmt.Errorf("no codec for image format %v", format)
+	}
+	return codec, nil
+}
+
+// Get a codec by its advertised name
+func CodecByName(name string) (Codec, error) {
+	codecMutex.RLock()
+	defer codecMutex.RUnlock()
+	codec, ok := codecByName[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown codec %q", name)
+	}
+	return codec, nil
+}
+
+// Get the names of all registered codecs in registration order
+func CodecNames() []string {
+	codecMutex.RLock()
+	defer codecMutex.RUnlock()
+	return append([]string(nil), codecOrder...)
+}
+
+// Pick the first preferred codec the peer and this build both support, empty if none
+func NegotiateCodec(preference, peer []string) string {
+	for _, name := range preference {
+		if _, err := CodecByName(name); err == nil && HasCapability(peer, name) {
+			return name
+		}
+	}
+	return ""
+}
+
+// Encode an image with the codec for a format
+func EncodeImage(img image.Image, format uploadpb.ImageFormat, quality int) ([]byte, error) {
+	codec, err := GetCodec(format)
+	if err != nil {
+		return nil, err
+	}
+	return codec.Encode(img, quality)
+}
+
+// Decode an image with the codec for a format
+func DecodeImage(data []byte, format uploadpb.ImageFormat) (image.Image, error) {
+	codec, err := GetCodec(format)
+	if err != nil {
+		return nil, err
+	}
+	return codec.Decode(data)
+}
+
+// Convert an encoded image to a format browsers display, passing png and jpeg through untouched
+func ViewerImage(data []byte, format uploadpb.ImageFormat) ([]byte, uploadpb.ImageFormat, error) {
+	switch format {
+	case uploadpb.ImageFormat_PNG, uploadpb.ImageFormat_JPEG:
+		return data, format, nil
+
+	// Legacy uploads only need the zlib wrapper removed
+	case uploadpb.ImageFormat_PNG_ZLIB:
+		pngdata, err := zlibDecompress(data)
+		return pngdata, uploadpb.ImageFormat_PNG, err
+	}
+
+	img, err := DecodeImage(data, format)
+	if err != nil {
+		return nil, format, err
+	}
+	pngdata, err := EncodeImage(img, uploadpb.ImageFormat_PNG, 0)
+	return pngdata, uploadpb.ImageFormat_PNG, err
+}
+
+// Get the http content type of an image format viewers receive
+func ImageContentType(format uploadpb.ImageFormat) string {
+	if format == uploadpb.ImageFormat_JPEG {
+		return "image/jpeg"
+	}
+	return "image/png"
+}
+
+// Get the format of an upload's image, uploads from older agents carry no formats
+func imageFormat(formats []uploadpb.ImageFormat, index int) uploadpb.ImageFormat {
+	if index < len(formats) {
+		return formats[index]
+	}
+	return uploadpb.ImageFormat_PNG_ZLIB
+}
+
+// Png wrapped in zlib as sent by older agents
+type pngZlibCodec struct{}
+
+func (pngZlibCodec) Name() string                 { return "png.zlib" }
+func (pngZlibCodec) Format() uploadpb.ImageFormat { return uploadpb.ImageFormat_PNG_ZLIB }
+
+func (pngZlibCodec) Encode(img image.Image, quality int) ([]byte, error) {
+	pngbuff := new(bytes.Buffer)
+	if err := png.Encode(pngbuff, img); err != nil {
+		return nil, err
+	}
+	return zlibCompress(pngbuff.Bytes())
+}
+
+func (pngZlibCodec) Decode(data []byte) (image.Image, error) {
+	pngdata, err := zlibDecompress(data)
+	if err != nil {
+		return nil, err
+	}
+	return pngCodec{}.Decode(pngdata)
+}
+
+// Lossless png tuned for speed
+type pngCodec struct{}
+
+func (pngCodec) Name() string                 { return "png" }
+func (pngCodec) Format() uploadpb.ImageFormat { return uploadpb.ImageFormat_PNG }
+
+func (pngCodec) Encode(img image.Image, quality int) ([]byte, error) {
+	pngbuff := new(bytes.Buffer)
+	encoder := png.Encoder{CompressionLevel: png.BestSpeed}
+	if err := encoder.Encode(pngbuff, img); err != nil {
+		return nil, err
+	}
+	return pngbuff.Bytes(), nil
+}
+
+func (pngCodec) Decode(data []byte) (image.Image, error) {
+	if err := checkImageConfig(png.DecodeConfig(bytes.NewReader(data))); err != nil {
+		return nil, err
+	}
+	return png.Decode(bytes.NewReader(data))
+}
+
+// Lossy jpeg with a quality setting
+type jpegCodec struct{}
+
+func (jpegCodec) Name() string                 { return "jpeg" }
+func (jpegCodec) Format() uploadpb.ImageFormat { return uploadpb.ImageFormat_JPEG }
+
+func (jpegCodec) Encode(img image.Image, quality int) ([]byte, error) {
+	if quality <= 0 || quality > 100 {
+		quality = DefaultImageQuality
+	}
+	jpgbuff := new(bytes.Buffer)
+	if err := jpeg.Encode(jpgbuff, img, &jpeg.Options{Quality: quality}); err != nil {
+		return nil, err
+	}
+	return jpgbuff.Bytes(), nil
+}
+
+func (jpegCodec) Decode(data []byte) (image.Image, error) {
+	if err := checkImageConfig(jpeg.DecodeConfig(bytes.NewReader(data))); err != nil {
+		return nil, err
+	}
+	return jpeg.Decode(bytes.NewReader(data))
+}
+
+// Raw RGBA pixels behind a width and height header, compressed with zstd
+type rawZstdCodec struct{}
+
+func (rawZstdCodec) Name() string                 { return "raw.zstd" }
+func (rawZstdCodec) Format() uploadpb.ImageFormat { return uploadpb.ImageFormat_RAW_ZSTD }
+
+// Size of the width and height header
+const rawHeaderSize = 8
+
+var (
+	zstdOnce     sync.Once
+	zstdEncoder  *zstd.Encoder
+	zstdDecoders chan *zstd.Decoder
+	zstdErr      error
+)
+
+// Create the shared zstd encoder, safe for concurrent EncodeAll, and a pool of streaming
+// decoders so images are read header first instead of decompressed whole
+func zstdCoders() (*zstd.Encoder, chan *zstd.Decoder, error) {
+	zstdOnce.Do(func() {
+		zstdEncoder, zstdErr = zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest))
+		if zstdErr != nil {
+			return
+		}
+		zstdDecoders = make(chan *zstd.Decoder, runtime.NumCPU())
+		for i := 0; i < cap(zstdDecoders); i++ {
+			decoder, err := zstd.NewReader(nil, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxMemory(maxDecodedImage))
+			if err != nil {
+				zstdErr = err
+				return
+			}
+			zstdDecoders <- decoder
+		}
+	})
+	return zstdEncoder, zstdDecoders, zstdErr
+}
+
+func (rawZstdCodec) Encode(img image.Image, quality int) ([]byte, error) {
+	encoder, _, err := zstdCoders()
+	if err != nil {
+		return nil, err
+	}
+
+	// Copy rows into a tightly packed buffer after the header
+	rgba := toRGBA(img)
+	bounds := rgba.Bounds()
+	rowlen := bounds.Dx() * 4
+	raw := make([]byte, rawHeaderSize, rawHeaderSize+rowlen*bounds.Dy())
+	binary.BigEndian.PutUint32(raw[0:4], uint32(bounds.Dx()))
+	binary.BigEndian.PutUint32(raw[4:8], uint32(bounds.Dy()))
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		start := rgba.PixOffset(bounds.Min.X, y)
+		raw = append(raw, rgba.Pix[start:start+rowlen]...)
+	}
+
+	return encoder.EncodeAll(raw, nil), nil
+}
+
+func (rawZstdCodec) Decode(data []byte) (image.Image, error) {
+	_, decoders, err := zstdCoders()
+	if err != nil {
+		return nil, err
+	}
+	decoder := <-decoders
+	defer func() {
+		decoder.Reset(nil)
+		decoders <- decoder
+	}()
+	if err := decoder.Reset(bytes.NewReader(data)); err != nil {
+		return nil, err
+	}
+
+	// Check the dimensions before allocating for the pixels
+	var header [rawHeaderSize]byte
+	if _, err := io.ReadFull(decoder, header[:]); err != nil {
+		return nil, fmt.Errorf("raw image too short: %w", err)
+	}
+	width := int(binary.BigEndian.Uint32(header[0:4]))
+	height := int(binary.BigEndian.Uint32(header[4:8]))
+	if err := checkImageSize(width, height); err != nil {
+		return nil, err
+	}
+
+	// Read exactly the pixels the header promises
+	pix := make([]byte, width*height*4)
+	if _, err := io.ReadFull(decoder, pix); err != nil {
+		return nil, fmt.Errorf("raw image size mismatch for %dx%d: %w", width, height, err)
+	}
+	if n, _ := decoder.Read(header[:1]); n > 0 {
+		return nil, fmt.Errorf("raw image size mismatch for %dx%d: trailing data", width, height)
+	}
+	return &image.RGBA{
+		Pix:    pix,
+		Stride: width * 4,
+		Rect:   image.Rect(0, 0, width, height),
+	}, nil
+}
+
+// Check an encoded image's dimensions read by DecodeConfig
+func checkImageConfig(config image.Config, err error) error {
+	if err != nil {
+		return err
+	}
+	return checkImageSize(config.Width, config.Height)
+}
+
+// Check image dimensions against MaxImagePixels
+func checkImageSize(width, height int) error {
+	if width <= 0 || height <= 0 {
+		return fmt.Errorf("invalid image size %dx%d", width, height)
+	}
+	if int64(width)*int64(height) > MaxImagePixels {
+		return fmt.Errorf("%w: %dx%d is over %d pixels", ErrImageTooLarge, width, height, MaxImagePixels)
+	}
+	return nil
+}
+
+// Get an image as RGBA, converting only when needed
+func toRGBA(img image.Image) *image.RGBA {
+	if rgba, ok := img.(*image.RGBA); ok {
+		return rgba
+	}
+	rgba := image.NewRGBA(img.Bounds())
+	draw.Draw(rgba, rgba.Bounds(), img, img.Bounds().Min, draw.Src)
+	return rgba
+}
+
+// Compress data with zlib
+func zlibCompress(data []byte) ([]byte, error) {
+
+	// Create buffer and encode image
+	var byt bytes.Buffer
+	w := zlib.NewWriter(&byt)
+
+	// Write data to encoder
+	if _, werr := w.Write(data); werr != nil {
+		return nil, werr
+	}
+
+	// Close encoder for writing
+	if cerr := w.Close(); cerr != nil {
+		return nil, cerr
+	}
+
+	return byt.Bytes(), nil
+}
+
+// Decompress data with zlib
+func zlibDecompress(data []byte) ([]byte, error) {
+
+	// Create zlib processor
+	r, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	// Decode all bytes into new []byte, refusing more than the largest image could need
+	out, err := ioutil.ReadAll(io.LimitReader(r, maxDecodedImage+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(out) > maxDecodedImage {
+		return nil, fmt.Errorf("%w: decompresses to over %d bytes", ErrImageTooLarge, maxDecodedImage)
+	}
+	return out, nil
+}
@@ -0,0 +1,180 @@
+package goscreenmonit
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/micaiahwallace/goscreenmonit/uploadpb"
+)
+
+// Default time to wait for an agent to acknowledge a command
+const DefaultCommandTimeout = 10 * time.Second
+
+var (
+	ErrAgentNotConnected   = errors.New("agent not connected")
+	ErrCommandTimeout      = errors.New("agent did not acknowledge the command in time")
+	ErrInvalidCommand      = errors.New("invalid command")
+	ErrCommandsUnsupported = errors.New("agent does not support commands")
+)
+
+// A command waiting for the agent's acknowledgement
+type pendingCommand struct {
+	cmd  *uploadpb.Command
+	done chan *uploadpb.CommandAck
+}
+
+// Send a command to a connected agent and wait for its acknowledgement
+func (server *Server) SendCommand(agentID string, cmd *uploadpb.Command, timeout time.Duration) (*uploadpb.CommandAck, error) {
+	client := server.registry.Get(agentID)
+	if client == nil {
+		return nil, ErrAgentNotConnected
+	}
+	if !HasCapability(client.Capabilities, CapCommands) {
+		return nil, ErrCommandsUnsupported
+	}
+	if err := validateCommand(client, cmd); err != nil {
+		return nil, err
+	}
+
+	// Register for the acknowledgement before sending so a fast reply isn't missed
+	cmd.Id = atomic.AddUint64(&server.commandSeq, 1)
+	pending := client.expectAck(cmd)
+	defer client.cancelAck(cmd.GetId())
+
+	msg, err := CreateCommand(cmd)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Sending %v command %d to %s\n", cmd.GetAction(), cmd.GetId(), client.ID)
+	if err := client.Conn.WriteFrame(msg); err != nil {
+		return nil, err
+	}
+
+	select {
+	case ack := <-pending.done:
+		return ack, nil
+	case <-time.After(timeout):
+		return nil, ErrCommandTimeout
+	}
+}
+
+// Check a command's arguments against what the agent supports
+func validateCommand(client *RegisteredClient, cmd *uploadpb.Command) error {
+	switch cmd.GetAction() {
+	case uploadpb.Command_SET_FPS:
+		if cmd.GetFps() == 0 {
+			return fmt.Errorf("%w: fps must be greater than 0", ErrInvalidCommand)
+		}
+	case uploadpb.Command_SET_CODEC:
+		if _, err := CodecByName(cmd.GetCodec()); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidCommand, err)
+		}
+		if !HasCapability(client.Register.GetCodecs(), cmd.GetCodec()) {
+			return fmt.Errorf("%w: agent does not support codec %s", ErrInvalidCommand, cmd.GetCodec())
+		}
+		if cmd.GetQuality() > 100 {
+			return fmt.Errorf("%w: quality must be 1-100", ErrInvalidCommand)
+		}
+	case uploadpb.Command_PAUSE, uploadpb.Command_RESUME, uploadpb.Command_SNAPSHOT, uploadpb.Command_RECONNECT:
+	default:
+		return fmt.Errorf("%w: unknown action %v", ErrInvalidCommand, cmd.GetAction())
+	}
+	return nil
+}
+
+// Deliver an agent's acknowledgement to the waiting sender
+func (server *Server) handleCommandAck(ack *uploadpb.CommandAck, client *RegisteredClient) {
+	if client == nil {
+		return
+	}
+	cmd := client.resolveAck(ack)
+	if cmd == nil {
+		log.Printf("Unexpected acknowledgement of command %d from %s\n", ack.GetId(), client.ID)
+		return
+	}
+	if !ack.GetOk() {
+		log.Printf("Agent %s failed %v command %d: %s\n", client.ID, cmd.GetAction(), ack.GetId(), ack.GetError())
+		return
+	}
+
+	// Keep the decoder in step with a codec change
+	if cmd.GetAction() == uploadpb.Command_SET_CODEC {
+		quality := int(cmd.GetQuality())
+		if quality == 0 {
+			quality = client.GetQuality()
+		}
+		client.setCodec(cmd.GetCodec(), quality)
+		client.decoder.Quality = quality
+	}
+}
+
+// Start waiting for a command's acknowledgement
+func (client *RegisteredClient) expectAck(cmd *uploadpb.Command) *pendingCommand {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	if client.commands == nil {
+		client.commands = make(map[uint64]*pendingCommand)
+	}
+	pending := &pendingCommand{cmd: cmd, done: make(chan *uploadpb.CommandAck, 1)}
+	client.commands[cmd.GetId()] = pending
+	return pending
+}
+
+// Stop waiting for a command's acknowledgement
+func (client *RegisteredClient) cancelAck(id uint64) {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	delete(client.commands, id)
+}
+
+// Hand an acknowledgement to its waiting command, returning the command or nil if nobody is waiting
+func (client *RegisteredClient) resolveAck(ack *uploadpb.CommandAck) *uploadpb.Command {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	pending, ok := client.commands[ack.GetId()]
+	if !ok {
+		return nil
+	}
+	delete(client.commands, ack.GetId())
+	pending.done <- ack
+	return pending.cmd
+}
+
+// Get the codec the agent is uploading with
+func (client *RegisteredClient) GetCodec() string {
+	client.mutex.RLock()
+	defer client.mutex.RUnlock()
+	return client.Codec
+}
+
+// Get the quality lossy codecs use for the agent
+func (client *RegisteredClient) GetQuality() int {
+	client.mutex.RLock()
+	defer client.mutex.RUnlock()
+	return client.Quality
+}
+
+// Update the agent's codec after it acknowledged a change
+func (client *RegisteredClient) setCodec(codec string, quality int) {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	client.Codec = codec
+	client.Quality = quality
+}
+
+// Get the agent's latest full resolution snapshot, nil if none was requested
+func (client *RegisteredClient) GetSnapshot() *uploadpb.ImageUpload {
+	client.mutex.RLock()
+	defer client.mutex.RUnlock()
+	return client.snapshot
+}
+
+// Keep a snapshot upload
+func (client *RegisteredClient) setSnapshot(upload *uploadpb.ImageUpload) {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	client.snapshot = upload
+}
@@ -0,0 +1,290 @@
+package goscreenmonit
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/draw"
+	"sync"
+
+	"github.com/micaiahwallace/goscreenmonit/uploadpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Side length of the square tiles compared between frames
+const DefaultTileSize = 64
+
+// Frames sent between forced keyframes
+const DefaultKeyframeInterval = 60
+
+// Returned when a delta can't be applied because the base frame is missing
+var ErrKeyframeNeeded = errors.New("keyframe needed")
+
+// Agent side encoder sending only the tiles that changed since the previous capture
+type DeltaEncoder struct {
+	TileSize         int
+	KeyframeInterval int
+
+	mutex    sync.Mutex
+	codec    Codec
+	quality  int
+	prev     []*image.RGBA
+	sequence uint64
+	sinceKey int
+	forceKey bool
+}
+
+// Create a delta encoder with default tile size and keyframe interval
+func NewDeltaEncoder() *DeltaEncoder {
+	return &DeltaEncoder{
+		TileSize:         DefaultTileSize,
+		KeyframeInterval: DefaultKeyframeInterval,
+		codec:            pngZlibCodec{},
+		forceKey:         true,
+	}
+}
+
+// Encode images and tiles with a codec, starting over from a keyframe
+func (enc *DeltaEncoder) SetCodec(codec Codec, quality int) {
+	enc.mutex.Lock()
+	enc.codec = codec
+	enc.quality = quality
+	enc.forceKey = true
+	enc.mutex.Unlock()
+}
+
+// Change the quality used by lossy codecs without forcing a keyframe
+func (enc *DeltaEncoder) SetQuality(quality int) {
+	enc.mutex.Lock()
+	enc.quality = quality
+	enc.mutex.Unlock()
+}
+
+// Make the next encoded frame a keyframe
+func (enc *DeltaEncoder) RequestKeyframe() {
+	enc.mutex.Lock()
+	enc.forceKey = true
+	enc.mutex.Unlock()
+}
+
+// Encode a capture of every display as a keyframe or delta upload, a nil frame is a failed capture
+func (enc *DeltaEncoder) Encode(frames []*image.RGBA) (*uploadpb.ImageUpload, error) {
+	enc.mutex.Lock()
+	defer enc.mutex.Unlock()
+
+	enc.sequence++
+	upload := &uploadpb.ImageUpload{
+		Timestamp: timestamppb.Now(),
+		Sequence:  enc.sequence,
+	}
+
+	// Send a keyframe when asked, periodically, or when the display layout changed
+	if enc.forceKey || enc.sinceKey >= enc.KeyframeInterval || !sameLayout(enc.prev, frames) {
+		upload.FrameType = uploadpb.ImageUpload_KEYFRAME
+		for _, img := range frames {
+			if img == nil {
+				upload.Images = append(upload.Images, nil)
+				upload.Formats = append(upload.Formats, enc.codec.Format())
+				continue
+			}
+			encimg, err := enc.codec.Encode(img, enc.quality)
+			if err != nil {
+				return nil, err
+			}
+			upload.Images = append(upload.Images, encimg)
+			upload.Formats = append(upload.Formats, enc.codec.Format())
+		}
+		enc.forceKey = false
+		enc.sinceKey = 0
+		enc.prev = frames
+		return upload, nil
+	}
+
+	// Otherwise send the changed tiles of each display
+	upload.FrameType = uploadpb.ImageUpload_DELTA
+	for i, img := range frames {
+		if img == nil {
+			upload.Deltas = append(upload.Deltas, &uploadpb.DisplayDelta{Display: uint32(i)})
+			continue
+		}
+		delta := &uploadpb.DisplayDelta{
+			Display: uint32(i),
+			Width:   uint32(img.Bounds().Dx()),
+			Height:  uint32(img.Bounds().Dy()),
+		}
+		for _, rect := range changedTiles(enc.prev[i], img, enc.TileSize) {
+			data, err := enc.codec.Encode(img.SubImage(rect), enc.quality)
+			if err != nil {
+				return nil, err
+			}
+			delta.Tiles = append(delta.Tiles, &uploadpb.Tile{
+				X:      uint32(rect.Min.X - img.Bounds().Min.X),
+				Y:      uint32(rect.Min.Y - img.Bounds().Min.Y),
+				Width:  uint32(rect.Dx()),
+				Height: uint32(rect.Dy()),
+				Data:   data,
+				Format: enc.codec.Format(),
+			})
+		}
+		upload.Deltas = append(upload.Deltas, delta)
+	}
+	enc.sinceKey++
+	enc.prev = frames
+	return upload, nil
+}
+
+// Server side decoder reconstructing full frames from keyframes and deltas
+type DeltaDecoder struct {
+
+	// Quality used when re-encoding reconstructed frames with a lossy codec
+	Quality int
+
+	sequence uint64
+	frames   []*image.RGBA
+	images   [][]byte
+	formats  []uploadpb.ImageFormat
+
+	// Set once a keyframe was requested, until one arrives
+	keyframeRequested bool
+}
+
+// Check if the agent should be asked for a keyframe, only once until a keyframe arrives
+func (dec *DeltaDecoder) ShouldRequestKeyframe() bool {
+	if dec.keyframeRequested {
+		return false
+	}
+	dec.keyframeRequested = true
+	return true
+}
+
+// Apply an upload, returning the reconstructed full frames encoded in formats viewers display
+func (dec *DeltaDecoder) Apply(upload *uploadpb.ImageUpload) ([][]byte, []uploadpb.ImageFormat, error) {
+	switch upload.GetFrameType() {
+
+	// Keyframes replace the reference frames
+	case uploadpb.ImageUpload_KEYFRAME:
+		dec.keyframeRequested = false
+		count := len(upload.GetImages())
+		frames := make([]*image.RGBA, count)
+		images := make([][]byte, count)
+		formats := make([]uploadpb.ImageFormat, count)
+		for i, encimg := range upload.GetImages() {
+			format := imageFormat(upload.GetFormats(), i)
+			if len(encimg) == 0 {
+				formats[i] = format
+				continue
+			}
+			img, err := DecodeImage(encimg, format)
+			if err != nil {
+				dec.frames = nil
+				return nil, nil, err
+			}
+			frames[i] = toRGBA(img)
+			images[i], formats[i], err = ViewerImage(encimg, format)
+			if err != nil {
+				dec.frames = nil
+				return nil, nil, err
+			}
+		}
+		dec.frames = frames
+		dec.images = images
+		dec.formats = formats
+		dec.sequence = upload.GetSequence()
+		return images, formats, nil
+
+	// Deltas must directly follow the reference frame
+	case uploadpb.ImageUpload_DELTA:
+		if dec.frames == nil || upload.GetSequence() != dec.sequence+1 {
+			dec.frames = nil
+			return nil, nil, ErrKeyframeNeeded
+		}
+		images := make([][]byte, len(dec.frames))
+		changed := make([]bool, len(dec.frames))
+		for _, delta := range upload.GetDeltas() {
+			d := int(delta.GetDisplay())
+			if d >= len(dec.frames) || !deltaFits(dec.frames[d], delta) {
+				dec.frames = nil
+				return nil, nil, ErrKeyframeNeeded
+			}
+			for _, tile := range delta.GetTiles() {
+				timg, err := DecodeImage(tile.GetData(), tile.GetFormat())
+				if err != nil {
+					dec.frames = nil
+					return nil, nil, err
+				}
+				at := image.Rect(int(tile.GetX()), int(tile.GetY()), int(tile.GetX()+tile.GetWidth()), int(tile.GetY()+tile.GetHeight()))
+				draw.Draw(dec.frames[d], at, timg, timg.Bounds().Min, draw.Src)
+				changed[d] = true
+			}
+		}
+		dec.sequence = upload.GetSequence()
+
+		// Re-encode the reconstructed displays that changed
+		for i, img := range dec.frames {
+			if img == nil || !changed[i] {
+				images[i] = dec.images[i]
+				continue
+			}
+			encimg, err := EncodeImage(img, dec.formats[i], dec.Quality)
+			if err != nil {
+				return nil, nil, err
+			}
+			images[i] = encimg
+		}
+		dec.images = images
+		return images, dec.formats, nil
+	}
+
+	return upload.GetImages(), upload.GetFormats(), nil
+}
+
+// Check if two captures have the same display count, sizes and failed displays
+func sameLayout(prev, next []*image.RGBA) bool {
+	if len(prev) != len(next) {
+		return false
+	}
+	for i := range prev {
+		if (prev[i] == nil) != (next[i] == nil) {
+			return false
+		}
+		if prev[i] != nil && prev[i].Bounds() != next[i].Bounds() {
+			return false
+		}
+	}
+	return true
+}
+
+// Check if a display delta matches the size of its reference frame, failed displays have no size
+func deltaFits(frame *image.RGBA, delta *uploadpb.DisplayDelta) bool {
+	if frame == nil {
+		return delta.GetWidth() == 0 && delta.GetHeight() == 0 && len(delta.GetTiles()) == 0
+	}
+	return uint32(frame.Bounds().Dx()) == delta.GetWidth() && uint32(frame.Bounds().Dy()) == delta.GetHeight()
+}
+
+// Find the tiles whose pixels differ between two same sized images
+func changedTiles(prev, next *image.RGBA, size int) []image.Rectangle {
+	bounds := next.Bounds()
+	rects := make([]image.Rectangle, 0)
+	for ty := bounds.Min.Y; ty < bounds.Max.Y; ty += size {
+		for tx := bounds.Min.X; tx < bounds.Max.X; tx += size {
+			rect := image.Rect(tx, ty, tx+size, ty+size).Intersect(bounds)
+			if tileChanged(prev, next, rect) {
+				rects = append(rects, rect)
+			}
+		}
+	}
+	return rects
+}
+
+// Compare a tile row by row
+func tileChanged(prev, next *image.RGBA, rect image.Rectangle) bool {
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		a := prev.Pix[prev.PixOffset(rect.Min.X, y):prev.PixOffset(rect.Max.X, y)]
+		b := next.Pix[next.PixOffset(rect.Min.X, y):next.PixOffset(rect.Max.X, y)]
+		if !bytes.Equal(a, b) {
+			return true
+		}
+	}
+	return false
+}
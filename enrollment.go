@@ -0,0 +1,323 @@
+package goscreenmonit
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrEnrollmentRequired = errors.New("enrollment token or credential required")
+	ErrInvalidToken       = errors.New("invalid enrollment token")
+	ErrInvalidCredential  = errors.New("invalid agent credential")
+	ErrRevoked            = errors.New("agent credential revoked")
+	ErrNotFound           = errors.New("not found")
+)
+
+// Credential last seen times are only bookkeeping, so they are written at most this often
+const lastSeenSaveInterval = time.Minute
+
+// An admin created token agents present on first connect
+type EnrollmentToken struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	TokenHash string    `json:"tokenHash"`
+	MaxUses   int       `json:"maxUses"`
+	Uses      int       `json:"uses"`
+	Revoked   bool      `json:"revoked"`
+	CreatedAt time.Time `json:"createdAt"`
+	ExpiresAt time.Time `json:"expiresAt,omitempty"`
+}
+
+// A credential issued to an agent after enrollment
+type AgentCredential struct {
+	ID         string    `json:"id"`
+	SecretHash string    `json:"secretHash"`
+	TokenID    string    `json:"tokenId"`
+	Host       string    `json:"host"`
+	User       string    `json:"user"`
+	Revoked    bool      `json:"revoked"`
+	CreatedAt  time.Time `json:"createdAt"`
+	LastSeen   time.Time `json:"lastSeen"`
+}
+
+// Persistent store of enrollment tokens and issued agent credentials
+type EnrollmentStore struct {
+	path        string
+	mutex       sync.Mutex
+	savedAt     time.Time
+	Tokens      map[string]*EnrollmentToken `json:"tokens"`
+	Credentials map[string]*AgentCredential `json:"credentials"`
+}
+
+// Open an enrollment store backed by a json file, creating it if missing
+func OpenEnrollmentStore(path string) (*EnrollmentStore, error) {
+	store := &EnrollmentStore{
+		path:        path,
+		Tokens:      make(map[string]*EnrollmentToken),
+		Credentials: make(map[string]*AgentCredential),
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return store, store.save()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, store); err != nil {
+		return nil, err
+	}
+
+	return store, nil
+}
+
+// Create a new enrollment token, returning the record and the plaintext token
+func (store *EnrollmentStore) CreateToken(name string, maxUses int, ttl time.Duration) (*EnrollmentToken, string, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	secret, err := randomHex(32)
+	if err != nil {
+		return nil, "", err
+	}
+	id, err := randomHex(8)
+	if err != nil {
+		return nil, "", err
+	}
+
+	token := &EnrollmentToken{
+		ID:        id,
+		Name:      name,
+		TokenHash: hashSecret(secret),
+		MaxUses:   maxUses,
+		CreatedAt: time.Now(),
+	}
+	if ttl > 0 {
+		token.ExpiresAt = token.CreatedAt.Add(ttl)
+	}
+	store.Tokens[id] = token
+	if err := store.save(); err != nil {
+		delete(store.Tokens, id)
+		return nil, "", err
+	}
+
+	// Tokens are presented as id.secret so lookups don't need a scan
+	return token, id + "." + secret, nil
+}
+
+// List all enrollment tokens
+func (store *EnrollmentStore) ListTokens() []EnrollmentToken {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	tokens := make([]EnrollmentToken, 0, len(store.Tokens))
+	for _, t := range store.Tokens {
+		tokens = append(tokens, *t)
+	}
+	return tokens
+}
+
+// Revoke a token so it can no longer enroll agents
+func (store *EnrollmentStore) RevokeToken(id string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	token, ok := store.Tokens[id]
+	if !ok {
+		return ErrNotFound
+	}
+	revoked := token.Revoked
+	token.Revoked = true
+	if err := store.save(); err != nil {
+		token.Revoked = revoked
+		return err
+	}
+	return nil
+}
+
+// List all issued agent credentials
+func (store *EnrollmentStore) ListCredentials() []AgentCredential {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	creds := make([]AgentCredential, 0, len(store.Credentials))
+	for _, c := range store.Credentials {
+		creds = append(creds, *c)
+	}
+	return creds
+}
+
+// Revoke an agent credential so the agent is refused on connect
+func (store *EnrollmentStore) RevokeCredential(id string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	cred, ok := store.Credentials[id]
+	if !ok {
+		return ErrNotFound
+	}
+	revoked := cred.Revoked
+	cred.Revoked = true
+	if err := store.save(); err != nil {
+		cred.Revoked = revoked
+		return err
+	}
+	return nil
+}
+
+// Authenticate a registration, enrolling it when it carries a token.
+// Returns the credential id and a newly issued secret (empty when reusing an existing credential).
+func (store *EnrollmentStore) Authenticate(req *RegistrationAuth) (string, string, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	// Prefer an existing credential
+	if req.CredentialID != "" {
+		cred, ok := store.Credentials[req.CredentialID]
+		if ok {
+			if cred.Revoked {
+				return cred.ID, "", ErrRevoked
+			}
+			if !secretMatches(cred.SecretHash, req.CredentialSecret) {
+				return "", "", ErrInvalidCredential
+			}
+			cred.LastSeen = time.Now()
+			store.saveLastSeen()
+			return cred.ID, "", nil
+		}
+
+		// Unknown credentials fall through to re-enrollment when a token is available
+		if req.Token == "" {
+			return "", "", ErrInvalidCredential
+		}
+	}
+
+	if req.Token == "" {
+		return "", "", ErrEnrollmentRequired
+	}
+
+	// Validate the enrollment token
+	token, err := store.lookupToken(req.Token)
+	if err != nil {
+		return "", "", err
+	}
+
+	// Issue a new credential
+	id, err := randomHex(8)
+	if err != nil {
+		return "", "", err
+	}
+	secret, err := randomHex(32)
+	if err != nil {
+		return "", "", err
+	}
+	now := time.Now()
+	store.Credentials[id] = &AgentCredential{
+		ID:         id,
+		SecretHash: hashSecret(secret),
+		TokenID:    token.ID,
+		Host:       req.Host,
+		User:       req.User,
+		CreatedAt:  now,
+		LastSeen:   now,
+	}
+	token.Uses++
+	if err := store.save(); err != nil {
+		delete(store.Credentials, id)
+		token.Uses--
+		return "", "", err
+	}
+
+	return id, secret, nil
+}
+
+// Find a usable token for a presented id.secret token string
+func (store *EnrollmentStore) lookupToken(presented string) (*EnrollmentToken, error) {
+	parts := strings.SplitN(presented, ".", 2)
+	if len(parts) != 2 {
+		return nil, ErrInvalidToken
+	}
+
+	token, ok := store.Tokens[parts[0]]
+	if !ok || !secretMatches(token.TokenHash, parts[1]) {
+		return nil, ErrInvalidToken
+	}
+	if token.Revoked {
+		return nil, ErrInvalidToken
+	}
+	if token.MaxUses > 0 && token.Uses >= token.MaxUses {
+		return nil, ErrInvalidToken
+	}
+	if !token.ExpiresAt.IsZero() && time.Now().After(token.ExpiresAt) {
+		return nil, ErrInvalidToken
+	}
+
+	return token, nil
+}
+
+// Write the store to disk atomically
+func (store *EnrollmentStore) save() error {
+	data, err := json.MarshalIndent(store, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := store.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, store.path); err != nil {
+		return err
+	}
+	store.savedAt = time.Now()
+	return nil
+}
+
+// Write updated last seen times unless the store was written recently, any other save
+// picks them up too. Failures only lose bookkeeping so they don't fail authentication.
+func (store *EnrollmentStore) saveLastSeen() {
+	if time.Since(store.savedAt) < lastSeenSaveInterval {
+		return
+	}
+	if err := store.save(); err != nil {
+		log.Printf("Unable to save credential last seen times: %v\n", err)
+	}
+}
+
+// Authentication material presented in a registration
+type RegistrationAuth struct {
+	Host             string
+	User             string
+	Token            string
+	CredentialID     string
+	CredentialSecret string
+}
+
+// Hash a secret for storage
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// Compare a presented secret against a stored hash in constant time
+func secretMatches(hash, secret string) bool {
+	return subtle.ConstantTimeCompare([]byte(hash), []byte(hashSecret(secret))) == 1
+}
+
+// Generate a random hex string from n bytes
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,124 @@
+package goscreenmonit
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/micaiahwallace/goscreenmonit/uploadpb"
+)
+
+// Exit code of an agent too old for the server, so a supervisor can upgrade it
+const ExitUpgradeRequired = 3
+
+// Exit code of an agent the server will never accept as configured, such as a revoked one
+const ExitRefused = 4
+
+// How long agents are asked to wait after a server side failure
+const internalRetryAfter = 30 * time.Second
+
+// Send an error to a connection and close it. Agents without the errors capability get the
+// quit or rejection they understand instead.
+func (server *Server) errorConn(conn *FramedConn, capabilities []string, code uploadpb.Error_Code, message string, retryAfter time.Duration) {
+	if !HasCapability(capabilities, CapErrors) {
+		if code == uploadpb.Error_ALREADY_REGISTERED || code == uploadpb.Error_REVOKED {
+			server.quitConn(conn)
+		} else {
+			server.rejectConn(conn, message)
+		}
+		return
+	}
+	server.sendError(conn, code, message, retryAfter)
+}
+
+// Send an error response and close the connection
+func (server *Server) sendError(conn *FramedConn, code uploadpb.Error_Code, message string, retryAfter time.Duration) {
+	errres, err := CreateError(code, message, retryAfter)
+	if err != nil {
+		log.Printf("Unable to create error response. %v\n", err)
+	} else {
+		conn.WriteFrame(errres)
+	}
+	conn.Close()
+}
+
+// Get the error code for an enrollment failure
+func enrollmentErrorCode(err error) uploadpb.Error_Code {
+	switch {
+	case errors.Is(err, ErrRevoked):
+		return uploadpb.Error_REVOKED
+	case errors.Is(err, ErrInvalidCredential):
+		return uploadpb.Error_INVALID_CREDENTIAL
+	case errors.Is(err, ErrInvalidToken):
+		return uploadpb.Error_INVALID_TOKEN
+	case errors.Is(err, ErrEnrollmentRequired):
+		return uploadpb.Error_ENROLLMENT_REQUIRED
+	default:
+		return uploadpb.Error_INTERNAL
+	}
+}
+
+// React to a server error: retry later, re-enroll, stop for an upgrade or stop for good
+func (session *Session) handleError(conn *FramedConn, serr *uploadpb.Error) {
+	log.Printf("Server error %v: %s\n", serr.GetCode(), serr.GetMessage())
+	session.setStatus(func(status *SessionStatus) {
+		status.LastError = describeServerError(serr)
+	})
+
+	switch serr.GetCode() {
+
+	// Nothing this agent can fix on its own
+	case uploadpb.Error_REVOKED, uploadpb.Error_INVALID_TOKEN:
+		log.Println("Agent is not allowed to connect, quitting now.")
+		session.finish(ExitRefused)
+
+	// The server no longer speaks this agent's protocol
+	case uploadpb.Error_UNSUPPORTED_VERSION:
+		log.Printf("Agent %s (protocol v%d) must be upgraded, quitting now.\n", AgentVersion, ProtocolVersion)
+		session.finish(ExitUpgradeRequired)
+
+	// The credential is unusable, enroll again with the token if there is one
+	case uploadpb.Error_INVALID_CREDENTIAL, uploadpb.Error_ENROLLMENT_REQUIRED:
+		if session.enrollToken == "" {
+			log.Println("No enrollment token to re-enroll with, quitting now.")
+			session.finish(ExitRefused)
+			return
+		}
+		log.Println("Discarding agent credential and re-enrolling.")
+		session.credential = nil
+		if session.credPath != "" {
+			if err := os.Remove(session.credPath); err != nil && !os.IsNotExist(err) {
+				log.Printf("Unable to remove agent credential: %v\n", err)
+			}
+		}
+		session.retryLater(conn, serr)
+
+	// Anything else is temporary
+	default:
+		session.retryLater(conn, serr)
+	}
+}
+
+// Close a connection, reconnecting no sooner than the error's retry hint
+func (session *Session) retryLater(conn *FramedConn, serr *uploadpb.Error) {
+	session.mutex.Lock()
+	session.serverError = serr
+	session.mutex.Unlock()
+	conn.Close()
+}
+
+// Take the error that closed the last connection, nil if it closed without one
+func (session *Session) takeServerError() *uploadpb.Error {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+	serr := session.serverError
+	session.serverError = nil
+	return serr
+}
+
+// Describe a server error for the status file
+func describeServerError(serr *uploadpb.Error) string {
+	return fmt.Sprintf("%s: %s", serr.GetCode(), serr.GetMessage())
+}
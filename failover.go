@@ -0,0 +1,233 @@
+package goscreenmonit
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// Retry bounds after losing or failing to reach every server
+const (
+	reconnectMin = 2 * time.Second
+	reconnectMax = 2 * time.Minute
+)
+
+// A connection must stay up this long before the reconnect backoff starts over
+const stableConnection = 30 * time.Second
+
+// How often a failed over agent first checks whether a more preferred server is back
+const DefaultFailbackInterval = 30 * time.Second
+
+// Checks back off while preferred servers stay down, up to this multiple of the interval
+const failbackBackoff = 8
+
+// Connection state of a session, written to the status file on every change
+type SessionStatus struct {
+	State          SessionState `json:"state"`
+	Server         string       `json:"server"`
+	Servers        []string     `json:"servers"`
+	FailedOver     bool         `json:"failedOver"`
+	Attempt        int          `json:"attempt"`
+	RetryAt        time.Time    `json:"retryAt"`
+	ConnectedSince time.Time    `json:"connectedSince"`
+	LastError      string       `json:"lastError"`
+	UpdatedAt      time.Time    `json:"updatedAt"`
+}
+
+// Connect to the first reachable server in priority order, failing over to later ones and
+// returning to an earlier one once it is reachable again
+func (session *Session) SetServers(addresses []string) {
+	session.servers = addresses
+}
+
+// Check for a more preferred server every interval while failed over, backing off while they
+// stay down, zero stays on the fallback server until the connection drops
+func (session *Session) SetFailbackInterval(interval time.Duration) {
+	session.failbackInterval = interval
+}
+
+// Keep the connection state in a file, read by the agent status command
+func (session *Session) SetStatusFile(path string) {
+	session.statusPath = path
+}
+
+// Get the current connection state
+func (session *Session) Status() SessionStatus {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+	return session.status
+}
+
+// Load a session status written by a running agent
+func LoadSessionStatus(path string) (*SessionStatus, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	status := &SessionStatus{}
+	if err := json.Unmarshal(data, status); err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+// Update the connection state and write it to the status file
+func (session *Session) setStatus(update func(*SessionStatus)) {
+
+	// Keep status file writes in the same order as the updates
+	session.statusMutex.Lock()
+	defer session.statusMutex.Unlock()
+
+	session.mutex.Lock()
+	update(&session.status)
+	session.status.Servers = session.servers
+	session.status.UpdatedAt = time.Now()
+	status := session.status
+	session.mutex.Unlock()
+
+	if session.statusPath == "" {
+		return
+	}
+	if err := writeStatusFile(session.statusPath, status); err != nil {
+		log.Printf("Unable to write status file: %v\n", err)
+	}
+}
+
+// Write a status file atomically so readers never see a partial write
+func writeStatusFile(path string, status SessionStatus) error {
+	data, err := json.MarshalIndent(status, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Dial each server in priority order, returning the first connection and its index
+func (session *Session) dialServers(ctx context.Context) (net.Conn, int, error) {
+	var lasterr error
+	for i, address := range session.servers {
+		session.setStatus(func(status *SessionStatus) {
+			status.Server = address
+		})
+		conn, err := session.dial(ctx, address)
+		if err == nil {
+			return conn, i, nil
+		}
+
+		// Remember verification failures over plain connection errors so the harder backoff applies
+		if isVerificationError(err) {
+			log.Printf("Server verification failed for %s: %v\n", address, err)
+		} else {
+			log.Printf("Unable to connect to server %s: %v\n", address, err)
+		}
+		if lasterr == nil || !isVerificationError(lasterr) {
+			lasterr = err
+		}
+	}
+	return nil, -1, lasterr
+}
+
+// Dial a single server, giving up when the context is cancelled or the handshake stalls
+func (session *Session) dial(ctx context.Context, address string) (net.Conn, error) {
+	if strings.Contains(address, "://") {
+		return session.dialWebSocket(ctx, address)
+	}
+
+	dialer := &net.Dialer{Timeout: session.heartbeatTimeout}
+	raw, err := dialer.DialContext(ctx, "tcp4", address)
+	if err != nil {
+		return nil, err
+	}
+
+	// Verify the host name like tls.Dial does
+	tlsconf := session.tlsConfig()
+	if host, _, herr := net.SplitHostPort(address); herr == nil && tlsconf.ServerName == "" {
+		tlsconf.ServerName = host
+	}
+
+	// Identify as an agent to servers sharing a port with the web server
+	tlsconf.NextProtos = []string{AgentProtocol}
+	conn := tls.Client(raw, tlsconf)
+	conn.SetDeadline(time.Now().Add(session.heartbeatTimeout))
+	if err := conn.Handshake(); err != nil {
+		raw.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// While connected to a fallback server, periodically check the servers before it and drop the
+// connection once one is reachable so the connect loop returns to it
+func (session *Session) watchFailback(ctx context.Context, conn *FramedConn, index int) {
+	backoff := NewBackoff(session.failbackInterval, session.failbackInterval*failbackBackoff)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff.Next()):
+		}
+
+		for _, address := range session.servers[:index] {
+			if err := session.probe(ctx, address); err != nil {
+				continue
+			}
+			log.Printf("Server %s is reachable again, failing back.\n", address)
+			session.mutex.Lock()
+			session.failingBack = true
+			session.mutex.Unlock()
+			conn.Close()
+			return
+		}
+	}
+}
+
+// Check if a server is reachable, with a plain tcp connect before paying for a verified handshake
+func (session *Session) probe(ctx context.Context, address string) error {
+	if network, tcpAddress := probeAddress(address); tcpAddress != "" {
+		dialer := &net.Dialer{Timeout: session.heartbeatTimeout}
+		raw, err := dialer.DialContext(ctx, network, tcpAddress)
+		if err != nil {
+			return err
+		}
+		raw.Close()
+	}
+
+	// Only fail back to a server that passes verification
+	conn, err := session.dial(ctx, address)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// Get the network and address a server listens on, empty when it is only reachable through a proxy
+func probeAddress(address string) (string, string) {
+	if !strings.Contains(address, "://") {
+		return "tcp4", address
+	}
+	u, err := url.Parse(address)
+	if err != nil {
+		return "", ""
+	}
+	if proxy, err := http.ProxyFromEnvironment(&http.Request{URL: &url.URL{Scheme: "https", Host: u.Host}}); err != nil || proxy != nil {
+		return "", ""
+	}
+	port := u.Port()
+	if port == "" {
+		port = "443"
+	}
+	return "tcp", net.JoinHostPort(u.Hostname(), port)
+}
@@ -0,0 +1,531 @@
+package goscreenmonit
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/micaiahwallace/goscreenmonit/uploadpb"
+)
+
+// Number of frames buffered for writing before ingestion waits on the disk
+const frameStoreQueue = 256
+
+// How far ahead of the server clock an agent's capture time is trusted, later times are clamped so
+// retention still ages out frames from agents whose clocks run fast
+const maxFrameClockSkew = time.Minute
+
+// Returned for agent ids that can't name a recording directory
+var ErrInvalidAgentID = errors.New("invalid agent id")
+
+// Limits applied to recorded frames per agent, zero disables a limit
+type RetentionPolicy struct {
+	MaxAge        time.Duration
+	MaxAgentBytes int64
+	GCInterval    time.Duration
+}
+
+// A recorded frame on disk
+type StoredFrame struct {
+	AgentID   string
+	Display   int
+	Timestamp time.Time
+	Format    uploadpb.ImageFormat
+	Path      string
+	Size      int64
+}
+
+type pendingFrame struct {
+	agentID   string
+	display   int
+	timestamp time.Time
+	format    uploadpb.ImageFormat
+	data      []byte
+}
+
+// Identifies the recording of one agent display
+type displayKey struct {
+	agentID string
+	display int
+}
+
+// Persists every received frame per agent and display under a root directory:
+// <root>/<hex agent id>/<display>/<unix nanos>.<png|jpg>
+// Each display's frame list is read from disk once and then kept up to date in memory.
+type FrameStore struct {
+	root      string
+	retention RetentionPolicy
+	queue     chan pendingFrame
+	quit      chan struct{}
+	stopOnce  sync.Once
+	mutex     sync.Mutex
+	index     map[displayKey][]StoredFrame
+}
+
+// Create a frame store rooted at a directory
+func NewFrameStore(root string, retention RetentionPolicy) (*FrameStore, error) {
+	if err := os.MkdirAll(root, 0700); err != nil {
+		return nil, err
+	}
+	return &FrameStore{
+		root:      root,
+		retention: retention,
+		queue:     make(chan pendingFrame, frameStoreQueue),
+		quit:      make(chan struct{}),
+		index:     make(map[displayKey][]StoredFrame),
+	}, nil
+}
+
+// Record frames received by the monitor server and start background writing and gc
+func (store *FrameStore) Start(mserver *Server) {
+	mserver.Subscribe("", store.handleEvent)
+	go store.writeLoop()
+	if store.retention.GCInterval > 0 {
+		go store.gcLoop()
+	}
+}
+
+// Stop background writing and gc
+func (store *FrameStore) Stop() {
+	store.stopOnce.Do(func() { close(store.quit) })
+}
+
+// Queue each display of a received or backfilled frame for writing
+func (store *FrameStore) handleEvent(ev Event) {
+	if ev.Type != EventFrame && ev.Type != EventBackfill {
+		return
+	}
+
+	// Prefer the agent's capture time, but never much later than the frame arrived
+	received := time.Now()
+	ts := received
+	if ev.Upload.GetTimestamp() != nil {
+		ts = ev.Upload.GetTimestamp().AsTime()
+		if latest := received.Add(maxFrameClockSkew); ts.After(latest) {
+			ts = latest
+		}
+	}
+
+	for i, im := range ev.Upload.GetImages() {
+
+		// Failed captures have no image
+		if len(im) == 0 {
+			continue
+		}
+		frame := pendingFrame{agentID: ev.Client.ID, display: i, timestamp: ts, format: imageFormat(ev.Upload.GetFormats(), i), data: im}
+		select {
+		case store.queue <- frame:
+		case <-store.quit:
+			return
+		}
+	}
+}
+
+// Write queued frames to disk
+func (store *FrameStore) writeLoop() {
+	for {
+		select {
+		case <-store.quit:
+			return
+		case frame := <-store.queue:
+			if err := store.Save(frame.agentID, frame.display, frame.timestamp, frame.format, frame.data); err != nil {
+				log.Printf("Unable to record frame for %s: %v\n", frame.agentID, err)
+			}
+		}
+	}
+}
+
+// Write a single png or jpeg frame to disk
+func (store *FrameStore) Save(agentID string, display int, ts time.Time, format uploadpb.ImageFormat, data []byte) error {
+	dir, err := store.displayDir(agentID, display)
+	if err != nil {
+		return err
+	}
+
+	// Write to a temp file first so readers never see partial frames. Gc may remove the
+	// directory once it is empty, so create it again if it vanished in between
+	path := filepath.Join(dir, frameFileName(ts, format))
+	tmp := path + ".tmp"
+	for attempt := 0; ; attempt++ {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return err
+		}
+		err = ioutil.WriteFile(tmp, data, 0600)
+		if err == nil || !os.IsNotExist(err) || attempt > 0 {
+			break
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	store.addToIndex(StoredFrame{AgentID: agentID, Display: display, Timestamp: time.Unix(0, ts.UnixNano()), Format: format, Path: path, Size: info.Size()})
+	return nil
+}
+
+// List recorded frames for an agent display, oldest first
+func (store *FrameStore) Frames(agentID string, display int) ([]StoredFrame, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	frames, err := store.indexed(agentID, display)
+	if err != nil {
+		return nil, err
+	}
+	return append([]StoredFrame{}, frames...), nil
+}
+
+// Get the frames of an agent display, reading them from disk the first time. Called with the lock held
+func (store *FrameStore) indexed(agentID string, display int) ([]StoredFrame, error) {
+	key := displayKey{agentID: agentID, display: display}
+	if frames, ok := store.index[key]; ok {
+		return frames, nil
+	}
+
+	dir, err := store.displayDir(agentID, display)
+	if err != nil {
+		return nil, err
+	}
+	frames, err := store.framesInDir(dir, display)
+	if os.IsNotExist(err) {
+		frames, err = []StoredFrame{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for i := range frames {
+		frames[i].AgentID = agentID
+	}
+
+	// File names are zero padded so this is already time order, but don't rely on ReadDir
+	sort.Slice(frames, func(i, j int) bool { return frames[i].Timestamp.Before(frames[j].Timestamp) })
+	store.index[key] = frames
+	return frames, nil
+}
+
+// Add a saved frame to its display's index in time order, unless the index isn't loaded yet
+func (store *FrameStore) addToIndex(frame StoredFrame) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	key := displayKey{agentID: frame.AgentID, display: frame.Display}
+	frames, ok := store.index[key]
+	if !ok {
+		return
+	}
+
+	// Live frames append, backfilled ones are inserted further back
+	i := sort.Search(len(frames), func(i int) bool { return frames[i].Timestamp.After(frame.Timestamp) })
+	if i > 0 && frames[i-1].Path == frame.Path {
+		frames[i-1] = frame
+		return
+	}
+	frames = append(frames, StoredFrame{})
+	copy(frames[i+1:], frames[i:])
+	frames[i] = frame
+	store.index[key] = frames
+}
+
+// Drop removed frames from an agent's display indexes
+func (store *FrameStore) removeFromIndex(agentID string, removed map[string]bool) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	for key, frames := range store.index {
+		if key.agentID != agentID {
+			continue
+		}
+		kept := frames[:0]
+		for _, f := range frames {
+			if !removed[f.Path] {
+				kept = append(kept, f)
+			}
+		}
+		store.index[key] = kept
+	}
+}
+
+// A span of recorded frames without gaps longer than the range gap
+type FrameRange struct {
+	Start  time.Time `json:"start"`
+	End    time.Time `json:"end"`
+	Frames int       `json:"frames"`
+}
+
+// Group an agent display's recorded frames into contiguous ranges
+func (store *FrameStore) Ranges(agentID string, display int, gap time.Duration) ([]FrameRange, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	frames, err := store.indexed(agentID, display)
+	if err != nil {
+		return nil, err
+	}
+
+	ranges := make([]FrameRange, 0)
+	for _, f := range frames {
+		last := len(ranges) - 1
+		if last >= 0 && f.Timestamp.Sub(ranges[last].End) <= gap {
+			ranges[last].End = f.Timestamp
+			ranges[last].Frames++
+			continue
+		}
+		ranges = append(ranges, FrameRange{Start: f.Timestamp, End: f.Timestamp, Frames: 1})
+	}
+	return ranges, nil
+}
+
+// Find the recorded frame closest to a time
+func (store *FrameStore) Nearest(agentID string, display int, t time.Time) (StoredFrame, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	frames, err := store.indexed(agentID, display)
+	if err != nil {
+		return StoredFrame{}, err
+	}
+	if len(frames) == 0 {
+		return StoredFrame{}, ErrNotFound
+	}
+
+	// Compare the first frame at or after t with the one before it
+	i := sort.Search(len(frames), func(i int) bool { return !frames[i].Timestamp.Before(t) })
+	if i == len(frames) {
+		return frames[i-1], nil
+	}
+	if i > 0 && t.Sub(frames[i-1].Timestamp) < frames[i].Timestamp.Sub(t) {
+		return frames[i-1], nil
+	}
+	return frames[i], nil
+}
+
+// List recorded frames within a time range, a zero bound is open
+func (store *FrameStore) Between(agentID string, display int, from, to time.Time) ([]StoredFrame, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	frames, err := store.indexed(agentID, display)
+	if err != nil {
+		return nil, err
+	}
+
+	start := 0
+	if !from.IsZero() {
+		start = sort.Search(len(frames), func(i int) bool { return !frames[i].Timestamp.Before(from) })
+	}
+	end := len(frames)
+	if !to.IsZero() {
+		end = sort.Search(len(frames), func(i int) bool { return frames[i].Timestamp.After(to) })
+	}
+	if start > end {
+		start = end
+	}
+	return append([]StoredFrame{}, frames[start:end]...), nil
+}
+
+// Read a recorded frame's image data
+func (store *FrameStore) Read(frame StoredFrame) ([]byte, error) {
+	return ioutil.ReadFile(frame.Path)
+}
+
+// List displays with recordings for an agent
+func (store *FrameStore) Displays(agentID string) ([]int, error) {
+	dir, err := store.agentDir(agentID)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return []int{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	displays := make([]int, 0)
+	for _, entry := range entries {
+		if d, err := strconv.Atoi(entry.Name()); err == nil && entry.IsDir() {
+			displays = append(displays, d)
+		}
+	}
+	sort.Ints(displays)
+	return displays, nil
+}
+
+// Apply the retention policy to every agent
+func (store *FrameStore) GC() error {
+	agents, err := ioutil.ReadDir(store.root)
+	if err != nil {
+		return err
+	}
+
+	cutoff := time.Time{}
+	if store.retention.MaxAge > 0 {
+		cutoff = time.Now().Add(-store.retention.MaxAge)
+	}
+
+	for _, agent := range agents {
+		if !agent.IsDir() {
+			continue
+		}
+		if err := store.gcAgent(agent.Name(), cutoff); err != nil {
+			log.Printf("Recording gc failed for %s: %v\n", agent.Name(), err)
+		}
+	}
+	return nil
+}
+
+// Remove expired frames then the oldest frames until the agent is under its size limit,
+// then the display and agent directories left empty
+func (store *FrameStore) gcAgent(agentDir string, cutoff time.Time) error {
+	displays, err := ioutil.ReadDir(filepath.Join(store.root, agentDir))
+	if err != nil {
+		return err
+	}
+	agentID := agentIDFromDir(agentDir)
+
+	// Gather all frames across displays
+	frames := make([]StoredFrame, 0)
+	for _, d := range displays {
+		display, cerr := strconv.Atoi(d.Name())
+		if cerr != nil || !d.IsDir() {
+			continue
+		}
+		dframes, ferr := store.framesInDir(filepath.Join(store.root, agentDir, d.Name()), display)
+		if ferr != nil {
+			return ferr
+		}
+		frames = append(frames, dframes...)
+	}
+	sort.Slice(frames, func(i, j int) bool { return frames[i].Timestamp.Before(frames[j].Timestamp) })
+
+	var total int64
+	for _, f := range frames {
+		total += f.Size
+	}
+
+	removed := make(map[string]bool)
+	var rerr error
+	for _, f := range frames {
+		expired := !cutoff.IsZero() && f.Timestamp.Before(cutoff)
+		oversize := store.retention.MaxAgentBytes > 0 && total > store.retention.MaxAgentBytes
+		if !expired && !oversize {
+			break
+		}
+		if err := os.Remove(f.Path); err != nil && !os.IsNotExist(err) {
+			rerr = err
+			break
+		}
+		total -= f.Size
+		removed[f.Path] = true
+	}
+
+	if len(removed) > 0 {
+		store.removeFromIndex(agentID, removed)
+		log.Printf("Recording gc removed %d frames for %s\n", len(removed), agentID)
+	}
+
+	// Removing a directory only succeeds once it is empty
+	for _, d := range displays {
+		if d.IsDir() {
+			os.Remove(filepath.Join(store.root, agentDir, d.Name()))
+		}
+	}
+	os.Remove(filepath.Join(store.root, agentDir))
+	return rerr
+}
+
+// List frames in a display directory
+func (store *FrameStore) framesInDir(dir string, display int) ([]StoredFrame, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	frames := make([]StoredFrame, 0, len(entries))
+	for _, entry := range entries {
+		if ts, format, ok := parseFrameFileName(entry.Name()); ok {
+			frames = append(frames, StoredFrame{Display: display, Timestamp: ts, Format: format, Path: filepath.Join(dir, entry.Name()), Size: entry.Size()})
+		}
+	}
+	return frames, nil
+}
+
+// Periodically apply the retention policy
+func (store *FrameStore) gcLoop() {
+	ticker := time.NewTicker(store.retention.GCInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-store.quit:
+			return
+		case <-ticker.C:
+			if err := store.GC(); err != nil {
+				log.Printf("Recording gc failed: %v\n", err)
+			}
+		}
+	}
+}
+
+// Directory holding frames for an agent. Agents choose their own ids, so the id is hex
+// encoded to keep it inside the root and distinct from every other id
+func (store *FrameStore) agentDir(agentID string) (string, error) {
+	if agentID == "" || agentID == "." || agentID == ".." {
+		return "", ErrInvalidAgentID
+	}
+	return filepath.Join(store.root, hex.EncodeToString([]byte(agentID))), nil
+}
+
+// Directory holding frames for an agent display
+func (store *FrameStore) displayDir(agentID string, display int) (string, error) {
+	dir, err := store.agentDir(agentID)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, strconv.Itoa(display)), nil
+}
+
+// Get the agent id an agent directory is named after, or the name itself if it isn't hex
+func agentIDFromDir(name string) string {
+	id, err := hex.DecodeString(name)
+	if err != nil {
+		return name
+	}
+	return string(id)
+}
+
+// Zero padded so lexical order matches time order
+func frameFileName(ts time.Time, format uploadpb.ImageFormat) string {
+	ext := ".png"
+	if format == uploadpb.ImageFormat_JPEG {
+		ext = ".jpg"
+	}
+	return fmt.Sprintf("%020d%s", ts.UnixNano(), ext)
+}
+
+// Get the timestamp and format encoded in a frame file name
+func parseFrameFileName(name string) (time.Time, uploadpb.ImageFormat, bool) {
+	format := uploadpb.ImageFormat_PNG
+	ext := filepath.Ext(name)
+	switch ext {
+	case ".png":
+	case ".jpg":
+		format = uploadpb.ImageFormat_JPEG
+	default:
+		return time.Time{}, format, false
+	}
+	nanos, err := strconv.ParseInt(strings.TrimSuffix(name, ext), 10, 64)
+	if err != nil {
+		return time.Time{}, format, false
+	}
+	return time.Unix(0, nanos), format, true
+}
@@ -0,0 +1,12 @@
+#!/bin/bash
+# Generate an agent client certificate signed by a local CA for mutual tls.
+# Usage: ./genclientcert.sh <agent-name>
+set -e
+name=${1:?usage: genclientcert.sh <agent-name>}
+if [ ! -f ca.crt ]; then
+  openssl req -x509 -nodes -newkey rsa:4096 -keyout ca.key -out ca.crt -days 3650 -subj "/CN=goscreenmonit agent CA"
+fi
+openssl req -nodes -newkey rsa:2048 -keyout "$name.key" -out "$name.csr" -subj "/CN=$name"
+printf "extendedKeyUsage=clientAuth\n" > "$name.ext"
+openssl x509 -req -in "$name.csr" -CA ca.crt -CAkey ca.key -CAcreateserial -out "$name.crt" -days 365 -extfile "$name.ext"
+rm "$name.csr" "$name.ext"
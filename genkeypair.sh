@@ -1,2 +1,4 @@
 #!/bin/bash
-openssl req -x509 -nodes -newkey rsa:4096 -keyout server.key -out server.crt -days 365
\ No newline at end of file
+# Usage: ./genkeypair.sh [subjectAltName]  e.g. "DNS:monitor.local,IP:192.168.1.5"
+san=${1:-"DNS:localhost,IP:127.0.0.1"}
+openssl req -x509 -nodes -newkey rsa:4096 -keyout server.key -out server.crt -days 365 -addext "subjectAltName=$san"
@@ -9,6 +9,7 @@ require (
 	github.com/gobwas/ws v1.0.4
 	github.com/gorilla/mux v1.8.0
 	github.com/kbinani/screenshot v0.0.0-20210326165202-b96eb3309bb0
+	github.com/klauspost/compress v1.13.6
 	github.com/micaiahwallace/gowatchprog v0.0.0-20210622045044-519156bced13
 	google.golang.org/protobuf v1.26.0
 )
@@ -0,0 +1,93 @@
+package goscreenmonit
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/micaiahwallace/goscreenmonit/uploadpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Default heartbeat timing, a connection is dropped after missing a few heartbeats
+const (
+	DefaultHeartbeatInterval = 15 * time.Second
+	DefaultHeartbeatTimeout  = 45 * time.Second
+	DefaultWriteTimeout      = 30 * time.Second
+)
+
+// Expect agents to ping every interval and drop connections silent for longer than timeout
+func (server *Server) SetHeartbeat(interval, timeout time.Duration) {
+	server.heartbeatInterval = interval
+	server.heartbeatTimeout = timeout
+}
+
+// Drop connections whose writes block longer than timeout
+func (server *Server) SetWriteTimeout(timeout time.Duration) {
+	server.writeTimeout = timeout
+}
+
+// Answer an agent ping with a pong echoing it
+func (server *Server) handlePing(ping *uploadpb.Heartbeat, conn *FramedConn, client *RegisteredClient) {
+	if client != nil {
+		client.setLastHeartbeat(time.Now())
+	}
+	pong, err := CreateResponseMessage(uploadpb.ServerResponse_PONG, ping)
+	if err != nil {
+		log.Printf("Unable to create pong. %v\n", err)
+		return
+	}
+	conn.WriteFrame(pong)
+}
+
+// Get when the agent last sent a heartbeat, zero if it never did
+func (client *RegisteredClient) GetLastHeartbeat() time.Time {
+	client.mutex.RLock()
+	defer client.mutex.RUnlock()
+	return client.lastHeartbeat
+}
+
+// Record an agent heartbeat
+func (client *RegisteredClient) setLastHeartbeat(at time.Time) {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	client.lastHeartbeat = at
+}
+
+// Ping the server every interval and reconnect when nothing arrives for longer than timeout
+func (session *Session) SetHeartbeat(interval, timeout time.Duration) {
+	session.heartbeatInterval = interval
+	session.heartbeatTimeout = timeout
+}
+
+// Reconnect when a write blocks longer than timeout
+func (session *Session) SetWriteTimeout(timeout time.Duration) {
+	session.writeTimeout = timeout
+}
+
+// Ping the server over a connection until it closes, the pongs keep the read deadline from expiring
+func (session *Session) heartbeat(ctx context.Context, conn *FramedConn, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var sequence uint64
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		sequence++
+		ping, err := CreateRequest(uploadpb.ClientRequest_PING, &uploadpb.Heartbeat{
+			Sequence: sequence,
+			Sent:     timestamppb.Now(),
+		})
+		if err != nil {
+			log.Printf("Unable to create ping: %v\n", err)
+			return
+		}
+		if err := conn.WriteFrame(ping); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package goscreenmonit
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Default number of frames queued per viewer before the oldest is dropped
+const DefaultViewerQueue = 2
+
+// Default time a viewer write may block before the viewer is disconnected
+const DefaultViewerStall = 10 * time.Second
+
+// A browser watching one display of an agent
+type Viewer struct {
+	sent    uint64 // first for 64-bit atomic alignment
+	dropped uint64
+
+	Name     string
+	ClientID string
+	Screen   int
+	Started  time.Time
+
+	queue     chan []byte
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// Snapshot of a viewer's delivery counters
+type ViewerStats struct {
+	Name     string    `json:"name"`
+	ClientID string    `json:"clientId"`
+	Screen   int       `json:"screen"`
+	Started  time.Time `json:"started"`
+	Sent     uint64    `json:"sent"`
+	Dropped  uint64    `json:"dropped"`
+	Queued   int       `json:"queued"`
+}
+
+// Fans agent frames out to viewers through bounded per-viewer queues so slow viewers never block ingestion
+type FrameHub struct {
+	mutex     sync.RWMutex
+	viewers   map[string]map[*Viewer]struct{}
+	queueSize int
+	stall     time.Duration
+}
+
+// Create a hub fed by frame events from the monitor server
+func NewFrameHub(mserver *Server) *FrameHub {
+	hub := &FrameHub{
+		viewers:   make(map[string]map[*Viewer]struct{}),
+		queueSize: DefaultViewerQueue,
+		stall:     DefaultViewerStall,
+	}
+	mserver.Subscribe("", hub.handleEvent)
+	return hub
+}
+
+// Configure per-viewer queue length and the write stall threshold for new viewers
+func (hub *FrameHub) SetLimits(queueSize int, stall time.Duration) {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+	if queueSize > 0 {
+		hub.queueSize = queueSize
+	}
+	if stall > 0 {
+		hub.stall = stall
+	}
+}
+
+// Time a single viewer write may block before the viewer is considered stuck
+func (hub *FrameHub) StallTimeout() time.Duration {
+	hub.mutex.RLock()
+	defer hub.mutex.RUnlock()
+	return hub.stall
+}
+
+// Add a viewer for an agent display
+func (hub *FrameHub) AddViewer(name, clientID string, screen int) *Viewer {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+
+	viewer := &Viewer{
+		Name:     name,
+		ClientID: clientID,
+		Screen:   screen,
+		Started:  time.Now(),
+		queue:    make(chan []byte, hub.queueSize),
+		done:     make(chan struct{}),
+	}
+	if hub.viewers[clientID] == nil {
+		hub.viewers[clientID] = make(map[*Viewer]struct{})
+	}
+	hub.viewers[clientID][viewer] = struct{}{}
+	return viewer
+}
+
+// Remove a viewer and stop its delivery
+func (hub *FrameHub) RemoveViewer(viewer *Viewer) {
+	hub.mutex.Lock()
+	if set, ok := hub.viewers[viewer.ClientID]; ok {
+		delete(set, viewer)
+		if len(set) == 0 {
+			delete(hub.viewers, viewer.ClientID)
+		}
+	}
+	hub.mutex.Unlock()
+
+	viewer.closeOnce.Do(func() { close(viewer.done) })
+}
+
+// Get delivery stats for all viewers
+func (hub *FrameHub) Stats() []ViewerStats {
+	hub.mutex.RLock()
+	defer hub.mutex.RUnlock()
+
+	stats := make([]ViewerStats, 0)
+	for _, set := range hub.viewers {
+		for v := range set {
+			stats = append(stats, v.Stats())
+		}
+	}
+	return stats
+}
+
+// Count viewers watching an agent
+func (hub *FrameHub) ViewerCount(clientID string) int {
+	hub.mutex.RLock()
+	defer hub.mutex.RUnlock()
+	return len(hub.viewers[clientID])
+}
+
+// Queue the newest frame for every viewer of the agent without blocking
+func (hub *FrameHub) handleEvent(ev Event) {
+	if ev.Type != EventFrame {
+		return
+	}
+	images := ev.Upload.GetImages()
+
+	hub.mutex.RLock()
+	defer hub.mutex.RUnlock()
+	for v := range hub.viewers[ev.Client.ID] {
+		if v.Screen < 0 || v.Screen >= len(images) || len(images[v.Screen]) == 0 {
+			continue
+		}
+		v.offer(images[v.Screen])
+	}
+}
+
+// Channel of frames to write to the viewer
+func (viewer *Viewer) Frames() <-chan []byte {
+	return viewer.queue
+}
+
+// Closed when the viewer has been removed
+func (viewer *Viewer) Done() <-chan struct{} {
+	return viewer.done
+}
+
+// Record a successful write
+func (viewer *Viewer) MarkSent() {
+	atomic.AddUint64(&viewer.sent, 1)
+}
+
+// Get the viewer's delivery counters
+func (viewer *Viewer) Stats() ViewerStats {
+	return ViewerStats{
+		Name:     viewer.Name,
+		ClientID: viewer.ClientID,
+		Screen:   viewer.Screen,
+		Started:  viewer.Started,
+		Sent:     atomic.LoadUint64(&viewer.sent),
+		Dropped:  atomic.LoadUint64(&viewer.dropped),
+		Queued:   len(viewer.queue),
+	}
+}
+
+// Enqueue a frame, dropping the oldest queued frame when full
+func (viewer *Viewer) offer(frame []byte) {
+	for {
+		select {
+		case viewer.queue <- frame:
+			return
+		default:
+		}
+
+		select {
+		case <-viewer.queue:
+			atomic.AddUint64(&viewer.dropped, 1)
+		default:
+		}
+	}
+}
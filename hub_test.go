@@ -0,0 +1,204 @@
+package goscreenmonit
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/micaiahwallace/goscreenmonit/uploadpb"
+)
+
+// Upload carrying its sequence number as the image on every display
+func numberedUpload(seq, displays int) *uploadpb.ImageUpload {
+	images := make([][]byte, displays)
+	for i := range images {
+		images[i] = []byte(strconv.Itoa(seq))
+	}
+	return &uploadpb.ImageUpload{Images: images}
+}
+
+// Register many agents, each watched by fast viewers that keep up and slow viewers that never
+// read, while viewers, subscribers and readers churn alongside. Run with -race.
+func TestHubStress(t *testing.T) {
+	const (
+		agents      = 20
+		fastViewers = 4
+		slowViewers = 4
+		frames      = 500
+		queueSize   = 2
+		displays    = 2
+	)
+
+	server := NewServer("127.0.0.1:0", "", "")
+	hub := NewFrameHub(server)
+	hub.SetLimits(queueSize, time.Second)
+
+	clients := make([]*RegisteredClient, agents)
+	for i := range clients {
+		clients[i] = &RegisteredClient{ID: fmt.Sprintf("agent-%d", i), Register: &uploadpb.Register{}}
+		if _, err := server.registry.Add(clients[i], DuplicateReject); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Fast viewers drain their queue until removed, remembering the last frame they got
+	var viewers sync.WaitGroup
+	fastLast := make([][]string, agents)
+	fast := make([][]*Viewer, agents)
+	slow := make([][]*Viewer, agents)
+	for a, client := range clients {
+		fastLast[a] = make([]string, fastViewers)
+		for v := 0; v < fastViewers; v++ {
+			viewer := hub.AddViewer("fast", client.ID, v%displays)
+			fast[a] = append(fast[a], viewer)
+			viewers.Add(1)
+			go func(a, v int, viewer *Viewer) {
+				defer viewers.Done()
+				for {
+					select {
+					case frame := <-viewer.Frames():
+						viewer.MarkSent()
+						fastLast[a][v] = string(frame)
+					case <-viewer.Done():
+						return
+					}
+				}
+			}(a, v, viewer)
+		}
+		for v := 0; v < slowViewers; v++ {
+			slow[a] = append(slow[a], hub.AddViewer("slow", client.ID, v%displays))
+		}
+	}
+
+	// Churn viewers, subscriptions and read paths while frames flow
+	stop := make(chan struct{})
+	var churn sync.WaitGroup
+	churn.Add(1)
+	go func() {
+		defer churn.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			id := clients[i%agents].ID
+			viewer := hub.AddViewer("churn", id, 0)
+			cancel := server.Subscribe(id, func(Event) {})
+			hub.Stats()
+			hub.ViewerCount(id)
+			for _, c := range server.GetClients() {
+				c.GetStats()
+				c.GetLatestUpload()
+			}
+			cancel()
+			hub.RemoveViewer(viewer)
+		}
+	}()
+
+	// Every agent uploads from its own goroutine, which must never wait on a viewer
+	ingested := make(chan struct{})
+	go func() {
+		var uploads sync.WaitGroup
+		for _, client := range clients {
+			uploads.Add(1)
+			go func(client *RegisteredClient) {
+				defer uploads.Done()
+				for seq := 0; seq < frames; seq++ {
+					server.registry.RecordUpload(client, numberedUpload(seq, displays), 100)
+				}
+			}(client)
+		}
+		uploads.Wait()
+		close(ingested)
+	}()
+	select {
+	case <-ingested:
+	case <-time.After(20 * time.Second):
+		t.Fatal("ingestion blocked on viewers")
+	}
+	close(stop)
+	churn.Wait()
+
+	// Slow viewers kept only the newest frames and counted the rest as dropped
+	newest := []string{strconv.Itoa(frames - 2), strconv.Itoa(frames - 1)}
+	for a := range clients {
+		for _, viewer := range slow[a] {
+			stats := viewer.Stats()
+			if stats.Dropped != frames-queueSize {
+				t.Errorf("%s slow viewer dropped %d frames, want %d", clients[a].ID, stats.Dropped, frames-queueSize)
+			}
+			for _, want := range newest {
+				if got := string(<-viewer.Frames()); got != want {
+					t.Errorf("%s slow viewer queued frame %s, want %s", clients[a].ID, got, want)
+				}
+			}
+		}
+	}
+
+	// Fast viewers end up with the final frame
+	deadline := time.Now().Add(5 * time.Second)
+	for a := range clients {
+		for _, viewer := range fast[a] {
+			for len(viewer.Frames()) > 0 && time.Now().Before(deadline) {
+				time.Sleep(time.Millisecond)
+			}
+			hub.RemoveViewer(viewer)
+		}
+		for _, viewer := range slow[a] {
+			hub.RemoveViewer(viewer)
+		}
+	}
+	viewers.Wait()
+	for a := range clients {
+		for v, last := range fastLast[a] {
+			if last != strconv.Itoa(frames-1) {
+				t.Errorf("%s fast viewer %d last got frame %s, want %d", clients[a].ID, v, last, frames-1)
+			}
+		}
+		if stats := clients[a].GetStats(); stats.Frames != frames {
+			t.Errorf("%s recorded %d frames, want %d", clients[a].ID, stats.Frames, frames)
+		}
+	}
+	if n := len(hub.Stats()); n != 0 {
+		t.Errorf("%d viewers left after removing all of them", n)
+	}
+
+	// Deregistering leaves an empty registry
+	for _, client := range clients {
+		if !server.registry.Remove(client) {
+			t.Errorf("%s was not registered", client.ID)
+		}
+	}
+	if n := len(server.GetClients()); n != 0 {
+		t.Errorf("%d clients left after removing all of them", n)
+	}
+}
+
+// Replacing a connection tells subscribers the old one is gone before announcing the new one
+func TestRegistryReplacePublishesDeregistered(t *testing.T) {
+	reg := NewRegistry()
+	old := &RegisteredClient{ID: "agent", Register: &uploadpb.Register{}}
+	replacement := &RegisteredClient{ID: "agent", Register: &uploadpb.Register{}}
+	if _, err := reg.Add(old, DuplicateReplace); err != nil {
+		t.Fatal(err)
+	}
+
+	var events []Event
+	reg.Subscribe("agent", func(event Event) { events = append(events, event) })
+	if existing, err := reg.Add(replacement, DuplicateReplace); err != nil || existing != old {
+		t.Fatalf("replace returned %v, %v", existing, err)
+	}
+	if len(events) != 2 ||
+		events[0].Type != EventDeregistered || events[0].Client != old ||
+		events[1].Type != EventRegistered || events[1].Client != replacement {
+		t.Fatalf("unexpected events on replace: %+v", events)
+	}
+
+	// The old connection closing later doesn't deregister the replacement
+	if reg.Remove(old) || len(events) != 2 {
+		t.Fatal("closing the replaced connection removed the new one")
+	}
+}
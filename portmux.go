@@ -0,0 +1,227 @@
+package goscreenmonit
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ALPN protocol agents offer so a shared port can tell them from browsers
+const AgentProtocol = "goscreenmonit"
+
+// Bytes read to tell agents from http clients that didn't negotiate a protocol
+const sniffSize = 8
+
+var errMuxClosed = errors.New("shared listener closed")
+
+// Splits one tls listener between agents and the web server, by ALPN protocol or, for clients
+// that don't offer one, by the first bytes they send
+type PortMux struct {
+	listener net.Listener
+	timeout  time.Duration
+	agents   *muxListener
+	web      *muxListener
+}
+
+// Split a listener, giving each connection timeout to finish its handshake and send its first bytes
+func NewPortMux(listener net.Listener, timeout time.Duration) *PortMux {
+	return &PortMux{
+		listener: listener,
+		timeout:  timeout,
+		agents:   newMuxListener(listener.Addr()),
+		web:      newMuxListener(listener.Addr()),
+	}
+}
+
+// Listen on one tls address for both agents and the web server, using the monitor server's
+// certificate. Client certificates are requested but only required from agents.
+func ListenShared(address string, server *Server) (*PortMux, error) {
+	tlsconfig, err := server.TLSConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	// Browsers can't be required to present a certificate, agents are checked after the handshake
+	if tlsconfig.ClientAuth == tls.RequireAndVerifyClientCert {
+		tlsconfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	tlsconfig.NextProtos = []string{AgentProtocol, "http/1.1"}
+
+	listener, err := tls.Listen("tcp4", address, tlsconfig)
+	if err != nil {
+		return nil, err
+	}
+	return NewPortMux(listener, server.heartbeatTimeout), nil
+}
+
+// Listener handing out agent connections, pass it to Server.SetListener
+func (pm *PortMux) AgentListener() net.Listener {
+	return pm.agents
+}
+
+// Listener handing out web connections, pass it to WebServer.SetListener
+func (pm *PortMux) WebListener() net.Listener {
+	return pm.web
+}
+
+// Accept and dispatch connections until the listener closes
+func (pm *PortMux) Serve() error {
+	defer pm.agents.Close()
+	defer pm.web.Close()
+	for {
+		conn, err := pm.listener.Accept()
+		if err != nil {
+			if nerr, ok := err.(net.Error); ok && nerr.Temporary() {
+				log.Printf("Incoming connection error: %v\n", err)
+				continue
+			}
+			return err
+		}
+		go pm.dispatch(conn)
+	}
+}
+
+// Stop accepting connections
+func (pm *PortMux) Close() error {
+	return pm.listener.Close()
+}
+
+// Hand a connection to the agent or web listener
+func (pm *PortMux) dispatch(conn net.Conn) {
+	conn.SetDeadline(time.Now().Add(pm.timeout))
+
+	// Prefer the protocol negotiated during the handshake
+	if tlsconn, ok := conn.(*tls.Conn); ok {
+		if err := tlsconn.Handshake(); err != nil {
+			log.Printf("TLS handshake failed for %s: %v\n", conn.RemoteAddr(), err)
+			conn.Close()
+			return
+		}
+		switch tlsconn.ConnectionState().NegotiatedProtocol {
+		case AgentProtocol:
+			conn.SetDeadline(time.Time{})
+			pm.agents.deliver(conn, pm.timeout)
+			return
+		case "http/1.1":
+			conn.SetDeadline(time.Time{})
+			pm.web.deliver(conn, pm.timeout)
+			return
+		}
+	}
+
+	// Agents open with a frame length whose high byte is always zero, http with a printable request line
+	reader := bufio.NewReaderSize(conn, 16)
+	prefix, err := reader.Peek(sniffSize)
+	if err != nil {
+		log.Printf("Unable to identify connection %s: %v\n", conn.RemoteAddr(), err)
+		conn.Close()
+		return
+	}
+	conn.SetDeadline(time.Time{})
+	sniffed := &sniffedConn{Conn: conn, reader: reader}
+	if prefix[sniffSize-1] == 0 {
+		pm.agents.deliver(sniffed, pm.timeout)
+	} else {
+		pm.web.deliver(sniffed, pm.timeout)
+	}
+}
+
+// Connection whose first bytes were already read while sniffing
+type sniffedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (sc *sniffedConn) Read(b []byte) (int, error) {
+	return sc.reader.Read(b)
+}
+
+// Get the tls state of the underlying connection
+func (sc *sniffedConn) ConnectionState() tls.ConnectionState {
+	if stater, ok := sc.Conn.(connectionStater); ok {
+		return stater.ConnectionState()
+	}
+	return tls.ConnectionState{}
+}
+
+// Context key holding the sniffed connection a request arrived on
+type sniffedConnKey struct{}
+
+// Remember sniffed connections in their requests' context, set as http.Server.ConnContext
+func sniffedConnContext(ctx context.Context, conn net.Conn) context.Context {
+	if sc, ok := conn.(*sniffedConn); ok {
+		return context.WithValue(ctx, sniffedConnKey{}, sc)
+	}
+	return ctx
+}
+
+// Fill in the tls state http.Server leaves out for connections that aren't a *tls.Conn
+func restoreSniffedTLS(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if sc, ok := r.Context().Value(sniffedConnKey{}).(*sniffedConn); ok && r.TLS == nil {
+			if stater, ok := sc.Conn.(connectionStater); ok {
+				state := stater.ConnectionState()
+				r.TLS = &state
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Listener fed with connections dispatched by a PortMux
+type muxListener struct {
+	addr   net.Addr
+	conns  chan net.Conn
+	closed chan struct{}
+	once   sync.Once
+}
+
+func newMuxListener(addr net.Addr) *muxListener {
+	return &muxListener{
+		addr:   addr,
+		conns:  make(chan net.Conn),
+		closed: make(chan struct{}),
+	}
+}
+
+// Wait for the next dispatched connection
+func (ml *muxListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-ml.conns:
+		return conn, nil
+	case <-ml.closed:
+		return nil, errMuxClosed
+	}
+}
+
+// Stop handing out connections
+func (ml *muxListener) Close() error {
+	ml.once.Do(func() { close(ml.closed) })
+	return nil
+}
+
+// Get the shared listener address
+func (ml *muxListener) Addr() net.Addr {
+	return ml.addr
+}
+
+// Pass a connection to whoever is accepting, closing it if the listener is closed or nobody
+// accepts it within timeout
+func (ml *muxListener) deliver(conn net.Conn, timeout time.Duration) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case ml.conns <- conn:
+	case <-ml.closed:
+		conn.Close()
+	case <-timer.C:
+		log.Printf("Connection %s was not accepted in time, closing it.\n", conn.RemoteAddr())
+		conn.Close()
+	}
+}
@@ -0,0 +1,80 @@
+package goscreenmonit
+
+import "fmt"
+
+// Current wire protocol version spoken by this build. v1 is the original host and user
+// registration, v2 adds version, capability and codec negotiation.
+const ProtocolVersion uint32 = 2
+
+// Oldest wire protocol version this build will negotiate down to
+const MinProtocolVersion uint32 = 1
+
+// Version of peers that predate negotiation and never send one
+const legacyProtocolVersion uint32 = 1
+
+// Capability names exchanged during registration
+const (
+	CapZlibUpload = "upload.zlib"
+	CapDeltaTiles = "upload.delta"
+	CapHeartbeat  = "heartbeat"
+	CapBackfill   = "upload.backfill"
+	CapErrors     = "errors"
+	CapCommands   = "commands"
+	CapStream     = "stream.control"
+)
+
+// Agent build version, set at link time with
+// -ldflags "-X github.com/micaiahwallace/goscreenmonit.AgentVersion=x.y.z"
+var AgentVersion = "dev"
+
+// Capabilities supported by this build
+var Capabilities = []string{
+	CapZlibUpload,
+	CapDeltaTiles,
+	CapHeartbeat,
+	CapBackfill,
+	CapErrors,
+	CapCommands,
+	CapStream,
+}
+
+// Pick the protocol version to speak with a peer advertising its own version
+func NegotiateVersion(peer uint32) (uint32, error) {
+	peer = peerVersion(peer)
+	if peer < MinProtocolVersion {
+		return 0, fmt.Errorf("protocol version %d is older than minimum supported %d", peer, MinProtocolVersion)
+	}
+	if peer > ProtocolVersion {
+		return ProtocolVersion, nil
+	}
+	return peer, nil
+}
+
+// Get the version a peer speaks, treating a missing version as the original protocol
+func peerVersion(version uint32) uint32 {
+	if version == 0 {
+		return legacyProtocolVersion
+	}
+	return version
+}
+
+// Get the capabilities supported by both sides
+func NegotiateCapabilities(local, peer []string) []string {
+	shared := make([]string, 0)
+	for _, c := range local {
+		if HasCapability(peer, c) {
+			shared = append(shared, c)
+		}
+	}
+	return shared
+}
+
+// Check if a capability is in a capability list
+func HasCapability(caps []string, capability string) bool {
+	for _, c := range caps {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,225 @@
+package goscreenmonit
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/micaiahwallace/goscreenmonit/uploadpb"
+)
+
+// Returned when registering an agent id that is already connected under DuplicateReject
+var ErrDuplicateAgent = errors.New("agent already connected")
+
+// Minimum time between stats events for a single agent
+const statsEventInterval = time.Second
+
+// Kinds of registry events
+type EventType int
+
+const (
+	EventRegistered EventType = iota
+	EventDeregistered
+	EventFrame
+	EventStats
+	EventBackfill
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventRegistered:
+		return "registered"
+	case EventDeregistered:
+		return "deregistered"
+	case EventFrame:
+		return "frame"
+	case EventStats:
+		return "stats"
+	case EventBackfill:
+		return "backfill"
+	}
+	return "unknown"
+}
+
+// An event emitted by the registry to subscribers
+type Event struct {
+	Type   EventType
+	Client *RegisteredClient
+	Upload *uploadpb.ImageUpload
+	Stats  ClientStats
+}
+
+// Upload counters for a registered agent
+type ClientStats struct {
+	Frames      uint64
+	Bytes       uint64
+	LastFrameAt time.Time
+	AgentFPS    float64
+	Backfilled  uint64
+}
+
+// Handler invoked for each event a subscription matches
+type EventHandler func(Event)
+
+type subscription struct {
+	clientID string
+	handler  EventHandler
+}
+
+// Concurrency safe set of registered agents keyed by agent id
+type Registry struct {
+	mutex   sync.RWMutex
+	clients map[string]*RegisteredClient
+	subs    map[*subscription]struct{}
+}
+
+// Create an empty registry
+func NewRegistry() *Registry {
+	return &Registry{
+		clients: make(map[string]*RegisteredClient),
+		subs:    make(map[*subscription]struct{}),
+	}
+}
+
+// Add a client, applying the duplicate policy when its id is already registered.
+// Returns the client it replaced, if any.
+func (reg *Registry) Add(client *RegisteredClient, policy DuplicatePolicy) (*RegisteredClient, error) {
+	reg.mutex.Lock()
+	existing, ok := reg.clients[client.ID]
+	if ok && policy == DuplicateReject {
+		reg.mutex.Unlock()
+		return existing, ErrDuplicateAgent
+	}
+	reg.clients[client.ID] = client
+	reg.mutex.Unlock()
+
+	// The replaced connection no longer owns the id, so it is deregistered now rather than when it closes
+	if ok {
+		reg.Publish(Event{Type: EventDeregistered, Client: existing})
+	}
+	reg.Publish(Event{Type: EventRegistered, Client: client})
+	return existing, nil
+}
+
+// Remove a client unless its id has since been taken over by a newer registration
+func (reg *Registry) Remove(client *RegisteredClient) bool {
+	reg.mutex.Lock()
+	current, ok := reg.clients[client.ID]
+	if !ok || current != client {
+		reg.mutex.Unlock()
+		return false
+	}
+	delete(reg.clients, client.ID)
+	reg.mutex.Unlock()
+
+	reg.Publish(Event{Type: EventDeregistered, Client: client})
+	return true
+}
+
+// Get a client by agent id, nil if not registered
+func (reg *Registry) Get(id string) *RegisteredClient {
+	reg.mutex.RLock()
+	defer reg.mutex.RUnlock()
+	return reg.clients[id]
+}
+
+// Get a snapshot of all registered clients
+func (reg *Registry) List() []*RegisteredClient {
+	reg.mutex.RLock()
+	defer reg.mutex.RUnlock()
+
+	clients := make([]*RegisteredClient, 0, len(reg.clients))
+	for _, c := range reg.clients {
+		clients = append(clients, c)
+	}
+	return clients
+}
+
+// Subscribe to events for one agent id, or all agents when clientID is empty.
+// Handlers run on the publishing goroutine. Returns a function that cancels the subscription.
+func (reg *Registry) Subscribe(clientID string, handler EventHandler) func() {
+	sub := &subscription{clientID: clientID, handler: handler}
+
+	reg.mutex.Lock()
+	reg.subs[sub] = struct{}{}
+	reg.mutex.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			reg.mutex.Lock()
+			delete(reg.subs, sub)
+			reg.mutex.Unlock()
+		})
+	}
+}
+
+// Deliver an event to all matching subscribers
+func (reg *Registry) Publish(ev Event) {
+
+	// Snapshot handlers so they run without holding the lock
+	reg.mutex.RLock()
+	handlers := make([]EventHandler, 0, len(reg.subs))
+	for sub := range reg.subs {
+		if sub.clientID == "" || sub.clientID == ev.Client.ID {
+			handlers = append(handlers, sub.handler)
+		}
+	}
+	reg.mutex.RUnlock()
+
+	for _, h := range handlers {
+		h(ev)
+	}
+}
+
+// Store a client's latest upload, update its stats and notify subscribers
+func (reg *Registry) RecordUpload(client *RegisteredClient, upload *uploadpb.ImageUpload, size int) {
+	stats, statsDue := client.recordUpload(upload, size)
+
+	reg.Publish(Event{Type: EventFrame, Client: client, Upload: upload, Stats: stats})
+	if statsDue {
+		reg.Publish(Event{Type: EventStats, Client: client, Stats: stats})
+	}
+}
+
+// Notify subscribers of a frame the agent captured while disconnected, without touching the live state
+func (reg *Registry) RecordBackfill(client *RegisteredClient, upload *uploadpb.ImageUpload) {
+	client.mutex.Lock()
+	client.stats.Backfilled++
+	client.mutex.Unlock()
+
+	reg.Publish(Event{Type: EventBackfill, Client: client, Upload: upload})
+}
+
+// Get the client's latest upload
+func (client *RegisteredClient) GetLatestUpload() *uploadpb.ImageUpload {
+	client.mutex.RLock()
+	defer client.mutex.RUnlock()
+	return client.latestUpload
+}
+
+// Get the client's upload counters
+func (client *RegisteredClient) GetStats() ClientStats {
+	client.mutex.RLock()
+	defer client.mutex.RUnlock()
+	return client.stats
+}
+
+// Update upload state, returning the new stats and whether a stats event is due
+func (client *RegisteredClient) recordUpload(upload *uploadpb.ImageUpload, size int) (ClientStats, bool) {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	now := time.Now()
+	client.latestUpload = upload
+	client.stats.Frames++
+	client.stats.Bytes += uint64(size)
+	client.stats.LastFrameAt = now
+	client.stats.AgentFPS = float64(upload.GetEffectiveFps())
+
+	due := now.Sub(client.lastStatsEvent) >= statsEventInterval
+	if due {
+		client.lastStatsEvent = now
+	}
+	return client.stats, due
+}
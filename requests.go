@@ -26,27 +26,45 @@ func CreateRequest(reqtype uploadpb.ClientRequest_RequestType, message protorefl
 	return proto.Marshal(request)
 }
 
-// Create a registration message
-func CreateRegistration(host, user string) ([]byte, error) {
+// Create a registration message advertising this build's protocol
+func CreateRegistration(regcmd *uploadpb.Register) ([]byte, error) {
 
-	// Create registration command
-	regcmd := &uploadpb.Register{
-		Host: host,
-		User: user,
-	}
+	// Stamp registration with this build's version
+	regcmd.ProtocolVersion = ProtocolVersion
+	regcmd.AgentVersion = AgentVersion
 
 	return CreateRequest(uploadpb.ClientRequest_REGISTER, regcmd)
 }
 
-// Create an image upload message
-func CreateUpload(images [][]byte) ([]byte, error) {
+// Create an image upload message with the format of each image
+func CreateUpload(images [][]byte, formats []uploadpb.ImageFormat) ([]byte, error) {
 
 	// create the message
 	msg := &uploadpb.ImageUpload{
 		Images:    images,
+		Formats:   formats,
 		Timestamp: timestamppb.Now(),
 	}
 
 	// Serialize data
+	return CreateUploadMessage(msg)
+}
+
+// Create an image upload message from a prepared upload
+func CreateUploadMessage(msg *uploadpb.ImageUpload) ([]byte, error) {
 	return CreateRequest(uploadpb.ClientRequest_UPLOAD, msg)
 }
+
+// Create an acknowledgement of a server command, a nil error means it was applied
+func CreateCommandAck(id uint64, err error) ([]byte, error) {
+
+	ack := &uploadpb.CommandAck{
+		Id: id,
+		Ok: err == nil,
+	}
+	if err != nil {
+		ack.Error = err.Error()
+	}
+
+	return CreateRequest(uploadpb.ClientRequest_COMMAND_ACK, ack)
+}
@@ -1,8 +1,11 @@
 package goscreenmonit
 
 import (
+	"time"
+
 	"github.com/micaiahwallace/goscreenmonit/uploadpb"
 	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
 )
 
 // Create a server response container with a message
@@ -16,3 +19,66 @@ func CreateResponse(restype uploadpb.ServerResponse_MessageType) ([]byte, error)
 	// Serialize data
 	return proto.Marshal(request)
 }
+
+// Create a server response container wrapping a message payload
+func CreateResponseMessage(restype uploadpb.ServerResponse_MessageType, message protoreflect.ProtoMessage) ([]byte, error) {
+
+	// Get the response bytes
+	resbytes, err := proto.Marshal(message)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create a response container
+	response := &uploadpb.ServerResponse{
+		Type:     restype,
+		Response: resbytes,
+	}
+
+	// Serialize data
+	return proto.Marshal(response)
+}
+
+// Create a registration acceptance with the negotiated protocol, codec and stream mode
+func CreateAuthenticated(auth *uploadpb.Authenticated) ([]byte, error) {
+	return CreateResponseMessage(uploadpb.ServerResponse_AUTHENTICATED, auth)
+}
+
+// Create a registration rejection with a reason
+func CreateRejection(reason string) ([]byte, error) {
+
+	msg := &uploadpb.Rejected{
+		Reason:             reason,
+		MinProtocolVersion: MinProtocolVersion,
+		MaxProtocolVersion: ProtocolVersion,
+	}
+
+	return CreateResponseMessage(uploadpb.ServerResponse_REJECTED, msg)
+}
+
+// Create a stop stream message with the background sampling rate
+func CreateStopStream(backgroundFPS float64) ([]byte, error) {
+
+	msg := &uploadpb.StreamControl{
+		BackgroundFps: float32(backgroundFPS),
+	}
+
+	return CreateResponseMessage(uploadpb.ServerResponse_STOP_STREAM, msg)
+}
+
+// Create an operator command for an agent
+func CreateCommand(cmd *uploadpb.Command) ([]byte, error) {
+	return CreateResponseMessage(uploadpb.ServerResponse_COMMAND, cmd)
+}
+
+// Create an error response with a reason code and a hint for how long to wait before retrying
+func CreateError(code uploadpb.Error_Code, message string, retryAfter time.Duration) ([]byte, error) {
+
+	msg := &uploadpb.Error{
+		Code:         code,
+		Message:      message,
+		RetryAfterMs: uint32(retryAfter / time.Millisecond),
+	}
+
+	return CreateResponseMessage(uploadpb.ServerResponse_ERROR, msg)
+}
@@ -1,10 +1,7 @@
 package goscreenmonit
 
 import (
-	"bytes"
-	"compress/zlib"
 	"image"
-	"io/ioutil"
 
 	"github.com/kbinani/screenshot"
 )
@@ -62,65 +59,57 @@ import (
 // 	}
 // }
 
-// Get number of screens
-func GetScreenCount() int {
-	return screenshot.NumActiveDisplays()
-}
+// Source of display captures used by a session. A session calls its capturer from one
+// goroutine at a time, so implementations don't need to be goroutine-safe.
+type Capturer interface {
 
-// Capture screen by index
-func CaptureScreen(index int) (*image.RGBA, error) {
+	// Number of displays currently available
+	ScreenCount() int
 
-	// Capture display by index
-	img, err := screenshot.CaptureDisplay(index)
-	if err != nil {
-		return nil, err
-	}
+	// Capture a display by index
+	Capture(index int) (*image.RGBA, error)
 
-	return img, nil
+	// Position and scale of a display by index
+	Geometry(index int) DisplayGeometry
 }
 
-// Compress screenshot with zlib
-func EncodeImage(img []byte) ([]byte, error) {
+// Where a display sits in the virtual desktop
+type DisplayGeometry struct {
+	Bounds image.Rectangle
+	Scale  float64
+}
 
-	// Create buffer and encode image
-	var byt bytes.Buffer
-	w := zlib.NewWriter(&byt)
+// Captures the real displays of the logged in user
+type ScreenCapturer struct{}
 
-	// Write image to encoder
-	// log.Printf("Writing image: %v\n", len(img))
-	if _, werr := w.Write(img); werr != nil {
-		return nil, werr
-	}
+// Get number of screens
+func (ScreenCapturer) ScreenCount() int {
+	return GetScreenCount()
+}
 
-	// Close encoder for writing
-	// log.Printf("Closing writer\n")
-	if cerr := w.Close(); cerr != nil {
-		return nil, cerr
-	}
+// Capture screen by index
+func (ScreenCapturer) Capture(index int) (*image.RGBA, error) {
+	return CaptureScreen(index)
+}
 
-	// Return final encoded bytes
-	// log.Printf("Length: %v\n", byt.Len())
-	return byt.Bytes(), nil
+// Get screen bounds by index, captures are in physical pixels so the scale is always 1
+func (ScreenCapturer) Geometry(index int) DisplayGeometry {
+	return DisplayGeometry{Bounds: screenshot.GetDisplayBounds(index), Scale: 1}
 }
 
-// Decompress images with zlib
-func DecodeImage(encimg []byte) ([]byte, error) {
+// Get number of screens
+func GetScreenCount() int {
+	return screenshot.NumActiveDisplays()
+}
 
-	// Create buffer reader from bytes
-	byt := bytes.NewReader(encimg)
+// Capture screen by index
+func CaptureScreen(index int) (*image.RGBA, error) {
 
-	// Create zlib processor
-	r, err := zlib.NewReader(byt)
+	// Capture display by index
+	img, err := screenshot.CaptureDisplay(index)
 	if err != nil {
 		return nil, err
 	}
-	defer r.Close()
 
-	// Decode all bytes into new []byte
-	decbyt, derr := ioutil.ReadAll(r)
-	if derr != nil {
-		return nil, derr
-	}
-
-	return decbyt, nil
+	return img, nil
 }
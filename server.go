@@ -2,39 +2,112 @@ package goscreenmonit
 
 import (
 	"crypto/tls"
+	"crypto/x509"
 	"errors"
+	"fmt"
 	"log"
 	"net"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/micaiahwallace/goscreenmonit/uploadpb"
 	"google.golang.org/protobuf/proto"
 )
 
 type RegisteredClient struct {
+	ID           string
 	Address      string
-	Conn         net.Conn
+	Conn         *FramedConn
 	Register     *uploadpb.Register
-	LatestUpload *uploadpb.ImageUpload
-	Listeners    []*func()
+	Version      uint32
+	Capabilities []string
+	Identity     *CertIdentity
+	CredentialID string
+	Codec        string
+	Quality      int
+
+	mutex          sync.RWMutex
+	latestUpload   *uploadpb.ImageUpload
+	stats          ClientStats
+	lastStatsEvent time.Time
+	decoder        DeltaDecoder
+	commands       map[uint64]*pendingCommand
+	snapshot       *uploadpb.ImageUpload
+	lastHeartbeat  time.Time
+
+	// Serializes start and stop stream messages, which wait for the auth response
+	streamMutex sync.Mutex
+	streamReady bool
+	streaming   bool
 }
 
+// Identity taken from a verified agent client certificate
+type CertIdentity struct {
+	CommonName  string
+	Serial      string
+	Fingerprint string
+}
+
+// Get the agent id a certificate authenticates, its common name unless that can't be used in a url
+func (identity *CertIdentity) AgentID() string {
+	if identity.CommonName == "" || strings.ContainsAny(identity.CommonName, "/?#%") {
+		return identity.Fingerprint
+	}
+	return identity.CommonName
+}
+
+// What to do when an agent id registers while already connected
+type DuplicatePolicy int
+
+const (
+	// Refuse the new connection and keep the existing one
+	DuplicateReject DuplicatePolicy = iota
+
+	// Close the existing connection and register the new one
+	DuplicateReplace
+)
+
 type Server struct {
-	address  string
-	certPath string
-	keyPath  string
-	running  bool
-	quit     chan int
-	clients  map[string]*RegisteredClient
+	commandSeq        uint64 // first for 64-bit atomic alignment
+	address           string
+	certPath          string
+	keyPath           string
+	caPath            string
+	enrollment        *EnrollmentStore
+	duplicates        DuplicatePolicy
+	codecs            []string
+	quality           int
+	onDemand          bool
+	backgroundFPS     float64
+	watchers          watchers
+	heartbeatInterval time.Duration
+	heartbeatTimeout  time.Duration
+	writeTimeout      time.Duration
+	maxUploadFrame    int
+	maxControlFrame   int
+	running           bool
+	quit              chan int
+	registry          *Registry
+	listener          net.Listener
 }
 
 // Create and start a new server
 func NewServer(address, certPath, keyPath string) *Server {
 	server := &Server{
-		address:  address,
-		certPath: certPath,
-		keyPath:  keyPath,
-		running:  false,
-		clients:  make(map[string]*RegisteredClient),
+		address:           address,
+		certPath:          certPath,
+		keyPath:           keyPath,
+		running:           false,
+		codecs:            DefaultCodecPreference,
+		quality:           DefaultImageQuality,
+		heartbeatInterval: DefaultHeartbeatInterval,
+		heartbeatTimeout:  DefaultHeartbeatTimeout,
+		writeTimeout:      DefaultWriteTimeout,
+		maxUploadFrame:    DefaultMaxUploadFrame,
+		maxControlFrame:   DefaultMaxControlFrame,
+		registry:          NewRegistry(),
+		watchers:          watchers{counts: make(map[string]int)},
 	}
 	return server
 }
@@ -50,76 +123,167 @@ func (server *Server) Start(quit chan int) {
 	go server.listen()
 }
 
-// Provide access to client list
-func (server *Server) GetClients() map[string]*RegisteredClient {
-	return server.clients
+// Require agents to present a client certificate signed by the CA bundle at caPath
+func (server *Server) RequireClientCerts(caPath string) {
+	server.caPath = caPath
 }
 
-// Access a single client
-func (server *Server) GetClient(address string) *RegisteredClient {
-	client, ok := server.clients[address]
-	if !ok {
-		return nil
-	}
-	return client
+// Require agents to enroll with a token and authenticate with issued credentials
+func (server *Server) RequireEnrollment(store *EnrollmentStore) {
+	server.enrollment = store
 }
 
-// Register a listener for client image updates
-func (server *Server) AddClientListener(address string, ln *func()) error {
-	client, ok := server.clients[address]
-	if !ok {
-		return errors.New("client doesn't exist")
+// Access the enrollment store, nil when enrollment is disabled
+func (server *Server) GetEnrollment() *EnrollmentStore {
+	return server.enrollment
+}
+
+// Revoke an agent credential and disconnect the agent if it is connected
+func (server *Server) RevokeAgent(credentialID string) error {
+	if server.enrollment == nil {
+		return errors.New("enrollment is disabled")
+	}
+	if err := server.enrollment.RevokeCredential(credentialID); err != nil {
+		return err
+	}
+	for _, client := range server.registry.List() {
+		if client.CredentialID == credentialID {
+			log.Printf("Disconnecting revoked agent: (%s) %s\n", client.Register.GetUser(), client.ID)
+			server.errorConn(client.Conn, client.Capabilities, uploadpb.Error_REVOKED, ErrRevoked.Error(), 0)
+		}
 	}
-	client.Listeners = append(client.Listeners, ln)
 	return nil
 }
 
-// Remove a registered listener to cleanup
-func (server *Server) RemoveClientListener(address string, ln *func()) error {
-	client, ok := server.clients[address]
-	if !ok {
-		return errors.New("client doesn't exist")
-	}
-	for i, listener := range client.Listeners {
-		if listener == ln {
-			client.Listeners = append(client.Listeners[:i], client.Listeners[i+1:]...)
-			break
+// Limit the size of frames agents may upload and of control frames sent to them
+func (server *Server) SetMaxFrameSize(upload, control int) {
+	server.maxUploadFrame = upload
+	server.maxControlFrame = control
+}
+
+// Set the policy applied when an already connected agent id registers again
+func (server *Server) SetDuplicatePolicy(policy DuplicatePolicy) {
+	server.duplicates = policy
+}
+
+// Set the codecs offered to agents in order of preference and the quality for lossy codecs
+func (server *Server) SetCodecs(preference []string, quality int) error {
+	for _, name := range preference {
+		if _, err := CodecByName(name); err != nil {
+			return err
 		}
 	}
+	server.codecs = preference
+	if quality > 0 {
+		server.quality = quality
+	}
 	return nil
 }
 
+// Provide a snapshot of the client list
+func (server *Server) GetClients() []*RegisteredClient {
+	return server.registry.List()
+}
+
+// Access a single client by agent id
+func (server *Server) GetClient(id string) *RegisteredClient {
+	return server.registry.Get(id)
+}
+
+// Subscribe to registry events for an agent id, or all agents when id is empty
+func (server *Server) Subscribe(id string, handler EventHandler) func() {
+	return server.registry.Subscribe(id, handler)
+}
+
 // Start listening on the address
 func (server *Server) listen() {
 
-	// Load tls keypair
-	cert, certerr := tls.LoadX509KeyPair(server.certPath, server.keyPath)
-	if certerr != nil {
-		log.Printf("Unable to load server keypair: %v\n", certerr)
-		server.quit <- 1
-		return
-	}
-	tlsconfig := &tls.Config{Certificates: []tls.Certificate{cert}}
-
-	// Create the socket listener
-	listener, err := tls.Listen("tcp4", server.address, tlsconfig)
-	if err != nil {
-		log.Printf("Unable to start server: %v\n", err)
-		server.quit <- 1
-		return
+	// Create the socket listener unless one was supplied
+	listener := server.listener
+	if listener == nil {
+		tlsconfig, err := server.TLSConfig()
+		if err != nil {
+			log.Printf("Unable to configure tls: %v\n", err)
+			server.quit <- 1
+			return
+		}
+		listener, err = tls.Listen("tcp4", server.address, tlsconfig)
+		if err != nil {
+			log.Printf("Unable to start server: %v\n", err)
+			server.quit <- 1
+			return
+		}
 	}
 
-	// Accept incoming connections
+	// Accept incoming connections until the listener closes
 	for {
 		conn, err := listener.Accept()
 		if err != nil {
-			log.Printf("Incoming connection error: %v\n", err)
-			continue
+			if nerr, ok := err.(net.Error); ok && nerr.Temporary() {
+				log.Printf("Incoming connection error: %v\n", err)
+				continue
+			}
+			log.Printf("Monitor listener stopped: %v\n", err)
+			server.quit <- 1
+			return
 		}
 		go server.handleClient(conn)
 	}
 }
 
+// Accept agents from a listener instead of listening on the server address. Connections
+// may be tls or come from another transport, Start must be called afterwards.
+func (server *Server) SetListener(listener net.Listener) {
+	server.listener = listener
+}
+
+// Build the tls config agents connect with, requiring client certificates in mtls mode
+func (server *Server) TLSConfig() (*tls.Config, error) {
+
+	// Load tls keypair
+	cert, err := tls.LoadX509KeyPair(server.certPath, server.keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("load server keypair: %w", err)
+	}
+	tlsconfig := &tls.Config{Certificates: []tls.Certificate{cert}}
+
+	// Log fingerprints agents can pin
+	if leaf, lerr := x509.ParseCertificate(cert.Certificate[0]); lerr == nil {
+		log.Printf("Server certificate fingerprint: %s\n", CertFingerprint(leaf))
+		log.Printf("Server public key fingerprint: %s\n", SPKIFingerprint(leaf))
+	}
+
+	// Require verified client certificates in mtls mode
+	pool, err := server.clientCAs()
+	if err != nil {
+		return nil, err
+	}
+	if pool != nil {
+		tlsconfig.ClientCAs = pool
+		tlsconfig.ClientAuth = tls.RequireAndVerifyClientCert
+		log.Printf("Requiring agent client certificates signed by %s\n", server.caPath)
+	}
+	return tlsconfig, nil
+}
+
+// Load the CA bundle agent client certificates must chain to, nil unless in mtls mode
+func (server *Server) clientCAs() (*x509.CertPool, error) {
+	if server.caPath == "" {
+		return nil, nil
+	}
+	pool, err := loadCertPool(server.caPath)
+	if err != nil {
+		return nil, fmt.Errorf("load client CA bundle: %w", err)
+	}
+	return pool, nil
+}
+
+// Serve an agent connection accepted by another transport, such as the web server's websocket
+// endpoint, until it closes
+func (server *Server) ServeConn(conn net.Conn) {
+	server.handleClient(conn)
+}
+
 // Handle client connection
 func (server *Server) handleClient(conn net.Conn) {
 
@@ -128,125 +292,380 @@ func (server *Server) handleClient(conn net.Conn) {
 	addr := conn.RemoteAddr().String()
 	log.Printf("New connection: %s\n", addr)
 
-	// Start processing requests
-	indata := make(chan []byte)
-	go ReadCommand(conn, indata)
+	// Complete the tls handshake so client certificates are verified up front
+	if tlsconn, ok := conn.(*tls.Conn); ok {
+		tlsconn.SetDeadline(time.Now().Add(server.heartbeatTimeout))
+		if err := tlsconn.Handshake(); err != nil {
+			log.Printf("TLS handshake failed for %s: %v\n", addr, err)
+			return
+		}
+		tlsconn.SetDeadline(time.Time{})
+	}
 
-	// Keep processing commands until socket closes
-	for msgdata := range indata {
+	// Transports that don't require client certificates themselves still need a verified chain
+	if server.caPath != "" && connIdentity(conn) == nil {
+		log.Printf("Refusing agent %s without a verified client certificate\n", addr)
+		return
+	}
+
+	// Drop connections that go silent, agents must register and then heartbeat in time. Until
+	// they register only control sized frames are accepted.
+	fconn := NewFramedConn(conn, server.maxControlFrame, server.maxControlFrame)
+	fconn.SetReadTimeout(server.heartbeatTimeout)
+	fconn.SetWriteTimeout(server.writeTimeout)
+
+	// Keep processing requests until the socket closes
+	var client *RegisteredClient
+	for {
+		msgdata, err := fconn.ReadFrame()
+		if err != nil {
+			logReadError(addr, err)
+			break
+		}
 		req := &uploadpb.ClientRequest{}
 		if err := proto.Unmarshal(msgdata, req); err != nil {
 			log.Printf("Client request process error: %v\n", err)
 			continue
 		}
-		server.processRequest(req, conn)
+		registered := client != nil
+		client = server.processRequest(req, fconn, client)
+		if !registered && client != nil {
+			fconn.SetMaxReadSize(server.maxUploadFrame)
+		}
+
+		// Agents that don't heartbeat may legitimately go quiet
+		if client != nil && !HasCapability(client.Capabilities, CapHeartbeat) {
+			fconn.SetReadTimeout(0)
+		}
 	}
 
 	// Termination of connection
-	server.deregister(addr)
+	server.deregister(client)
 	log.Printf("Connection closed %s\n", addr)
 }
 
-// Process client request
-func (server *Server) processRequest(req *uploadpb.ClientRequest, conn net.Conn) {
+// Process client request, returning the client registered on this connection
+func (server *Server) processRequest(req *uploadpb.ClientRequest, conn *FramedConn, client *RegisteredClient) *RegisteredClient {
 	switch req.Type {
 
 	// Parse registration and register connection
 	case uploadpb.ClientRequest_REGISTER:
+		if client != nil {
+			log.Printf("Client already registered: %v\n", client.ID)
+			server.errorConn(conn, client.Capabilities, uploadpb.Error_ALREADY_REGISTERED, "connection already registered as "+client.ID, 0)
+			return client
+		}
 		regreq := &uploadpb.Register{}
-		proto.Unmarshal(req.GetRequest(), regreq)
-		server.register(regreq, conn)
+		if !decodeRequest(req, regreq, conn) {
+			return nil
+		}
+		return server.register(regreq, conn)
 
 	// Parse image upload request and process images
 	case uploadpb.ClientRequest_UPLOAD:
 		uploadreq := &uploadpb.ImageUpload{}
-		proto.Unmarshal(req.GetRequest(), uploadreq)
-		server.uploadImages(uploadreq, conn)
+		if !decodeRequest(req, uploadreq, conn) {
+			return client
+		}
+		server.uploadImages(uploadreq, conn, client)
+
+	// Parse command acknowledgement and hand it to the sender
+	case uploadpb.ClientRequest_COMMAND_ACK:
+		ack := &uploadpb.CommandAck{}
+		if !decodeRequest(req, ack, conn) {
+			return client
+		}
+		server.handleCommandAck(ack, client)
+
+	// Answer keepalive pings
+	case uploadpb.ClientRequest_PING:
+		ping := &uploadpb.Heartbeat{}
+		if !decodeRequest(req, ping, conn) {
+			return client
+		}
+		server.handlePing(ping, conn, client)
+	}
+
+	return client
+}
+
+// Decode the payload of a client request, dropping frames that don't parse
+func decodeRequest(req *uploadpb.ClientRequest, msg proto.Message, conn *FramedConn) bool {
+	if err := proto.Unmarshal(req.GetRequest(), msg); err != nil {
+		log.Printf("Dropping malformed %v request from %s: %v\n", req.GetType(), conn.RemoteAddr(), err)
+		return false
 	}
+	return true
 }
 
 // Register a new client
-func (server *Server) register(req *uploadpb.Register, conn net.Conn) {
+func (server *Server) register(req *uploadpb.Register, conn *FramedConn) *RegisteredClient {
 
 	address := conn.RemoteAddr().String()
 
-	// Check if client registration exists
-	if _, ok := server.clients[address]; ok {
-		log.Printf("Client already registered: %v\n", address)
-		server.quitConn(address, conn)
-		return
+	// Negotiate protocol version with the agent
+	version, verr := NegotiateVersion(req.GetProtocolVersion())
+	if verr != nil {
+		log.Printf("Rejecting client %s (agent %s): %v\n", address, req.GetAgentVersion(), verr)
+		message := fmt.Sprintf("%v, server supports v%d-v%d", verr, MinProtocolVersion, ProtocolVersion)
+		server.errorConn(conn, req.GetCapabilities(), uploadpb.Error_UNSUPPORTED_VERSION, message, 0)
+		return nil
+	}
+	capabilities := NegotiateCapabilities(Capabilities, req.GetCapabilities())
+
+	// Choose the image codec, older agents only send zlib wrapped png
+	codec := NegotiateCodec(server.codecs, req.GetCodecs())
+	if codec == "" {
+		codec = pngZlibCodec{}.Name()
+	}
+
+	// Authenticate or enroll the agent when enrollment is required
+	var credentialID string
+	var issued *uploadpb.Credential
+	if server.enrollment != nil {
+		id, secret, aerr := server.enrollment.Authenticate(&RegistrationAuth{
+			Host:             req.GetHost(),
+			User:             req.GetUser(),
+			Token:            req.GetEnrollmentToken(),
+			CredentialID:     req.GetCredential().GetId(),
+			CredentialSecret: req.GetCredential().GetSecret(),
+		})
+		if aerr != nil {
+			if aerr == ErrRevoked {
+				log.Printf("Revoked agent attempted to register: %s (%s)\n", address, id)
+			} else {
+				log.Printf("Rejecting client %s: %v\n", address, aerr)
+			}
+			code := enrollmentErrorCode(aerr)
+			retryAfter := time.Duration(0)
+			if code == uploadpb.Error_INTERNAL {
+				retryAfter = internalRetryAfter
+			}
+			server.errorConn(conn, req.GetCapabilities(), code, aerr.Error(), retryAfter)
+			return nil
+		}
+		credentialID = id
+		if secret != "" {
+			log.Printf("Enrolled new agent %s as %s\n", address, id)
+			issued = &uploadpb.Credential{Id: id, Secret: secret}
+		}
+	}
+
+	// Attach the verified certificate identity if one was presented
+	identity := connIdentity(conn.NetConn())
+	if identity != nil {
+		log.Printf("Client %s presented certificate %s (%s)\n", address, identity.CommonName, identity.Fingerprint)
+	}
+
+	// Authenticated agents are known by their credential or certificate so they can't claim
+	// another agent's id, others by the id they send and then their address
+	id := req.GetAgentId()
+	switch {
+	case credentialID != "":
+		id = credentialID
+	case identity != nil:
+		id = identity.AgentID()
+	}
+	if id == "" {
+		id = address
+	}
+	if claimed := req.GetAgentId(); claimed != "" && claimed != id {
+		log.Printf("Client %s sent agent id %s, registering it as %s\n", address, claimed, id)
 	}
 
 	// Add connection to registered clients
-	log.Printf("Registering client: (%s) %s\n", req.GetUser(), address)
-	server.clients[address] = &RegisteredClient{
-		Address:   address,
-		Conn:      conn,
-		Register:  req,
-		Listeners: make([]*func(), 0),
+	log.Printf("Registering client: (%s) %s from %s agent %s protocol v%d codec %s\n", req.GetUser(), id, address, req.GetAgentVersion(), version, codec)
+	client := &RegisteredClient{
+		ID:           id,
+		Address:      address,
+		Conn:         conn,
+		Register:     req,
+		Version:      version,
+		Capabilities: capabilities,
+		Identity:     identity,
+		CredentialID: credentialID,
+		Codec:        codec,
+		Quality:      server.quality,
 	}
+	client.decoder.Quality = server.quality
 
 	// Send auth response
-	authresp, err := CreateResponse(uploadpb.ServerResponse_AUTHENTICATED)
+	authresp, err := CreateAuthenticated(&uploadpb.Authenticated{
+		ProtocolVersion:     version,
+		Capabilities:        capabilities,
+		Credential:          issued,
+		Codec:               codec,
+		Quality:             uint32(server.quality),
+		OnDemand:            server.onDemand && HasCapability(capabilities, CapStream),
+		BackgroundFps:       float32(server.backgroundFPS),
+		HeartbeatIntervalMs: uint32(server.heartbeatInterval / time.Millisecond),
+	})
 	if err != nil {
-		log.Printf("Unable to create auth response, quitting connection: %v\n", err)
-		server.quitConn(address, conn)
-		return
+		log.Printf("Unable to create auth response, closing connection: %v\n", err)
+		server.errorConn(conn, req.GetCapabilities(), uploadpb.Error_INTERNAL, "unable to create auth response", internalRetryAfter)
+		return nil
 	}
-	SendMessage(authresp, conn)
+
+	// Apply the duplicate policy when the agent id is already connected
+	existing, rerr := server.registry.Add(client, server.duplicates)
+	if rerr != nil {
+		log.Printf("Rejecting duplicate agent %s from %s, already connected from %s\n", id, address, existing.Address)
+
+		// A half-open existing connection is dropped within the heartbeat timeout
+		server.errorConn(conn, req.GetCapabilities(), uploadpb.Error_DUPLICATE_AGENT, rerr.Error(), server.heartbeatTimeout)
+		return nil
+	}
+	if existing != nil {
+		log.Printf("Replaced agent %s connection %s with %s\n", id, existing.Address, address)
+		existing.Conn.Close()
+	}
+
+	conn.WriteFrame(authresp)
+
+	// On-demand agents start idle unless someone is already watching
+	server.startStreamControl(client)
+	return client
 }
 
-// Deregister a client
-func (server *Server) deregister(address string) {
-	if c, ok := server.clients[address]; ok {
-		log.Printf("Deregistering client: (%s) %s\n", c.Register.GetUser(), address)
-		delete(server.clients, address)
+// Deregister a client, unless its agent id has since been taken over by a newer connection
+func (server *Server) deregister(client *RegisteredClient) {
+	if client == nil {
+		return
+	}
+	if server.registry.Remove(client) {
+		log.Printf("Deregistering client: (%s) %s\n", client.Register.GetUser(), client.ID)
 	}
 }
 
-// Send quit message to connection
-func (server *Server) quitConn(address string, conn net.Conn) {
+// Send quit message to connection, the registration is removed once the connection closes
+func (server *Server) quitConn(conn *FramedConn) {
 
 	// Send quit response
 	quitres, qerr := CreateResponse(uploadpb.ServerResponse_QUIT)
 	if qerr != nil {
 		log.Printf("Unable to create quit response. %v\n", qerr)
-		conn.Close()
 	} else {
-		SendMessage(quitres, conn)
+		conn.WriteFrame(quitres)
+	}
+	conn.Close()
+}
+
+// Send rejection message to connection and close it
+func (server *Server) rejectConn(conn *FramedConn, reason string) {
+
+	// Send rejection response
+	rejres, rerr := CreateRejection(reason)
+	if rerr != nil {
+		log.Printf("Unable to create rejection response. %v\n", rerr)
+	} else {
+		conn.WriteFrame(rejres)
 	}
+	conn.Close()
+}
 
-	// Delete registration if available
-	server.deregister(address)
+// Ask the agent to send its next frame as a keyframe
+func (server *Server) requestKeyframe(conn *FramedConn) {
+	keyres, err := CreateResponse(uploadpb.ServerResponse_REQUEST_KEYFRAME)
+	if err != nil {
+		log.Printf("Unable to create keyframe request. %v\n", err)
+		return
+	}
+	conn.WriteFrame(keyres)
 }
 
 // Process image uploads
-func (server *Server) uploadImages(req *uploadpb.ImageUpload, conn net.Conn) {
+func (server *Server) uploadImages(req *uploadpb.ImageUpload, conn *FramedConn, client *RegisteredClient) {
 
-	// Get reference to client
-	address := conn.RemoteAddr().String()
-	client, ok := server.clients[address]
-	if !ok {
-		log.Printf("Received image upload from unregistered client: %v\n", address)
+	// Uploads are only accepted after registration
+	if client == nil {
+		log.Printf("Received image upload from unregistered client: %v\n", conn.RemoteAddr().String())
+		server.sendError(conn, uploadpb.Error_NOT_REGISTERED, "upload before registration", 0)
+		return
 	}
 
-	// Decode images with zlib
-	for i, encim := range req.Images {
+	size := proto.Size(req)
 
-		decim, err := DecodeImage(encim)
-		if err != nil {
-			log.Printf("Unable to decode images: %v\n", err)
+	// Backfilled frames are spooled full images, never deltas against the live stream
+	if req.GetBackfill() && req.GetFrameType() != uploadpb.ImageUpload_FULL {
+		log.Printf("Dropping backfilled %v upload from %s\n", req.GetFrameType(), client.ID)
+		return
+	}
+
+	// Reconstruct full frames from keyframes and deltas
+	if req.GetFrameType() != uploadpb.ImageUpload_FULL {
+		images, formats, derr := client.decoder.Apply(req)
+		if derr != nil {
+
+			// Deltas keep arriving until the agent sees the request, so only ask once
+			if client.decoder.ShouldRequestKeyframe() {
+				log.Printf("Unable to apply %v upload from %s, requesting keyframe: %v\n", req.GetFrameType(), client.ID, derr)
+				server.requestKeyframe(conn)
+			}
 			return
 		}
+		req.Images = images
+		req.Formats = formats
+	} else {
+
+		// Convert full images to formats viewers display
+		images := make([][]byte, len(req.GetImages()))
+		formats := make([]uploadpb.ImageFormat, len(images))
+		for i, encim := range req.GetImages() {
+			if len(encim) == 0 {
+				formats[i] = imageFormat(req.GetFormats(), i)
+				continue
+			}
+			var err error
+			images[i], formats[i], err = ViewerImage(encim, imageFormat(req.GetFormats(), i))
+			if err != nil {
+				log.Printf("Unable to decode images: %v\n", err)
+				return
+			}
+		}
+		req.Images = images
+		req.Formats = formats
+	}
+	req.Deltas = nil
 
-		req.Images[i] = decim
+	// Frames captured while disconnected only go to the recording store
+	if req.GetBackfill() {
+		server.registry.RecordBackfill(client, req)
+		return
 	}
 
-	// Store image for later retrieval
-	client.LatestUpload = req
+	// Keep requested snapshots apart from the live stream
+	if req.GetSnapshot() {
+		log.Printf("Received snapshot from %s\n", client.ID)
+		client.setSnapshot(req)
+		return
+	}
+
+	// Store image for later retrieval and notify subscribers
+	server.registry.RecordUpload(client, req, size)
+}
+
+// Connections that know their tls state, either tls itself or a transport running over it
+type connectionStater interface {
+	ConnectionState() tls.ConnectionState
+}
+
+// Get the identity of a verified client certificate on a connection
+func connIdentity(conn net.Conn) *CertIdentity {
+	stater, ok := conn.(connectionStater)
+	if !ok {
+		return nil
+	}
+
+	// Only trust certificates that passed chain verification
+	state := stater.ConnectionState()
+	if len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	cert := state.VerifiedChains[0][0]
 
-	// Notify listeners of latest image
-	for _, listener := range client.Listeners {
-		(*listener)()
+	return &CertIdentity{
+		CommonName:  cert.Subject.CommonName,
+		Serial:      cert.SerialNumber.String(),
+		Fingerprint: CertFingerprint(cert),
 	}
 }
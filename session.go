@@ -1,223 +1,978 @@
 package goscreenmonit
 
 import (
-	"bytes"
+	"context"
 	"crypto/tls"
-	"image/png"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"io/ioutil"
 	"log"
 	"math"
 	"net"
+	"os"
+	"sync"
 	"time"
 
 	"github.com/micaiahwallace/goscreenmonit/uploadpb"
 	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Longest wait between checks while paused or idle
+const idleCheckInterval = time.Second
+
+// Retry bounds after the server fails certificate verification
+const (
+	verifyRetryMin = 5 * time.Second
+	verifyRetryMax = 5 * time.Minute
 )
 
 type Session struct {
-	address      string
-	socket       net.Conn
-	quit         chan int
-	isRecording  bool
-	fps          int
-	lastImgStamp int64
-	running      bool
-	registration Registration
+	servers           []string
+	conn              *FramedConn
+	capturer          Capturer
+	captureMutex      sync.Mutex
+	encoder           *DeltaEncoder
+	activity          *ActivityMonitor
+	bandwidth         *BandwidthController
+	mutex             sync.Mutex
+	state             SessionState
+	stateSubs         map[*stateSubscription]struct{}
+	ctx               context.Context
+	cancel            context.CancelFunc
+	goroutines        sync.WaitGroup
+	done              chan struct{}
+	started           bool
+	finished          bool
+	exitCode          int
+	codec             Codec
+	quality           int
+	paused            bool
+	streaming         bool
+	backgroundFPS     float64
+	wake              chan struct{}
+	heartbeatInterval time.Duration
+	heartbeatTimeout  time.Duration
+	writeTimeout      time.Duration
+	maxUploadFrame    int
+	maxControlFrame   int
+	status            SessionStatus
+	statusPath        string
+	statusMutex       sync.Mutex
+	failingBack       bool
+	failbackInterval  time.Duration
+	serverError       *uploadpb.Error
+	spool             *Spool
+	spoolFPS          float64
+	draining          bool
+	fps               int
+	lastImgStamp      int64
+	registration      Registration
+	version           uint32
+	capabilities      []string
+	clientCerts       []tls.Certificate
+	rootCAs           *x509.CertPool
+	pins              []string
+	enrollToken       string
+	credPath          string
+	credential        *uploadpb.Credential
 }
 
 type Registration struct {
-	Host string
-	User string
+	AgentID string
+	Host    string
+	User    string
 }
 
-// Create a new session that automatically connects to the server
-func NewSession(address string, fps int, registration Registration) *Session {
+// Create a new session that automatically connects to the server, capturing with the
+// given capturer or the real displays when nil
+func NewSession(address string, fps int, registration Registration, capturer Capturer) *Session {
 
 	if fps <= 0 {
 		log.Fatalf("FPS must be greater than 0")
 	}
+	if capturer == nil {
+		capturer = ScreenCapturer{}
+	}
 
 	sess := &Session{
-		address:      address,
-		fps:          fps,
-		isRecording:  false,
-		running:      false,
-		lastImgStamp: 0,
-		registration: registration,
+		servers:           []string{address},
+		capturer:          capturer,
+		encoder:           NewDeltaEncoder(),
+		activity:          NewActivityMonitor(),
+		bandwidth:         NewBandwidthController(0),
+		streaming:         true,
+		wake:              make(chan struct{}, 1),
+		stateSubs:         make(map[*stateSubscription]struct{}),
+		done:              make(chan struct{}),
+		codec:             pngZlibCodec{},
+		fps:               fps,
+		heartbeatInterval: DefaultHeartbeatInterval,
+		heartbeatTimeout:  DefaultHeartbeatTimeout,
+		writeTimeout:      DefaultWriteTimeout,
+		failbackInterval:  DefaultFailbackInterval,
+		maxUploadFrame:    DefaultMaxUploadFrame,
+		maxControlFrame:   DefaultMaxControlFrame,
+		lastImgStamp:      0,
+		registration:      registration,
 	}
 	return sess
 }
 
-// Start a new session
-func (session *Session) Start(quit chan int) {
-	if session.running {
-		log.Println("Already started session.")
+// Present a client certificate to the server for mutual tls
+func (session *Session) SetClientCertificate(certPath, keyPath string) error {
+	cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		return err
+	}
+	session.clientCerts = []tls.Certificate{cert}
+	return nil
+}
+
+// Verify the server certificate against a CA bundle instead of the system roots
+func (session *Session) SetServerCA(caPath string) error {
+	pool, err := loadCertPool(caPath)
+	if err != nil {
+		return err
+	}
+	session.rootCAs = pool
+	return nil
+}
+
+// Only accept servers whose certificate or public key matches a sha256 pin
+func (session *Session) PinServerCert(pins []string) error {
+	normalized := make([]string, 0, len(pins))
+	for _, pin := range pins {
+		npin, err := normalizePin(pin)
+		if err != nil {
+			return err
+		}
+		normalized = append(normalized, npin)
+	}
+	session.pins = normalized
+	return nil
+}
+
+// Create the tls config used to dial the server
+func (session *Session) tlsConfig() *tls.Config {
+	tlsconf := &tls.Config{
+		Certificates: session.clientCerts,
+		RootCAs:      session.rootCAs,
 	}
-	session.running = true
-	session.quit = quit
-	go session.connect()
+
+	// A pin replaces chain verification unless a CA bundle was also supplied
+	if len(session.pins) > 0 {
+		tlsconf.VerifyPeerCertificate = verifyPins(session.pins)
+		tlsconf.InsecureSkipVerify = session.rootCAs == nil
+	}
+
+	return tlsconf
 }
 
-// Stop the session
+// Capture at the session fps but only upload when more than threshold (0-1) of the screen
+// changed, dropping to idleFPS uploads while the screen is static
+func (session *Session) SetAdaptive(idleFPS, threshold float64) {
+	session.activity.SetAdaptive(idleFPS, threshold)
+}
+
+// Cap uploads at bytes per second, lowering quality, frame rate and resolution to stay under it
+func (session *Session) SetBandwidthLimit(bytesPerSecond int64) {
+	session.bandwidth = NewBandwidthController(bytesPerSecond)
+}
+
+// Enroll with a token on first connect and keep the issued credential at credPath
+func (session *Session) SetEnrollment(token, credPath string) error {
+	session.enrollToken = token
+	session.credPath = credPath
+
+	// Load a previously issued credential
+	cred, err := loadCredential(credPath)
+	if err != nil {
+		return err
+	}
+	session.credential = cred
+	return nil
+}
+
+// Start connecting to the servers and recording until the context is cancelled, Stop is
+// called or the server ends the session
+func (session *Session) Start(ctx context.Context) error {
+	session.mutex.Lock()
+	if session.started {
+		session.mutex.Unlock()
+		return errors.New("session already started")
+	}
+	session.started = true
+	session.ctx, session.cancel = context.WithCancel(ctx)
+	session.mutex.Unlock()
+
+	session.spawn(session.connect)
+	session.spawn(session.record)
+
+	// Report stopped once every goroutine is done
+	go func() {
+		session.goroutines.Wait()
+		session.setState(StateStopped)
+		close(session.done)
+	}()
+	return nil
+}
+
+// Stop the session, closing the connection and waiting for every goroutine to finish
 func (session *Session) Stop() {
-	session.running = false
-	session.quit <- 0
+	session.mutex.Lock()
+	started := session.started
+	session.mutex.Unlock()
+	if !started {
+		return
+	}
+	session.finish(0)
+	<-session.done
+}
+
+// Get a channel that is closed once the session has stopped
+func (session *Session) Done() <-chan struct{} {
+	return session.done
+}
+
+// Get the exit code the session ended with, non-zero when the server refused the agent
+func (session *Session) ExitCode() int {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+	return session.exitCode
 }
 
-// Start a retry loop to connect to the server
+// End the session with an exit code, only the first call has an effect
+func (session *Session) finish(code int) {
+	session.mutex.Lock()
+	if session.finished {
+		session.mutex.Unlock()
+		return
+	}
+	session.finished = true
+	session.exitCode = code
+	session.mutex.Unlock()
+
+	session.setState(StateStopping)
+	session.cancel()
+}
+
+// Run a goroutine that Stop waits for
+func (session *Session) spawn(fn func()) {
+	session.goroutines.Add(1)
+	go func() {
+		defer session.goroutines.Done()
+		fn()
+	}()
+}
+
+// Wait for a duration, returning false if the session was stopped meanwhile
+func (session *Session) wait(d time.Duration) bool {
+	select {
+	case <-session.ctx.Done():
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
+
+// Retry connecting to the servers until the session stops
 func (session *Session) connect() {
 
-	for {
+	backoff := NewBackoff(reconnectMin, reconnectMax)
+	verifyBackoff := NewBackoff(verifyRetryMin, verifyRetryMax)
 
-		// Dial out to server
-		tlsconf := &tls.Config{
+	for session.ctx.Err() == nil {
 
-			// @TODO: Fix security here
-			InsecureSkipVerify: true,
-		}
-		conn, err := tls.Dial("tcp4", session.address, tlsconf)
+		// Dial out to the first reachable server
+		session.setState(StateConnecting)
+		conn, index, err := session.dialServers(session.ctx)
 		if err != nil {
-			log.Printf("Unable to connect to server, retry in 5 seconds: %v\n", err)
-			time.Sleep(time.Second * 5)
+			if session.ctx.Err() != nil {
+				break
+			}
+
+			// Back off harder when the server identity can't be verified
+			wait := backoff.Next()
+			if isVerificationError(err) {
+				wait = verifyBackoff.Next()
+			}
+			log.Printf("Unable to reach any server (attempt %d), retry in %v.\n", backoff.Attempt(), wait.Round(time.Millisecond))
+			session.setStatus(func(status *SessionStatus) {
+				status.Attempt = backoff.Attempt()
+				status.RetryAt = time.Now().Add(wait)
+				status.LastError = err.Error()
+			})
+			session.setState(StateDisconnected)
+			session.wait(wait)
 			continue
 		}
+		verifyBackoff.Reset()
+		address := session.servers[index]
+		connected := time.Now()
+		log.Printf("Connected to server %s.\n", address)
+		session.setStatus(func(status *SessionStatus) {
+			status.Server = address
+			status.FailedOver = index > 0
+			status.ConnectedSince = connected
+			status.RetryAt = time.Time{}
+			status.LastError = ""
+		})
 
-		// store the connection
-		session.socket = conn
-		session.running = true
-
-		// register with the server
-		session.register()
+		// Run the connection until it closes
+		session.serve(conn, index)
+		if session.ctx.Err() != nil {
+			break
+		}
 
-		// Start processing commands
-		indata := make(chan []byte)
-		go ReadCommand(session.socket, indata)
+		// Fail back right away, otherwise only start the backoff over after a stable connection
+		session.mutex.Lock()
+		failingBack := session.failingBack
+		session.failingBack = false
+		session.mutex.Unlock()
+		if failingBack {
+			continue
+		}
+		if time.Since(connected) >= stableConnection {
+			backoff.Reset()
+		}
+		wait := backoff.Next()
+		lastError := "connection closed"
 
-		// Keep processing commands until socket closes
-		for msgdata := range indata {
-			response := &uploadpb.ServerResponse{}
-			if err := proto.Unmarshal(msgdata, response); err != nil {
-				log.Printf("Server message process error: %v\n", err)
-				continue
+		// Wait at least as long as the server asked
+		if serr := session.takeServerError(); serr != nil {
+			if retryAfter := time.Duration(serr.GetRetryAfterMs()) * time.Millisecond; retryAfter > wait {
+				wait = retryAfter
 			}
-			session.processResponse(response)
+			lastError = describeServerError(serr)
 		}
+		log.Printf("Connection to server %s closed. Connecting in %v.\n", address, wait.Round(time.Millisecond))
+		session.setStatus(func(status *SessionStatus) {
+			status.Attempt = backoff.Attempt()
+			status.RetryAt = time.Now().Add(wait)
+			status.LastError = lastError
+		})
+		session.setState(StateDisconnected)
+		session.wait(wait)
+	}
+
+	session.setState(StateStopping)
+}
+
+// Limit the size of uploads sent to the server and of control frames accepted from it
+func (session *Session) SetMaxFrameSize(upload, control int) {
+	session.maxUploadFrame = upload
+	session.maxControlFrame = control
+}
+
+// Register over a new connection and process server messages until it closes. Goroutines
+// working on the connection stop with it, so nothing writes to a stale socket.
+func (session *Session) serve(conn net.Conn, index int) {
+
+	// A server that stops answering is noticed by the read timeout
+	fconn := NewFramedConn(conn, session.maxControlFrame, session.maxUploadFrame)
+	fconn.SetReadTimeout(session.heartbeatTimeout)
+	fconn.SetWriteTimeout(session.writeTimeout)
+	ctx, cancel := context.WithCancel(session.ctx)
+	session.mutex.Lock()
+	session.conn = fconn
+	session.mutex.Unlock()
+	defer func() {
+		session.mutex.Lock()
+		session.conn = nil
+		session.mutex.Unlock()
+		cancel()
+	}()
 
-		// connection was closed
-		session.running = false
-		log.Println("Connection to server closed. Connecting in 3 seconds.")
-		time.Sleep(time.Second * 3)
+	// Closing the socket unblocks the reader when the session stops
+	session.spawn(func() {
+		<-ctx.Done()
+		fconn.Close()
+	})
+
+	// Return to a preferred server once it comes back
+	if index > 0 && session.failbackInterval > 0 {
+		session.spawn(func() { session.watchFailback(ctx, fconn, index) })
+	}
+
+	// register with the server
+	session.setState(StateRegistering)
+	if err := session.register(fconn); err != nil {
+		log.Printf("Unable to register with the server: %v\n", err)
+		return
+	}
+
+	// Keep processing commands until socket closes
+	for {
+		msgdata, err := fconn.ReadFrame()
+		if err != nil {
+			logReadError(conn.RemoteAddr().String(), err)
+			return
+		}
+		response := &uploadpb.ServerResponse{}
+		if err := proto.Unmarshal(msgdata, response); err != nil {
+			log.Printf("Server message process error: %v\n", err)
+			continue
+		}
+		session.processResponse(ctx, fconn, response)
 	}
 }
 
-// Process inbound server messages
-func (session *Session) processResponse(response *uploadpb.ServerResponse) {
+// Process inbound server messages received on a connection
+func (session *Session) processResponse(ctx context.Context, conn *FramedConn, response *uploadpb.ServerResponse) {
 	switch response.Type {
 
 	// Client is authenticated
 	case uploadpb.ServerResponse_AUTHENTICATED:
-		log.Println("Server registration successful, begin recording.")
-		go session.record()
+		auth := &uploadpb.Authenticated{}
+		if err := proto.Unmarshal(response.GetResponse(), auth); err != nil {
+			log.Printf("Unable to parse authentication response: %v\n", err)
+			return
+		}
+
+		// Verify the server picked a version we can speak
+		version := peerVersion(auth.GetProtocolVersion())
+		if version < MinProtocolVersion || version > ProtocolVersion {
+			log.Printf("Server negotiated unsupported protocol v%d (supported v%d-v%d), quitting now.\n", version, MinProtocolVersion, ProtocolVersion)
+			session.finish(1)
+			return
+		}
+		capabilities := auth.GetCapabilities()
+		session.mutex.Lock()
+		session.version = version
+		session.capabilities = capabilities
+		session.mutex.Unlock()
+
+		// Encode with the codec the server chose, older servers only understand zlib wrapped png
+		codec := Codec(pngZlibCodec{})
+		if auth.GetCodec() != "" {
+			chosen, err := CodecByName(auth.GetCodec())
+			if err != nil {
+				log.Printf("Server chose unsupported codec, quitting now: %v\n", err)
+				session.finish(1)
+				return
+			}
+			codec = chosen
+		}
+
+		// Keep a newly issued credential for future connections
+		if cred := auth.GetCredential(); cred != nil {
+			log.Printf("Enrolled with server as agent %s.\n", cred.GetId())
+			session.credential = cred
+			if session.credPath != "" {
+				if err := saveCredential(session.credPath, cred); err != nil {
+					log.Printf("Unable to save agent credential: %v\n", err)
+				}
+			}
+		}
+
+		// A new connection has no reference frame on the server
+		session.setCodec(codec, int(auth.GetQuality()))
+
+		// On-demand servers start agents idle until someone watches, when they can control the stream
+		session.setStreaming(!auth.GetOnDemand() || !HasCapability(capabilities, CapStream), float64(auth.GetBackgroundFps()))
+
+		// Ping at least as often as the server expects, older servers never answer so the connection may idle
+		if HasCapability(capabilities, CapHeartbeat) {
+			interval := session.heartbeatInterval
+			if serverInterval := time.Duration(auth.GetHeartbeatIntervalMs()) * time.Millisecond; serverInterval > 0 && serverInterval < interval {
+				interval = serverInterval
+			}
+			session.spawn(func() { session.heartbeat(ctx, conn, interval) })
+		} else {
+			conn.SetReadTimeout(0)
+		}
+
+		// Upload what was captured while disconnected
+		if session.spool != nil && HasCapability(capabilities, CapBackfill) {
+			session.spawn(func() { session.drainSpool(ctx, conn) })
+		}
+
+		log.Printf("Server registration successful (protocol v%d, capabilities %v, codec %s), begin recording.\n", version, capabilities, codec.Name())
+		if session.isPaused() {
+			session.setState(StatePaused)
+		} else {
+			session.setState(StateStreaming)
+		}
+		session.wakeUp()
+
+	// Server refused the registration
+	case uploadpb.ServerResponse_REJECTED:
+		rej := &uploadpb.Rejected{}
+		proto.Unmarshal(response.GetResponse(), rej)
+		log.Printf("Server rejected registration: %s (server supports v%d-v%d, agent %s speaks v%d), quitting now.\n",
+			rej.GetReason(), rej.GetMinProtocolVersion(), rej.GetMaxProtocolVersion(), AgentVersion, ProtocolVersion)
+		session.finish(1)
+
+	// Server refused a request, reacting depends on the reason
+	case uploadpb.ServerResponse_ERROR:
+		serr := &uploadpb.Error{}
+		if err := proto.Unmarshal(response.GetResponse(), serr); err != nil {
+			log.Printf("Unable to parse server error: %v\n", err)
+			return
+		}
+		session.handleError(conn, serr)
+
+	// A viewer started watching, stream at the full rate starting from a keyframe
+	case uploadpb.ServerResponse_START_STREAM:
+		log.Println("Server started the stream.")
+		session.encoder.RequestKeyframe()
+		session.mutex.Lock()
+		background := session.backgroundFPS
+		session.mutex.Unlock()
+		session.setStreaming(true, background)
+
+	// The last viewer left, idle or sample in the background
+	case uploadpb.ServerResponse_STOP_STREAM:
+		ctl := &uploadpb.StreamControl{}
+		proto.Unmarshal(response.GetResponse(), ctl)
+		log.Printf("Server stopped the stream (background %v fps).\n", ctl.GetBackgroundFps())
+		session.setStreaming(false, float64(ctl.GetBackgroundFps()))
+
+	// Server answered a ping, the read already pushed out the deadline
+	case uploadpb.ServerResponse_PONG:
+
+	// Server lost the reference frame for deltas
+	case uploadpb.ServerResponse_REQUEST_KEYFRAME:
+		log.Println("Server requested a keyframe.")
+		session.encoder.RequestKeyframe()
+
+	// Operator command to apply and acknowledge
+	case uploadpb.ServerResponse_COMMAND:
+		cmd := &uploadpb.Command{}
+		if err := proto.Unmarshal(response.GetResponse(), cmd); err != nil {
+			log.Printf("Unable to parse server command: %v\n", err)
+			return
+		}
+		session.handleCommand(ctx, conn, cmd)
 
 	// Client should quit now
 	case uploadpb.ServerResponse_QUIT:
 		log.Println("Quit command received, quitting now.")
-		session.quit <- 0
+		session.finish(0)
 	}
 
 }
 
-// Register user recording sessi5on with the server
-func (session *Session) register() error {
+// Apply a server command received on a connection and acknowledge it
+func (session *Session) handleCommand(ctx context.Context, conn *FramedConn, cmd *uploadpb.Command) {
+	log.Printf("Received %v command %d.\n", cmd.GetAction(), cmd.GetId())
 
-	// Create registration
-	cmd, err := CreateRegistration(session.registration.Host, session.registration.User)
+	// Snapshots wait on the bandwidth limit, so they upload and acknowledge off the read loop to
+	// keep heartbeats flowing
+	if cmd.GetAction() == uploadpb.Command_SNAPSHOT {
+		session.spawn(func() {
+			session.ackCommand(conn, cmd, session.snapshot(ctx, conn))
+		})
+		return
+	}
+	session.ackCommand(conn, cmd, session.applyCommand(cmd))
+}
+
+// Acknowledge a command with the outcome of applying it
+func (session *Session) ackCommand(conn *FramedConn, cmd *uploadpb.Command, err error) {
 	if err != nil {
-		return err
+		log.Printf("Unable to apply %v command: %v\n", cmd.GetAction(), err)
+	}
+	ack, aerr := CreateCommandAck(cmd.GetId(), err)
+	if aerr != nil {
+		log.Printf("Unable to create command acknowledgement: %v\n", aerr)
+		return
+	}
+	if serr := conn.WriteFrame(ack); serr != nil {
+		log.Printf("Unable to send command acknowledgement: %v\n", serr)
 	}
 
-	// Send registration to server
-	log.Println("Registering with the server.")
-	SendMessage(cmd, session.socket)
+	// Drop the connection only once the acknowledgement is sent, the connect loop dials again
+	if err == nil && cmd.GetAction() == uploadpb.Command_RECONNECT {
+		conn.Close()
+	}
+}
+
+// Apply a server command to the session
+func (session *Session) applyCommand(cmd *uploadpb.Command) error {
+	switch cmd.GetAction() {
+	case uploadpb.Command_SET_FPS:
+		if cmd.GetFps() == 0 {
+			return fmt.Errorf("fps must be greater than 0")
+		}
+		session.mutex.Lock()
+		session.fps = int(cmd.GetFps())
+		session.mutex.Unlock()
+
+	case uploadpb.Command_PAUSE, uploadpb.Command_RESUME:
+		paused := cmd.GetAction() == uploadpb.Command_PAUSE
+		session.mutex.Lock()
+		session.paused = paused
+		session.mutex.Unlock()
+		if paused {
+			session.setState(StatePaused)
+		} else {
+			session.setState(StateStreaming)
+		}
+		session.wakeUp()
+
+	case uploadpb.Command_SET_CODEC:
+		codec, err := CodecByName(cmd.GetCodec())
+		if err != nil {
+			return err
+		}
+		_, quality := session.getCodec()
+		if cmd.GetQuality() > 0 {
+			quality = int(cmd.GetQuality())
+		}
+		session.setCodec(codec, quality)
+
+	case uploadpb.Command_RECONNECT:
+
+	default:
+		return fmt.Errorf("unsupported command %v", cmd.GetAction())
+	}
 	return nil
 }
 
-// Begin recording screen and sending data to server
-func (session *Session) record() {
+// Upload every display at full resolution and the negotiated quality, outside the live stream
+func (session *Session) snapshot(ctx context.Context, conn *FramedConn) error {
+	frames, displays, _ := session.capture()
+	codec, quality := session.getCodec()
+	images, formats, err := encodeFrames(frames, codec, quality)
+	if err != nil {
+		return err
+	}
+	msg, err := CreateUploadMessage(&uploadpb.ImageUpload{
+		Images:    images,
+		Formats:   formats,
+		Displays:  displays,
+		Timestamp: timestamppb.Now(),
+		Snapshot:  true,
+	})
+	if err != nil {
+		return err
+	}
+	if err := session.bandwidth.Wait(ctx, len(msg)); err != nil {
+		return err
+	}
+	return conn.WriteFrame(msg)
+}
 
-	// Check recording status
-	if session.isRecording {
-		log.Println("Already started record session.")
-		return
+// Use a codec and quality for uploads, restarting deltas from a keyframe
+func (session *Session) setCodec(codec Codec, quality int) {
+	session.mutex.Lock()
+	session.codec = codec
+	session.quality = quality
+	session.mutex.Unlock()
+	session.encoder.SetCodec(codec, quality)
+}
+
+// Get the codec and quality used for uploads
+func (session *Session) getCodec() (Codec, int) {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+	return session.codec, session.quality
+}
+
+// Get the connection to upload on, nil unless registered with a server
+func (session *Session) uploadConn() *FramedConn {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+	if !session.state.Online() {
+		return nil
 	}
+	return session.conn
+}
 
-	// Setup recording session
-	session.isRecording = true
+// Get the capabilities negotiated with the server
+func (session *Session) getCapabilities() []string {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+	return session.capabilities
+}
 
-	// Take screenshots and send to server per fps
-	for {
+// Check if the server paused capturing
+func (session *Session) isPaused() bool {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+	return session.paused
+}
 
-		// Ensure we are running still
-		if !session.running {
-			session.isRecording = false
-			log.Println("Cannot start, session not yet running.")
-			return
-		}
+// Switch between streaming at the session fps and sampling at a background rate
+func (session *Session) setStreaming(streaming bool, backgroundFPS float64) {
+	session.mutex.Lock()
+	session.streaming = streaming
+	session.backgroundFPS = backgroundFPS
+	session.mutex.Unlock()
+	session.wakeUp()
+}
 
-		// Get display count
-		dcount := GetScreenCount()
+// Interrupt a wait between captures so a state change applies immediately
+func (session *Session) wakeUp() {
+	select {
+	case session.wake <- struct{}{}:
+	default:
+	}
+}
 
-		// Create image list
-		images := make([][]byte, 0)
+// Wait between captures, returning early when woken or stopped
+func (session *Session) sleep(d time.Duration) {
+	select {
+	case <-session.ctx.Done():
+	case <-session.wake:
+	case <-time.After(d):
+	}
+}
 
-		// Take screenshot
-		for i := 0; i < dcount; i++ {
+// Register user recording session with the server
+func (session *Session) register(conn *FramedConn) error {
 
-			// get screen data
-			img, err := CaptureScreen(i)
-			if err != nil {
-				time.Sleep(2 * time.Second)
-				log.Printf("Unable to capture screen: %v\n", err)
-				continue
-			}
+	// Create registration
+	cmd, err := CreateRegistration(&uploadpb.Register{
+		AgentId:         session.registration.AgentID,
+		Host:            session.registration.Host,
+		User:            session.registration.User,
+		Capabilities:    Capabilities,
+		Codecs:          CodecNames(),
+		EnrollmentToken: session.enrollToken,
+		Credential:      session.credential,
+	})
+	if err != nil {
+		return err
+	}
+
+	// Send registration to server
+	log.Println("Registering with the server.")
+	return conn.WriteFrame(cmd)
+}
+
+// Record screens until the session stops, uploading while registered and spooling otherwise
+func (session *Session) record() {
 
-			// encode with zlib
-			pngbuff := new(bytes.Buffer)
-			png.Encode(pngbuff, img)
-			encimg, encerr := EncodeImage(pngbuff.Bytes())
-			if encerr != nil {
-				time.Sleep(2 * time.Second)
-				log.Printf("Unable to encode bytes: %v\n", encerr)
+	// Take screenshots and send to server per fps
+	spooling := false
+	for session.ctx.Err() == nil {
+
+		// Wait for a registration, spooling captures meanwhile if enabled
+		conn := session.uploadConn()
+		if conn == nil {
+			if session.spool == nil {
+				session.sleep(idleCheckInterval)
 				continue
 			}
-
-			// Add image to upload
-			images = append(images, encimg)
+			if !spooling {
+				log.Printf("Not connected, spooling captures at %v fps.\n", session.spoolFPS)
+				spooling = true
+			}
+			if !session.isPaused() {
+				if err := session.spoolCapture(); err != nil {
+					log.Printf("Unable to spool capture: %v\n", err)
+				}
+			}
+			session.sleep(time.Duration(float64(time.Second) / session.spoolFPS))
+			continue
 		}
+		spooling = false
 
-		// Create upload request
-		msg, err := CreateUpload(images)
-		if err != nil {
-			log.Printf("Unable to create upload request: %v\n", err)
-			time.Sleep(2 * time.Second)
+		// Capture nothing while paused or idle
+		if session.isPaused() || session.targetFPS() <= 0 {
+			session.sleep(idleCheckInterval)
 			continue
 		}
 
-		// Send image upload to server
-		if err := SendMessage(msg, session.socket); err != nil {
-			log.Printf("Unable to send upload request: %v\n", err)
-			time.Sleep(2 * time.Second)
-			continue
+		// Take screenshot, backing off when displays fail
+		frames, displays, failed := session.capture()
+		if failed > 0 {
+			session.sleep(2 * time.Second)
+		}
+
+		// In adaptive mode only captures that changed enough are uploaded
+		if session.activity.ShouldUpload(frames, time.Now()) {
+			if err := session.upload(session.ctx, conn, frames, displays); err != nil {
+				log.Printf("Unable to upload capture: %v\n", err)
+				session.sleep(2 * time.Second)
+				continue
+			}
 		}
 
 		// Check if we need to wait for fps compliance
 		waitFor := session.getWaitTime()
-		time.Sleep(waitFor)
+		session.sleep(waitFor)
 
 		// Set the last screenshot timestamp
 		session.lastImgStamp = time.Now().UnixNano() / int64(time.Millisecond)
 	}
 }
 
+// Capture every display, failed displays stay in place so indices are stable. Snapshots capture
+// from the connection goroutine while recording, so captures take turns.
+func (session *Session) capture() ([]*image.RGBA, []*uploadpb.DisplayInfo, int) {
+	session.captureMutex.Lock()
+	defer session.captureMutex.Unlock()
+
+	// Get display count
+	dcount := session.capturer.ScreenCount()
+
+	// Create capture list
+	frames := make([]*image.RGBA, dcount)
+	displays := make([]*uploadpb.DisplayInfo, dcount)
+	failed := 0
+
+	for i := 0; i < dcount; i++ {
+		displays[i] = displayInfo(session.capturer, i)
+
+		// get screen data
+		img, err := session.capturer.Capture(i)
+		if err != nil {
+			log.Printf("Unable to capture screen %d: %v\n", i, err)
+			displays[i].Status = uploadpb.DisplayInfo_FAILED
+			displays[i].Error = err.Error()
+			failed++
+			continue
+		}
+
+		// Add capture to upload
+		frames[i] = img
+	}
+
+	return frames, displays, failed
+}
+
+// Encode and send a capture over a connection
+func (session *Session) upload(ctx context.Context, conn *FramedConn, frames []*image.RGBA, displays []*uploadpb.DisplayInfo) error {
+
+	// Apply the resolution and quality of the current bandwidth level
+	settings := session.captureSettings()
+	scaled := make([]*image.RGBA, len(frames))
+	for i, img := range frames {
+		scaled[i] = scaleRGBA(img, float64(settings.Scale))
+	}
+	session.encoder.SetQuality(int(settings.Quality))
+
+	// Create upload request
+	msg, err := session.createUpload(scaled, displays, settings)
+	if err != nil {
+		return fmt.Errorf("create request: %v", err)
+	}
+
+	// Send image upload to server within the bandwidth limit
+	if err := session.bandwidth.Wait(ctx, len(msg)); err != nil {
+
+		// Nothing was sent, but the encoder already moved on from the previous capture
+		session.encoder.RequestKeyframe()
+		return err
+	}
+	start := time.Now()
+	if err := conn.WriteFrame(msg); err != nil {
+
+		// The server never got this frame, so the next one can't be a delta against it
+		session.encoder.RequestKeyframe()
+		return fmt.Errorf("send request: %v", err)
+	}
+	session.bandwidth.Sent(len(msg), time.Since(start), time.Now())
+
+	session.activity.Uploaded(frames, time.Now())
+	return nil
+}
+
+// Get the effective capture settings for the current bandwidth level
+func (session *Session) captureSettings() *uploadpb.CaptureSettings {
+	level := session.bandwidth.Level()
+	throughput, latency := session.bandwidth.Stats()
+
+	// Scale the negotiated quality, keeping it usable
+	_, quality := session.getCodec()
+	if quality <= 0 {
+		quality = DefaultImageQuality
+	}
+	quality = int(float64(quality) * level.Quality)
+	if quality < 1 {
+		quality = 1
+	}
+
+	return &uploadpb.CaptureSettings{
+		Fps:            float32(session.targetFPS()),
+		Scale:          float32(level.Scale),
+		Quality:        uint32(quality),
+		BandwidthLimit: uint64(session.bandwidth.Limit()),
+		Throughput:     uint64(throughput),
+		SendLatencyMs:  uint32(latency / time.Millisecond),
+	}
+}
+
+// Get the capture rate after bandwidth adjustments
+func (session *Session) targetFPS() float64 {
+	session.mutex.Lock()
+	fps := float64(session.fps)
+	if !session.streaming {
+		fps = session.backgroundFPS
+	}
+	session.mutex.Unlock()
+	return fps * session.bandwidth.Level().FPS
+}
+
+// Encode captures as deltas when the server supports them, otherwise as full images
+func (session *Session) createUpload(frames []*image.RGBA, displays []*uploadpb.DisplayInfo, settings *uploadpb.CaptureSettings) ([]byte, error) {
+	if HasCapability(session.getCapabilities(), CapDeltaTiles) {
+		upload, err := session.encoder.Encode(frames)
+		if err != nil {
+			return nil, err
+		}
+		upload.Displays = displays
+		upload.Settings = settings
+		upload.EffectiveFps = float32(session.activity.EffectiveFPS(time.Now()))
+		return CreateUploadMessage(upload)
+	}
+
+	// Encode every display as a full image
+	codec, _ := session.getCodec()
+	images, formats, err := encodeFrames(frames, codec, int(settings.GetQuality()))
+	if err != nil {
+		return nil, err
+	}
+	return CreateUploadMessage(&uploadpb.ImageUpload{
+		Images:       images,
+		Formats:      formats,
+		Displays:     displays,
+		Settings:     settings,
+		Timestamp:    timestamppb.Now(),
+		EffectiveFps: float32(session.activity.EffectiveFPS(time.Now())),
+	})
+}
+
+// Encode each capture as a full image, failed captures stay empty
+func encodeFrames(frames []*image.RGBA, codec Codec, quality int) ([][]byte, []uploadpb.ImageFormat, error) {
+	images := make([][]byte, 0, len(frames))
+	formats := make([]uploadpb.ImageFormat, 0, len(frames))
+	for _, img := range frames {
+		if img == nil {
+			images = append(images, nil)
+			formats = append(formats, codec.Format())
+			continue
+		}
+		encimg, err := codec.Encode(img, quality)
+		if err != nil {
+			return nil, nil, err
+		}
+		images = append(images, encimg)
+		formats = append(formats, codec.Format())
+	}
+	return images, formats, nil
+}
+
+// Describe where a display sits in the virtual desktop
+func displayInfo(capturer Capturer, index int) *uploadpb.DisplayInfo {
+	geometry := capturer.Geometry(index)
+	return &uploadpb.DisplayInfo{
+		Index:  uint32(index),
+		X:      int32(geometry.Bounds.Min.X),
+		Y:      int32(geometry.Bounds.Min.Y),
+		Width:  uint32(geometry.Bounds.Dx()),
+		Height: uint32(geometry.Bounds.Dy()),
+		Scale:  float32(geometry.Scale),
+	}
+}
+
 // Get waiting period required for next screenshot
 func (session *Session) getWaitTime() time.Duration {
 
@@ -225,7 +980,11 @@ func (session *Session) getWaitTime() time.Duration {
 	var timeDiff float64 = float64((time.Now().UnixNano() / int64(time.Millisecond)) - session.lastImgStamp)
 
 	// Get number of ms per frame based on fps
-	var msPerFrame float64 = 1000 / float64(session.fps)
+	fps := session.targetFPS()
+	if fps <= 0 {
+		return idleCheckInterval
+	}
+	var msPerFrame float64 = 1000 / fps
 
 	// Get required minimum time to wait for next frame to meet max fps
 	waitForMs := math.Max(0, msPerFrame-float64(timeDiff))
@@ -233,3 +992,37 @@ func (session *Session) getWaitTime() time.Duration {
 	// Return ms duration as a time.Duration
 	return time.Millisecond * time.Duration(waitForMs)
 }
+
+// Stored form of an issued agent credential
+type storedCredential struct {
+	ID     string `json:"id"`
+	Secret string `json:"secret"`
+}
+
+// Load an agent credential from disk, nil if none has been issued yet
+func loadCredential(path string) (*uploadpb.Credential, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	stored := storedCredential{}
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, err
+	}
+	return &uploadpb.Credential{Id: stored.ID, Secret: stored.Secret}, nil
+}
+
+// Save an agent credential readable only by the current user
+func saveCredential(path string, cred *uploadpb.Credential) error {
+	data, err := json.Marshal(storedCredential{ID: cred.GetId(), Secret: cred.GetSecret()})
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0600)
+}
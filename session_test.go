@@ -0,0 +1,113 @@
+package goscreenmonit
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/micaiahwallace/goscreenmonit/uploadpb"
+)
+
+// Start a server on a loopback port with the test certificates
+func startTestServer(t *testing.T, pki *testPKI) (*Server, string, func()) {
+	t.Helper()
+	server := NewServer("127.0.0.1:0", pki.server.certPath, pki.server.keyPath)
+	conf, err := server.TLSConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := tls.Listen("tcp4", "127.0.0.1:0", conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.SetListener(listener)
+	server.Start(make(chan int, 1))
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	return server, net.JoinHostPort("localhost", port), func() { listener.Close() }
+}
+
+// Wait for a condition, failing the test after a few seconds
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Stream synthetic captures to a server while commands and snapshots arrive and several
+// goroutines stop the session at once. Run with -race.
+func TestSessionCommandsWhileRecording(t *testing.T) {
+	pki := newTestPKI(t)
+	defer pki.Close()
+	server, address, stop := startTestServer(t, pki)
+	defer stop()
+
+	session := NewSession(address, 20, Registration{AgentID: "race-agent"}, NewSyntheticCapturer(2, 64, 48, 10))
+	if err := session.SetServerCA(pki.ca.certPath); err != nil {
+		t.Fatal(err)
+	}
+	if err := session.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "uploads", func() bool {
+		client := server.GetClient("race-agent")
+		return client != nil && client.GetStats().Frames > 3
+	})
+
+	// Snapshots capture on the connection goroutine while the recorder captures too
+	actions := []uploadpb.Command_Action{
+		uploadpb.Command_SNAPSHOT,
+		uploadpb.Command_SET_FPS,
+		uploadpb.Command_SNAPSHOT,
+		uploadpb.Command_PAUSE,
+		uploadpb.Command_SNAPSHOT,
+		uploadpb.Command_RESUME,
+		uploadpb.Command_SNAPSHOT,
+	}
+	var commands sync.WaitGroup
+	for _, action := range actions {
+		commands.Add(1)
+		go func(action uploadpb.Command_Action) {
+			defer commands.Done()
+			ack, err := server.SendCommand("race-agent", &uploadpb.Command{Action: action, Fps: 30}, 5*time.Second)
+			if err != nil {
+				t.Errorf("%v command failed: %v", action, err)
+				return
+			}
+			if !ack.GetOk() {
+				t.Errorf("%v command refused: %s", action, ack.GetError())
+			}
+		}(action)
+	}
+	commands.Wait()
+
+	snapshot := server.GetClient("race-agent").GetSnapshot()
+	if snapshot == nil || len(snapshot.GetImages()) != 2 {
+		t.Fatalf("snapshot %v, want images of both displays", snapshot)
+	}
+
+	// Only the first stop decides the outcome, every caller returns once stopped
+	var stops sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		stops.Add(1)
+		go func() {
+			defer stops.Done()
+			session.Stop()
+		}()
+	}
+	stops.Wait()
+	if state := session.State(); state != StateStopped {
+		t.Errorf("session %v after stopping, want %v", state, StateStopped)
+	}
+	if code := session.ExitCode(); code != 0 {
+		t.Errorf("exit code %d after stopping, want 0", code)
+	}
+	waitFor(t, "deregistration", func() bool { return server.GetClient("race-agent") == nil })
+}
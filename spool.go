@@ -0,0 +1,305 @@
+package goscreenmonit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/micaiahwallace/goscreenmonit/uploadpb"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Pause between backfilled uploads so they don't crowd out the live stream
+const backfillInterval = 100 * time.Millisecond
+
+// Frames captured while disconnected, kept on disk until they can be uploaded:
+// <dir>/<unix nanos>.upload
+type Spool struct {
+	mutex    sync.Mutex
+	dir      string
+	maxBytes int64
+	maxAge   time.Duration
+}
+
+// Create a spool in a directory, dropping the oldest frames beyond maxBytes or older than
+// maxAge, zero disables a limit
+func NewSpool(dir string, maxBytes int64, maxAge time.Duration) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &Spool{
+		dir:      dir,
+		maxBytes: maxBytes,
+		maxAge:   maxAge,
+	}, nil
+}
+
+// Store an upload under its capture time
+func (spool *Spool) Add(upload *uploadpb.ImageUpload) error {
+	data, err := proto.Marshal(upload)
+	if err != nil {
+		return err
+	}
+
+	spool.mutex.Lock()
+	defer spool.mutex.Unlock()
+
+	// Write to a temp file first so a crash never leaves a partial upload
+	path := filepath.Join(spool.dir, spoolFileName(upload.GetTimestamp().AsTime()))
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	return spool.trim()
+}
+
+// Drop expired and excess uploads, then list the names of the rest oldest first
+func (spool *Spool) Pending() ([]string, error) {
+	spool.mutex.Lock()
+	defer spool.mutex.Unlock()
+
+	if err := spool.trim(); err != nil {
+		return nil, err
+	}
+	entries, err := spool.entries()
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(entries))
+	for i, entry := range entries {
+		names[i] = entry.Name()
+	}
+	return names, nil
+}
+
+// Read a spooled upload, nil when it was removed since being listed or was unreadable
+func (spool *Spool) Load(name string) (*uploadpb.ImageUpload, error) {
+	spool.mutex.Lock()
+	defer spool.mutex.Unlock()
+
+	path := filepath.Join(spool.dir, name)
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	upload := &uploadpb.ImageUpload{}
+	if err := proto.Unmarshal(data, upload); err != nil {
+
+		// Drop unreadable uploads so they can't block the spool
+		log.Printf("Dropping corrupt spooled upload %s: %v\n", name, err)
+		os.Remove(path)
+		return nil, nil
+	}
+	return upload, nil
+}
+
+// Remove an uploaded entry
+func (spool *Spool) Remove(name string) error {
+	spool.mutex.Lock()
+	defer spool.mutex.Unlock()
+	err := os.Remove(filepath.Join(spool.dir, name))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// Count the spooled uploads
+func (spool *Spool) Len() int {
+	spool.mutex.Lock()
+	defer spool.mutex.Unlock()
+	entries, _ := spool.entries()
+	return len(entries)
+}
+
+// List spooled uploads, oldest first
+func (spool *Spool) entries() ([]os.FileInfo, error) {
+	all, err := ioutil.ReadDir(spool.dir)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]os.FileInfo, 0, len(all))
+	for _, entry := range all {
+		if _, ok := parseSpoolFileName(entry.Name()); ok {
+			entries = append(entries, entry)
+		}
+	}
+
+	// Names are zero padded so lexical order is time order
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+// Remove expired uploads then the oldest until the spool is under its size limit
+func (spool *Spool) trim() error {
+	entries, err := spool.entries()
+	if err != nil {
+		return err
+	}
+
+	var total int64
+	for _, entry := range entries {
+		total += entry.Size()
+	}
+	cutoff := time.Time{}
+	if spool.maxAge > 0 {
+		cutoff = time.Now().Add(-spool.maxAge)
+	}
+
+	for _, entry := range entries {
+		ts, _ := parseSpoolFileName(entry.Name())
+		expired := !cutoff.IsZero() && ts.Before(cutoff)
+		oversize := spool.maxBytes > 0 && total > spool.maxBytes
+		if !expired && !oversize {
+			break
+		}
+		if err := os.Remove(filepath.Join(spool.dir, entry.Name())); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		total -= entry.Size()
+	}
+	return nil
+}
+
+// Zero padded so lexical order matches time order
+func spoolFileName(ts time.Time) string {
+	return fmt.Sprintf("%020d.upload", ts.UnixNano())
+}
+
+// Get the capture time encoded in a spool file name
+func parseSpoolFileName(name string) (time.Time, bool) {
+	if filepath.Ext(name) != ".upload" {
+		return time.Time{}, false
+	}
+	nanos, err := strconv.ParseInt(strings.TrimSuffix(name, ".upload"), 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(0, nanos), true
+}
+
+// Keep captures taken while disconnected in a spool at fps, uploading them once reconnected
+func (session *Session) SetSpool(spool *Spool, fps float64) error {
+	if !(fps > 0) || math.IsInf(fps, 1) {
+		return fmt.Errorf("spool fps must be greater than 0")
+	}
+	session.spool = spool
+	session.spoolFPS = fps
+	return nil
+}
+
+// Capture every display at full resolution into the spool
+func (session *Session) spoolCapture() error {
+	frames, displays, _ := session.capture()
+	codec, quality := session.getCodec()
+	images, formats, err := encodeFrames(frames, codec, quality)
+	if err != nil {
+		return err
+	}
+	return session.spool.Add(&uploadpb.ImageUpload{
+		Images:    images,
+		Formats:   formats,
+		Displays:  displays,
+		Timestamp: timestamppb.Now(),
+	})
+}
+
+// Upload spooled captures oldest first over a connection, stopping if it closes
+func (session *Session) drainSpool(ctx context.Context, conn *FramedConn) {
+
+	// Only one drain at a time across quick reconnects
+	session.mutex.Lock()
+	if session.draining {
+		session.mutex.Unlock()
+		return
+	}
+	session.draining = true
+	session.mutex.Unlock()
+	defer func() {
+		session.mutex.Lock()
+		session.draining = false
+		session.mutex.Unlock()
+	}()
+
+	sent := 0
+drain:
+	for ctx.Err() == nil {
+
+		// List the spool once per pass, anything spooled meanwhile goes in the next one
+		names, err := session.spool.Pending()
+		if err != nil {
+			log.Printf("Unable to read spool: %v\n", err)
+			return
+		}
+		if len(names) == 0 {
+			break
+		}
+		if sent == 0 {
+			log.Printf("Backfilling %d spooled captures.\n", len(names))
+		}
+
+		for _, name := range names {
+			if ctx.Err() != nil {
+				break drain
+			}
+			upload, err := session.spool.Load(name)
+			if err != nil {
+				log.Printf("Unable to read spool: %v\n", err)
+				return
+			}
+			if upload == nil {
+				continue
+			}
+
+			// Send within the bandwidth limit, leaving room for the live stream
+			upload.Backfill = true
+			msg, err := CreateUploadMessage(upload)
+			if err != nil {
+				log.Printf("Unable to create backfill upload: %v\n", err)
+				session.spool.Remove(name)
+				continue
+			}
+			if err := session.bandwidth.Wait(ctx, len(msg)); err != nil {
+				break drain
+			}
+			if err := conn.WriteFrame(msg); err != nil {
+
+				// An oversized upload would never go through, so don't let it block the rest
+				if errors.Is(err, ErrFrameTooLarge) {
+					log.Printf("Dropping spooled capture %s: %v\n", name, err)
+					session.spool.Remove(name)
+					continue
+				}
+				log.Printf("Backfill interrupted after %d captures: %v\n", sent, err)
+				return
+			}
+			session.spool.Remove(name)
+			sent++
+
+			select {
+			case <-ctx.Done():
+			case <-time.After(backfillInterval):
+			}
+		}
+	}
+	if sent > 0 {
+		log.Printf("Backfilled %d spooled captures.\n", sent)
+	}
+}
@@ -0,0 +1,127 @@
+package goscreenmonit
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Lifecycle state of an agent session
+type SessionState int
+
+const (
+	// Waiting to connect, either before the first attempt or backing off after a failure
+	StateDisconnected SessionState = iota
+
+	// Dialing the servers
+	StateConnecting
+
+	// Connected and waiting for the server to accept the registration
+	StateRegistering
+
+	// Registered and uploading captures
+	StateStreaming
+
+	// Registered but paused by the server
+	StatePaused
+
+	// Shutting down the connection and goroutines
+	StateStopping
+
+	// Every goroutine has finished
+	StateStopped
+)
+
+var sessionStateNames = []string{"disconnected", "connecting", "registering", "streaming", "paused", "stopping", "stopped"}
+
+func (state SessionState) String() string {
+	if state < 0 || int(state) >= len(sessionStateNames) {
+		return "unknown"
+	}
+	return sessionStateNames[state]
+}
+
+// Encode the state by name
+func (state SessionState) MarshalText() ([]byte, error) {
+	return []byte(state.String()), nil
+}
+
+// Decode a state name
+func (state *SessionState) UnmarshalText(text []byte) error {
+	for i, name := range sessionStateNames {
+		if name == string(text) {
+			*state = SessionState(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown session state %q", text)
+}
+
+// Check if the session is registered with a server
+func (state SessionState) Online() bool {
+	return state == StateStreaming || state == StatePaused
+}
+
+// A session state transition
+type StateChange struct {
+	From SessionState
+	To   SessionState
+	At   time.Time
+}
+
+// Handler invoked for each state transition, on the goroutine making the transition
+type StateHandler func(StateChange)
+
+type stateSubscription struct {
+	handler StateHandler
+}
+
+// Get the current session state
+func (session *Session) State() SessionState {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+	return session.state
+}
+
+// Subscribe to state transitions. Returns a function that cancels the subscription.
+func (session *Session) SubscribeState(handler StateHandler) func() {
+	sub := &stateSubscription{handler: handler}
+
+	session.mutex.Lock()
+	session.stateSubs[sub] = struct{}{}
+	session.mutex.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			session.mutex.Lock()
+			delete(session.stateSubs, sub)
+			session.mutex.Unlock()
+		})
+	}
+}
+
+// Move to a new state and notify subscribers. Once stopping, the only way out is stopped.
+func (session *Session) setState(state SessionState) {
+	session.mutex.Lock()
+	from := session.state
+	if from == state || from == StateStopped || (from == StateStopping && state != StateStopped) {
+		session.mutex.Unlock()
+		return
+	}
+	session.state = state
+	handlers := make([]StateHandler, 0, len(session.stateSubs))
+	for sub := range session.stateSubs {
+		handlers = append(handlers, sub.handler)
+	}
+	session.mutex.Unlock()
+
+	session.setStatus(func(status *SessionStatus) {
+		status.State = state
+	})
+
+	change := StateChange{From: from, To: state, At: time.Now()}
+	for _, h := range handlers {
+		h(change)
+	}
+}
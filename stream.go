@@ -0,0 +1,124 @@
+package goscreenmonit
+
+import (
+	"log"
+	"sync"
+
+	"github.com/micaiahwallace/goscreenmonit/uploadpb"
+)
+
+// Counts live viewers per agent id so on-demand agents only stream while watched
+type watchers struct {
+	mutex  sync.Mutex
+	counts map[string]int
+}
+
+// Only stream from agents while a viewer is watching them, sampling at backgroundFPS
+// otherwise, zero idles the agent completely
+func (server *Server) SetOnDemand(backgroundFPS float64) {
+	server.onDemand = true
+	server.backgroundFPS = backgroundFPS
+}
+
+// Add a live viewer of an agent, starting its stream for the first viewer. The returned
+// function removes the viewer, stopping the stream after the last one leaves
+func (server *Server) Watch(agentID string) func() {
+	server.watchers.mutex.Lock()
+	server.watchers.counts[agentID]++
+	first := server.watchers.counts[agentID] == 1
+	server.watchers.mutex.Unlock()
+	if first {
+		server.syncStreamState(agentID)
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			server.watchers.mutex.Lock()
+			server.watchers.counts[agentID]--
+			last := server.watchers.counts[agentID] <= 0
+			if last {
+				delete(server.watchers.counts, agentID)
+			}
+			server.watchers.mutex.Unlock()
+			if last {
+				server.syncStreamState(agentID)
+			}
+		})
+	}
+}
+
+// Check if an agent is currently streaming, agents that can't be stopped always are
+func (server *Server) IsStreaming(agentID string) bool {
+	if !server.onDemand {
+		return true
+	}
+	if client := server.registry.Get(agentID); client != nil && !HasCapability(client.Capabilities, CapStream) {
+		return true
+	}
+	return server.isWatched(agentID)
+}
+
+// Check if an agent has any live viewers
+func (server *Server) isWatched(agentID string) bool {
+	server.watchers.mutex.Lock()
+	defer server.watchers.mutex.Unlock()
+	return server.watchers.counts[agentID] > 0
+}
+
+// Allow stream messages to a newly registered agent once its auth response is sent, starting
+// its stream if viewers are already waiting
+func (server *Server) startStreamControl(client *RegisteredClient) {
+	client.streamMutex.Lock()
+	client.streamReady = true
+	client.streamMutex.Unlock()
+	server.sendStreamState(client)
+}
+
+// Look up a connected agent and bring its stream in line with its viewers
+func (server *Server) syncStreamState(agentID string) {
+	if client := server.registry.Get(agentID); client != nil {
+		server.sendStreamState(client)
+	}
+}
+
+// Tell an on-demand agent to start or stop streaming when that differs from what it was last
+// told. The viewer count is read under the client's stream lock, so whichever call sends last
+// sends the current state and start and stop messages reach the agent in order.
+func (server *Server) sendStreamState(client *RegisteredClient) {
+	if !server.onDemand || !HasCapability(client.Capabilities, CapStream) {
+		return
+	}
+	client.streamMutex.Lock()
+	defer client.streamMutex.Unlock()
+	if !client.streamReady {
+		return
+	}
+	streaming := server.isWatched(client.ID)
+	if streaming == client.streaming {
+		return
+	}
+	client.streaming = streaming
+	if streaming {
+		log.Printf("Starting stream from %s\n", client.ID)
+	} else {
+		log.Printf("Stopping stream from %s\n", client.ID)
+	}
+	server.streamConn(client.Conn, streaming)
+}
+
+// Send a start or stop stream message on a connection
+func (server *Server) streamConn(conn *FramedConn, streaming bool) {
+	var msg []byte
+	var err error
+	if streaming {
+		msg, err = CreateResponse(uploadpb.ServerResponse_START_STREAM)
+	} else {
+		msg, err = CreateStopStream(server.backgroundFPS)
+	}
+	if err != nil {
+		log.Printf("Unable to create stream message. %v\n", err)
+		return
+	}
+	conn.WriteFrame(msg)
+}
@@ -0,0 +1,113 @@
+package goscreenmonit
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"time"
+)
+
+// Produces deterministic moving test patterns instead of real screenshots,
+// for headless testing and demos
+type SyntheticCapturer struct {
+	Displays   int
+	Width      int
+	Height     int
+	ChangeRate float64
+
+	start time.Time
+	now   func() time.Time
+}
+
+// Create a synthetic capturer with a display count, resolution and pattern changes per second
+func NewSyntheticCapturer(displays, width, height int, changeRate float64) *SyntheticCapturer {
+	return &SyntheticCapturer{
+		Displays:   displays,
+		Width:      width,
+		Height:     height,
+		ChangeRate: changeRate,
+		start:      time.Now(),
+		now:        time.Now,
+	}
+}
+
+// Get number of synthetic displays
+func (c *SyntheticCapturer) ScreenCount() int {
+	return c.Displays
+}
+
+// Render the test pattern for a display at the current frame
+func (c *SyntheticCapturer) Capture(index int) (*image.RGBA, error) {
+	if index < 0 || index >= c.Displays {
+		return nil, fmt.Errorf("synthetic display %d out of range", index)
+	}
+	return c.Render(index, c.Frame()), nil
+}
+
+// Synthetic displays are laid out left to right
+func (c *SyntheticCapturer) Geometry(index int) DisplayGeometry {
+	return DisplayGeometry{Bounds: image.Rect(index*c.Width, 0, (index+1)*c.Width, c.Height), Scale: 1}
+}
+
+// Get the current pattern frame number, a change rate of zero is a static image
+func (c *SyntheticCapturer) Frame() int {
+	if c.ChangeRate <= 0 {
+		return 0
+	}
+	return int(c.now().Sub(c.start).Seconds() * c.ChangeRate)
+}
+
+// Render the test pattern for a display and frame number
+func (c *SyntheticCapturer) Render(index, frame int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, c.Width, c.Height))
+
+	// Per display tinted gradient background
+	tint := uint8(index * 60)
+	for y := 0; y < c.Height; y++ {
+		for x := 0; x < c.Width; x++ {
+			off := img.PixOffset(x, y)
+			img.Pix[off] = uint8(x * 255 / c.Width)
+			img.Pix[off+1] = uint8(y * 255 / c.Height)
+			img.Pix[off+2] = tint
+			img.Pix[off+3] = 0xff
+		}
+	}
+
+	// A square bouncing horizontally with the frame number
+	size := c.Height / 4
+	if size < 1 {
+		size = 1
+	}
+	span := c.Width - size
+	pos := 0
+	if span > 0 {
+		pos = (frame * 16) % (2 * span)
+		if pos > span {
+			pos = 2*span - pos
+		}
+	}
+	top := (c.Height - size) / 2
+	fill(img, image.Rect(pos, top, pos+size, top+size), color.RGBA{0xff, 0xff, 0xff, 0xff})
+
+	// Frame counter bar along the bottom encodes the frame number in binary
+	bit := c.Width / 32
+	if bit > 0 {
+		for i := 0; i < 32; i++ {
+			if frame&(1<<uint(i)) != 0 {
+				fill(img, image.Rect(i*bit, c.Height-bit, (i+1)*bit, c.Height), color.RGBA{0, 0, 0, 0xff})
+			}
+		}
+	}
+
+	return img
+}
+
+// Fill a rectangle of an image with a solid color
+func fill(img *image.RGBA, r image.Rectangle, col color.RGBA) {
+	r = r.Intersect(img.Bounds())
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			img.SetRGBA(x, y, col)
+		}
+	}
+}
@@ -1,83 +1,245 @@
 package goscreenmonit
 
 import (
+	"bytes"
 	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
 	"log"
 	"net"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
-// Helper function to read a certain amount of data into a buffer
-func ReadConnBytes(count uint64, conn net.Conn) ([]byte, error) {
+// Default frame size limits, agents upload images while servers only send control messages
+const (
+	DefaultMaxUploadFrame  = 64 << 20
+	DefaultMaxControlFrame = 1 << 20
+)
 
-	final := make([]byte, 0)
-	tmp := make([]byte, count)
+// Size of the little endian uint64 length prefix on every frame
+const frameHeaderSize = 8
 
-	// Read until all bytes are retrieved
-	for {
-		n, err := conn.Read(tmp)
-		if err != nil {
-			return nil, err
-		}
-		final = append(final, tmp[:n]...)
+// Read buffers larger than this are released after use instead of kept for the next frame
+const maxRetainedBuffer = 4 << 20
 
-		// All data is captured
-		if len(final) >= len(tmp) {
-			break
-		}
+var (
+	ErrFrameTooLarge = errors.New("frame too large")
+	ErrFrameTimeout  = errors.New("frame timed out")
+	ErrFrameStalled  = errors.New("frame stalled")
+)
+
+// A frame over the size limit, in either direction
+type FrameSizeError struct {
+	Size  uint64
+	Limit uint64
+	Write bool
+}
+
+func (err *FrameSizeError) Error() string {
+	direction := "incoming"
+	if err.Write {
+		direction = "outgoing"
 	}
+	return fmt.Sprintf("%s frame of %d bytes exceeds the %d byte limit", direction, err.Size, err.Limit)
+}
 
-	return final, nil
+// Match ErrFrameTooLarge with errors.Is
+func (err *FrameSizeError) Is(target error) bool {
+	return target == ErrFrameTooLarge
 }
 
-// Accept and process requests from other end of socket
-func ReadCommand(conn net.Conn, datapipe chan []byte) {
+// Connection exchanging length prefixed frames. Reads reuse a buffer and must come from a
+// single goroutine, writes may come from any goroutine and never interleave.
+type FramedConn struct {
+
+	// Changed atomically while in use, first in the struct so they stay 64 bit aligned
+	readTimeout  int64
+	writeTimeout int64
+	maxRead      uint64
+
+	conn        net.Conn
+	maxWrite    uint64
+	header      [frameHeaderSize]byte
+	readBuf     bytes.Buffer
+	writeMutex  sync.Mutex
+	writeHeader [frameHeaderSize]byte
+}
 
-	for {
+// Wrap a connection, refusing to read frames over maxRead or write frames over maxWrite bytes
+func NewFramedConn(conn net.Conn, maxRead, maxWrite int) *FramedConn {
+	return &FramedConn{
+		conn:     conn,
+		maxRead:  uint64(maxRead),
+		maxWrite: uint64(maxWrite),
+	}
+}
 
-		// Read first uint64 as the length of the message
-		lengthdata, lerr := ReadConnBytes(8, conn)
-		if lerr != nil {
-			close(datapipe)
-			return
-		}
-		msglen := binary.LittleEndian.Uint64(lengthdata)
+// Change the largest frame accepted by the next read, such as once the peer has authenticated
+func (fc *FramedConn) SetMaxReadSize(maxRead int) {
+	atomic.StoreUint64(&fc.maxRead, uint64(maxRead))
+}
 
-		// Check the length to make sure it's a valid message
-		if msglen > 1e9 {
-			log.Println("Stream sync broken, resetting connection.")
-			close(datapipe)
-			return
-		}
+// Fail reads when a frame doesn't start, or once started doesn't finish, within timeout. Zero waits forever
+func (fc *FramedConn) SetReadTimeout(timeout time.Duration) {
+	atomic.StoreInt64(&fc.readTimeout, int64(timeout))
+}
 
-		// Read the full message based on previous length
-		msgdata, merr := ReadConnBytes(msglen, conn)
-		if merr != nil {
-			close(datapipe)
-			return
+// Fail writes of a frame taking longer than timeout. Zero waits forever
+func (fc *FramedConn) SetWriteTimeout(timeout time.Duration) {
+	atomic.StoreInt64(&fc.writeTimeout, int64(timeout))
+}
+
+// Read the next frame. The returned data is only valid until the next call
+func (fc *FramedConn) ReadFrame() ([]byte, error) {
+
+	// Wait for the length prefix
+	if err := fc.setReadDeadline(); err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(fc.conn, fc.header[:]); err != nil {
+		return nil, wrapTimeout(err, ErrFrameTimeout)
+	}
+	size := binary.LittleEndian.Uint64(fc.header[:])
+
+	// Check the length before allocating anything for it
+	if maxRead := atomic.LoadUint64(&fc.maxRead); size > maxRead {
+		return nil, &FrameSizeError{Size: size, Limit: maxRead}
+	}
+
+	// Read the body into the reused buffer, growing it only as bytes arrive so a peer can't
+	// make us allocate a whole frame it never sends
+	fc.readBuf.Reset()
+	if err := fc.setReadDeadline(); err != nil {
+		return nil, err
+	}
+	if _, err := io.CopyN(&fc.readBuf, fc.conn, int64(size)); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
 		}
+		return nil, wrapTimeout(err, ErrFrameStalled)
+	}
+	data := fc.readBuf.Bytes()
 
-		// Send data to channel
-		datapipe <- msgdata
+	// Don't hold on to the memory of an unusually large frame
+	if fc.readBuf.Cap() > maxRetainedBuffer {
+		fc.readBuf = bytes.Buffer{}
 	}
+	return data, nil
 }
 
-// Send a message by sending the message size before the message itself
-func SendMessage(msg []byte, conn net.Conn) error {
+// Start the read timeout for the next part of a frame
+func (fc *FramedConn) setReadDeadline() error {
+	deadline := time.Time{}
+	if timeout := time.Duration(atomic.LoadInt64(&fc.readTimeout)); timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	return fc.conn.SetReadDeadline(deadline)
+}
+
+// Write a frame and its length prefix without copying the message
+func (fc *FramedConn) WriteFrame(msg []byte) error {
+	size := uint64(len(msg))
+	if size > fc.maxWrite {
+		return &FrameSizeError{Size: size, Limit: fc.maxWrite, Write: true}
+	}
 
-	// Create the message size data
-	sizemsg := make([]byte, 8)
-	msglen := uint64(len(msg))
-	binary.LittleEndian.PutUint64(sizemsg, msglen)
+	fc.writeMutex.Lock()
+	defer fc.writeMutex.Unlock()
 
-	// First send the size of the message
-	if _, err := conn.Write(sizemsg); err != nil {
+	deadline := time.Time{}
+	if timeout := time.Duration(atomic.LoadInt64(&fc.writeTimeout)); timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	if err := fc.conn.SetWriteDeadline(deadline); err != nil {
 		return err
 	}
+	binary.LittleEndian.PutUint64(fc.writeHeader[:], size)
+	frame := net.Buffers{fc.writeHeader[:], msg}
+	_, err := frame.WriteTo(fc.conn)
+	return wrapTimeout(err, ErrFrameTimeout)
+}
 
-	// Then send the actual message
-	if _, err := conn.Write(msg); err != nil {
-		return err
+// Close the underlying connection
+func (fc *FramedConn) Close() error {
+	return fc.conn.Close()
+}
+
+// Get the address of the other end
+func (fc *FramedConn) RemoteAddr() net.Addr {
+	return fc.conn.RemoteAddr()
+}
+
+// Get the underlying connection
+func (fc *FramedConn) NetConn() net.Conn {
+	return fc.conn
+}
+
+// Log why reading from a peer stopped, a closed connection is expected and not logged
+func logReadError(addr string, err error) {
+	switch {
+	case errors.Is(err, ErrFrameTimeout):
+		log.Printf("No heartbeat from %s in time, closing connection.\n", addr)
+	case errors.Is(err, ErrFrameStalled):
+		log.Printf("Message from %s stalled, closing connection.\n", addr)
+	case errors.Is(err, ErrFrameTooLarge):
+		log.Printf("Closing connection %s: %v\n", addr, err)
+	}
+}
+
+// Wrap a timeout error in timeoutErr, leaving other errors as they are
+func wrapTimeout(err, timeoutErr error) error {
+	if err != nil && isTimeout(err) {
+		return fmt.Errorf("%w: %v", timeoutErr, err)
+	}
+	return err
+}
+
+// Check if an error is a timeout
+func isTimeout(err error) bool {
+	if errors.Is(err, ErrFrameTimeout) || errors.Is(err, ErrFrameStalled) {
+		return true
+	}
+	var nerr net.Error
+	return errors.As(err, &nerr) && nerr.Timeout()
+}
+
+// Read exactly count bytes from a connection.
+//
+// Deprecated: Use FramedConn, which bounds frame sizes and applies timeouts.
+func ReadConnBytes(count uint64, conn net.Conn) ([]byte, error) {
+	data := make([]byte, count)
+	if _, err := io.ReadFull(conn, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// Read frames from a connection into datapipe, closing it when the connection fails. Frames
+// over DefaultMaxUploadFrame (64MB) fail the read, earlier releases accepted up to 1e9 bytes.
+//
+// Deprecated: Use FramedConn.ReadFrame, which bounds frame sizes and applies timeouts.
+func ReadCommand(conn net.Conn, datapipe chan []byte) {
+	fconn := NewFramedConn(conn, DefaultMaxUploadFrame, DefaultMaxUploadFrame)
+	for {
+		data, err := fconn.ReadFrame()
+		if err != nil {
+			logReadError(conn.RemoteAddr().String(), err)
+			close(datapipe)
+			return
+		}
+
+		// Frames are only valid until the next read, so hand over a copy
+		datapipe <- append([]byte(nil), data...)
 	}
+}
 
-	return nil
+// Send a message as a length prefixed frame. Each call writes through its own FramedConn, so
+// concurrent callers on the same connection must serialize their writes themselves.
+//
+// Deprecated: Use FramedConn.WriteFrame, which serializes writes and applies timeouts.
+func SendMessage(msg []byte, conn net.Conn) error {
+	return NewFramedConn(conn, 0, DefaultMaxUploadFrame).WriteFrame(msg)
 }
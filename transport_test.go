@@ -0,0 +1,121 @@
+package goscreenmonit
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// Connection reading from a fixed byte stream and discarding writes
+type readerConn struct {
+	*bytes.Reader
+}
+
+func (readerConn) Write(b []byte) (int, error)      { return len(b), nil }
+func (readerConn) Close() error                     { return nil }
+func (readerConn) LocalAddr() net.Addr              { return &net.TCPAddr{} }
+func (readerConn) RemoteAddr() net.Addr             { return &net.TCPAddr{} }
+func (readerConn) SetDeadline(time.Time) error      { return nil }
+func (readerConn) SetReadDeadline(time.Time) error  { return nil }
+func (readerConn) SetWriteDeadline(time.Time) error { return nil }
+
+// Connection recording writes into a buffer
+type writerConn struct {
+	*bytes.Buffer
+}
+
+func (writerConn) Read([]byte) (int, error)         { return 0, io.EOF }
+func (writerConn) Close() error                     { return nil }
+func (writerConn) LocalAddr() net.Addr              { return &net.TCPAddr{} }
+func (writerConn) RemoteAddr() net.Addr             { return &net.TCPAddr{} }
+func (writerConn) SetDeadline(time.Time) error      { return nil }
+func (writerConn) SetReadDeadline(time.Time) error  { return nil }
+func (writerConn) SetWriteDeadline(time.Time) error { return nil }
+
+// Build a frame with a length prefix that may not match its payload
+func rawFrame(size uint64, payload []byte) []byte {
+	frame := make([]byte, frameHeaderSize, frameHeaderSize+len(payload))
+	binary.LittleEndian.PutUint64(frame, size)
+	return append(frame, payload...)
+}
+
+func FuzzReadFrame(f *testing.F) {
+	const maxRead = 1 << 10
+
+	f.Add(rawFrame(5, []byte("hello")))
+	f.Add(rawFrame(0, nil))
+	f.Add([]byte{1, 2, 3})
+	f.Add(rawFrame(maxRead+1, []byte("oversize")))
+	f.Add(rawFrame(1<<62, nil))
+	f.Add(rawFrame(100, []byte("short")))
+	f.Add(append(rawFrame(2, []byte("ab")), rawFrame(3, []byte("cd"))...))
+
+	f.Fuzz(func(t *testing.T, stream []byte) {
+		fconn := NewFramedConn(readerConn{bytes.NewReader(stream)}, maxRead, maxRead)
+
+		// Walk the stream frame by frame, checking each read against the length prefixes
+		rest := stream
+		for {
+			data, err := fconn.ReadFrame()
+			if len(rest) == 0 {
+				if err != io.EOF {
+					t.Fatalf("read at the end of the stream returned %v, want EOF", err)
+				}
+				return
+			}
+			if len(rest) < frameHeaderSize {
+				if err != io.ErrUnexpectedEOF {
+					t.Fatalf("truncated header returned %v, want unexpected EOF", err)
+				}
+				return
+			}
+			size := binary.LittleEndian.Uint64(rest)
+			rest = rest[frameHeaderSize:]
+			switch {
+			case size > maxRead:
+				if !errors.Is(err, ErrFrameTooLarge) {
+					t.Fatalf("frame of %d bytes returned %v, want too large", size, err)
+				}
+				return
+			case size > uint64(len(rest)):
+				if err != io.ErrUnexpectedEOF {
+					t.Fatalf("frame of %d bytes with %d sent returned %v, want unexpected EOF", size, len(rest), err)
+				}
+				return
+			case err != nil:
+				t.Fatalf("complete frame of %d bytes returned %v", size, err)
+			case !bytes.Equal(data, rest[:size]):
+				t.Fatalf("frame data %x, want %x", data, rest[:size])
+			}
+			rest = rest[size:]
+		}
+	})
+}
+
+func TestWriteFrameRoundTrip(t *testing.T) {
+	var sent bytes.Buffer
+	writer := NewFramedConn(writerConn{&sent}, 0, 16)
+	for _, msg := range []string{"first", "", "third"} {
+		if err := writer.WriteFrame([]byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.WriteFrame(make([]byte, 17)); !errors.Is(err, ErrFrameTooLarge) {
+		t.Errorf("oversized write returned %v, want too large", err)
+	}
+
+	reader := NewFramedConn(readerConn{bytes.NewReader(sent.Bytes())}, 16, 0)
+	for _, want := range []string{"first", "", "third"} {
+		data, err := reader.ReadFrame()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != want {
+			t.Errorf("read %q, want %q", data, want)
+		}
+	}
+}
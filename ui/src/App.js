@@ -21,8 +21,8 @@ function App() {
   }, []);
 
   // Update selected monitor
-  const setMon = useCallback((address) => {
-    const mon = mons.find(m => m.address = address)
+  const setMon = useCallback((id) => {
+    const mon = mons.find(m => m.id === id)
     setSelected(mon)
   }, [mons]);
 
@@ -44,8 +44,8 @@ function App() {
   // Create image source urls
   const urls = [];
   if (selected) {
-    for (let i = 0; i < selected.screenCount; i++) {
-      urls.push(`/monitors/${selected.address}/${i}?r=${Math.random()}`);
+    for (let i = 0; i < selected.displays.length; i++) {
+      urls.push(`/monitors/${selected.id}/${i}?r=${Math.random()}`);
     }
   }
 
@@ -55,7 +55,7 @@ function App() {
   // Connect to websocket to receive data
   useEffect(() => {
     if (selected) {
-      const socket = new WebSocket(`wss://${window.location.host}/ws/${selected.address}/0`)
+      const socket = new WebSocket(`wss://${window.location.host}/ws/${selected.id}/0`)
       socket.onmessage = (message) => {
         const ctx = canvas.current.getContext("2d")
         var img = new Image();
@@ -77,14 +77,14 @@ function App() {
   }, [selected])
 
   // Generate image width
-  const imWidth = selected ? Math.min(Math.max(100 / selected.screenCount, 50), 35) : 50;
+  const imWidth = selected ? Math.min(Math.max(100 / selected.displays.length, 50), 35) : 50;
 
   return (
     <div>
       <h1>Go Screen Monit</h1>
       <ul>
         {mons.map(mon => (
-          <li key={mon.address}><a href="#" onClick={setMon.bind(null, mon.address)}>{mon.user} ({mon.host} - {mon.address})</a></li>
+          <li key={mon.id}><a href="#" onClick={setMon.bind(null, mon.id)}>{mon.user} ({mon.host} - {mon.address})</a></li>
         ))}
       </ul>
       {
@@ -21,11 +21,71 @@ const (
 	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
 )
 
+// Encoding of an image or tile
+type ImageFormat int32
+
+const (
+	ImageFormat_PNG_ZLIB ImageFormat = 0
+	ImageFormat_PNG      ImageFormat = 1
+	ImageFormat_JPEG     ImageFormat = 2
+	ImageFormat_RAW_ZSTD ImageFormat = 3
+)
+
+// Enum value maps for ImageFormat.
+var (
+	ImageFormat_name = map[int32]string{
+		0: "PNG_ZLIB",
+		1: "PNG",
+		2: "JPEG",
+		3: "RAW_ZSTD",
+	}
+	ImageFormat_value = map[string]int32{
+		"PNG_ZLIB": 0,
+		"PNG":      1,
+		"JPEG":     2,
+		"RAW_ZSTD": 3,
+	}
+)
+
+func (x ImageFormat) Enum() *ImageFormat {
+	p := new(ImageFormat)
+	*p = x
+	return p
+}
+
+func (x ImageFormat) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (ImageFormat) Descriptor() protoreflect.EnumDescriptor {
+	return file_upload_proto_enumTypes[0].Descriptor()
+}
+
+func (ImageFormat) Type() protoreflect.EnumType {
+	return &file_upload_proto_enumTypes[0]
+}
+
+func (x ImageFormat) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use ImageFormat.Descriptor instead.
+func (ImageFormat) EnumDescriptor() ([]byte, []int) {
+	return file_upload_proto_rawDescGZIP(), []int{0}
+}
+
 type ServerResponse_MessageType int32
 
 const (
-	ServerResponse_AUTHENTICATED ServerResponse_MessageType = 0
-	ServerResponse_QUIT          ServerResponse_MessageType = 1
+	ServerResponse_AUTHENTICATED    ServerResponse_MessageType = 0
+	ServerResponse_QUIT             ServerResponse_MessageType = 1
+	ServerResponse_REJECTED         ServerResponse_MessageType = 2
+	ServerResponse_REQUEST_KEYFRAME ServerResponse_MessageType = 3
+	ServerResponse_COMMAND          ServerResponse_MessageType = 4
+	ServerResponse_START_STREAM     ServerResponse_MessageType = 5
+	ServerResponse_STOP_STREAM      ServerResponse_MessageType = 6
+	ServerResponse_PONG             ServerResponse_MessageType = 7
+	ServerResponse_ERROR            ServerResponse_MessageType = 8
 )
 
 // Enum value maps for ServerResponse_MessageType.
@@ -33,10 +93,24 @@ var (
 	ServerResponse_MessageType_name = map[int32]string{
 		0: "AUTHENTICATED",
 		1: "QUIT",
+		2: "REJECTED",
+		3: "REQUEST_KEYFRAME",
+		4: "COMMAND",
+		5: "START_STREAM",
+		6: "STOP_STREAM",
+		7: "PONG",
+		8: "ERROR",
 	}
 	ServerResponse_MessageType_value = map[string]int32{
-		"AUTHENTICATED": 0,
-		"QUIT":          1,
+		"AUTHENTICATED":    0,
+		"QUIT":             1,
+		"REJECTED":         2,
+		"REQUEST_KEYFRAME": 3,
+		"COMMAND":          4,
+		"START_STREAM":     5,
+		"STOP_STREAM":      6,
+		"PONG":             7,
+		"ERROR":            8,
 	}
 )
 
@@ -51,11 +125,11 @@ func (x ServerResponse_MessageType) String() string {
 }
 
 func (ServerResponse_MessageType) Descriptor() protoreflect.EnumDescriptor {
-	return file_upload_proto_enumTypes[0].Descriptor()
+	return file_upload_proto_enumTypes[1].Descriptor()
 }
 
 func (ServerResponse_MessageType) Type() protoreflect.EnumType {
-	return &file_upload_proto_enumTypes[0]
+	return &file_upload_proto_enumTypes[1]
 }
 
 func (x ServerResponse_MessageType) Number() protoreflect.EnumNumber {
@@ -67,11 +141,141 @@ func (ServerResponse_MessageType) EnumDescriptor() ([]byte, []int) {
 	return file_upload_proto_rawDescGZIP(), []int{0, 0}
 }
 
+type Command_Action int32
+
+const (
+	Command_SET_FPS   Command_Action = 0
+	Command_PAUSE     Command_Action = 1
+	Command_RESUME    Command_Action = 2
+	Command_SNAPSHOT  Command_Action = 3
+	Command_SET_CODEC Command_Action = 4
+	Command_RECONNECT Command_Action = 5
+)
+
+// Enum value maps for Command_Action.
+var (
+	Command_Action_name = map[int32]string{
+		0: "SET_FPS",
+		1: "PAUSE",
+		2: "RESUME",
+		3: "SNAPSHOT",
+		4: "SET_CODEC",
+		5: "RECONNECT",
+	}
+	Command_Action_value = map[string]int32{
+		"SET_FPS":   0,
+		"PAUSE":     1,
+		"RESUME":    2,
+		"SNAPSHOT":  3,
+		"SET_CODEC": 4,
+		"RECONNECT": 5,
+	}
+)
+
+func (x Command_Action) Enum() *Command_Action {
+	p := new(Command_Action)
+	*p = x
+	return p
+}
+
+func (x Command_Action) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (Command_Action) Descriptor() protoreflect.EnumDescriptor {
+	return file_upload_proto_enumTypes[2].Descriptor()
+}
+
+func (Command_Action) Type() protoreflect.EnumType {
+	return &file_upload_proto_enumTypes[2]
+}
+
+func (x Command_Action) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use Command_Action.Descriptor instead.
+func (Command_Action) EnumDescriptor() ([]byte, []int) {
+	return file_upload_proto_rawDescGZIP(), []int{5, 0}
+}
+
+type Error_Code int32
+
+const (
+	Error_UNKNOWN             Error_Code = 0
+	Error_INTERNAL            Error_Code = 1
+	Error_ALREADY_REGISTERED  Error_Code = 2
+	Error_NOT_REGISTERED      Error_Code = 3
+	Error_DUPLICATE_AGENT     Error_Code = 4
+	Error_UNSUPPORTED_VERSION Error_Code = 5
+	Error_ENROLLMENT_REQUIRED Error_Code = 6
+	Error_INVALID_TOKEN       Error_Code = 7
+	Error_INVALID_CREDENTIAL  Error_Code = 8
+	Error_REVOKED             Error_Code = 9
+)
+
+// Enum value maps for Error_Code.
+var (
+	Error_Code_name = map[int32]string{
+		0: "UNKNOWN",
+		1: "INTERNAL",
+		2: "ALREADY_REGISTERED",
+		3: "NOT_REGISTERED",
+		4: "DUPLICATE_AGENT",
+		5: "UNSUPPORTED_VERSION",
+		6: "ENROLLMENT_REQUIRED",
+		7: "INVALID_TOKEN",
+		8: "INVALID_CREDENTIAL",
+		9: "REVOKED",
+	}
+	Error_Code_value = map[string]int32{
+		"UNKNOWN":             0,
+		"INTERNAL":            1,
+		"ALREADY_REGISTERED":  2,
+		"NOT_REGISTERED":      3,
+		"DUPLICATE_AGENT":     4,
+		"UNSUPPORTED_VERSION": 5,
+		"ENROLLMENT_REQUIRED": 6,
+		"INVALID_TOKEN":       7,
+		"INVALID_CREDENTIAL":  8,
+		"REVOKED":             9,
+	}
+)
+
+func (x Error_Code) Enum() *Error_Code {
+	p := new(Error_Code)
+	*p = x
+	return p
+}
+
+func (x Error_Code) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (Error_Code) Descriptor() protoreflect.EnumDescriptor {
+	return file_upload_proto_enumTypes[3].Descriptor()
+}
+
+func (Error_Code) Type() protoreflect.EnumType {
+	return &file_upload_proto_enumTypes[3]
+}
+
+func (x Error_Code) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use Error_Code.Descriptor instead.
+func (Error_Code) EnumDescriptor() ([]byte, []int) {
+	return file_upload_proto_rawDescGZIP(), []int{7, 0}
+}
+
 type ClientRequest_RequestType int32
 
 const (
-	ClientRequest_REGISTER ClientRequest_RequestType = 0
-	ClientRequest_UPLOAD   ClientRequest_RequestType = 1
+	ClientRequest_REGISTER    ClientRequest_RequestType = 0
+	ClientRequest_UPLOAD      ClientRequest_RequestType = 1
+	ClientRequest_COMMAND_ACK ClientRequest_RequestType = 2
+	ClientRequest_PING        ClientRequest_RequestType = 3
 )
 
 // Enum value maps for ClientRequest_RequestType.
@@ -79,10 +283,14 @@ var (
 	ClientRequest_RequestType_name = map[int32]string{
 		0: "REGISTER",
 		1: "UPLOAD",
+		2: "COMMAND_ACK",
+		3: "PING",
 	}
 	ClientRequest_RequestType_value = map[string]int32{
-		"REGISTER": 0,
-		"UPLOAD":   1,
+		"REGISTER":    0,
+		"UPLOAD":      1,
+		"COMMAND_ACK": 2,
+		"PING":        3,
 	}
 )
 
@@ -97,11 +305,11 @@ func (x ClientRequest_RequestType) String() string {
 }
 
 func (ClientRequest_RequestType) Descriptor() protoreflect.EnumDescriptor {
-	return file_upload_proto_enumTypes[1].Descriptor()
+	return file_upload_proto_enumTypes[4].Descriptor()
 }
 
 func (ClientRequest_RequestType) Type() protoreflect.EnumType {
-	return &file_upload_proto_enumTypes[1]
+	return &file_upload_proto_enumTypes[4]
 }
 
 func (x ClientRequest_RequestType) Number() protoreflect.EnumNumber {
@@ -110,7 +318,102 @@ func (x ClientRequest_RequestType) Number() protoreflect.EnumNumber {
 
 // Deprecated: Use ClientRequest_RequestType.Descriptor instead.
 func (ClientRequest_RequestType) EnumDescriptor() ([]byte, []int) {
-	return file_upload_proto_rawDescGZIP(), []int{1, 0}
+	return file_upload_proto_rawDescGZIP(), []int{8, 0}
+}
+
+type ImageUpload_FrameType int32
+
+const (
+	ImageUpload_FULL     ImageUpload_FrameType = 0
+	ImageUpload_KEYFRAME ImageUpload_FrameType = 1
+	ImageUpload_DELTA    ImageUpload_FrameType = 2
+)
+
+// Enum value maps for ImageUpload_FrameType.
+var (
+	ImageUpload_FrameType_name = map[int32]string{
+		0: "FULL",
+		1: "KEYFRAME",
+		2: "DELTA",
+	}
+	ImageUpload_FrameType_value = map[string]int32{
+		"FULL":     0,
+		"KEYFRAME": 1,
+		"DELTA":    2,
+	}
+)
+
+func (x ImageUpload_FrameType) Enum() *ImageUpload_FrameType {
+	p := new(ImageUpload_FrameType)
+	*p = x
+	return p
+}
+
+func (x ImageUpload_FrameType) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (ImageUpload_FrameType) Descriptor() protoreflect.EnumDescriptor {
+	return file_upload_proto_enumTypes[5].Descriptor()
+}
+
+func (ImageUpload_FrameType) Type() protoreflect.EnumType {
+	return &file_upload_proto_enumTypes[5]
+}
+
+func (x ImageUpload_FrameType) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use ImageUpload_FrameType.Descriptor instead.
+func (ImageUpload_FrameType) EnumDescriptor() ([]byte, []int) {
+	return file_upload_proto_rawDescGZIP(), []int{11, 0}
+}
+
+type DisplayInfo_CaptureStatus int32
+
+const (
+	DisplayInfo_OK     DisplayInfo_CaptureStatus = 0
+	DisplayInfo_FAILED DisplayInfo_CaptureStatus = 1
+)
+
+// Enum value maps for DisplayInfo_CaptureStatus.
+var (
+	DisplayInfo_CaptureStatus_name = map[int32]string{
+		0: "OK",
+		1: "FAILED",
+	}
+	DisplayInfo_CaptureStatus_value = map[string]int32{
+		"OK":     0,
+		"FAILED": 1,
+	}
+)
+
+func (x DisplayInfo_CaptureStatus) Enum() *DisplayInfo_CaptureStatus {
+	p := new(DisplayInfo_CaptureStatus)
+	*p = x
+	return p
+}
+
+func (x DisplayInfo_CaptureStatus) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (DisplayInfo_CaptureStatus) Descriptor() protoreflect.EnumDescriptor {
+	return file_upload_proto_enumTypes[6].Descriptor()
+}
+
+func (DisplayInfo_CaptureStatus) Type() protoreflect.EnumType {
+	return &file_upload_proto_enumTypes[6]
+}
+
+func (x DisplayInfo_CaptureStatus) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use DisplayInfo_CaptureStatus.Descriptor instead.
+func (DisplayInfo_CaptureStatus) EnumDescriptor() ([]byte, []int) {
+	return file_upload_proto_rawDescGZIP(), []int{13, 0}
 }
 
 // Server response command container
@@ -119,7 +422,8 @@ type ServerResponse struct {
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
-	Type ServerResponse_MessageType `protobuf:"varint,1,opt,name=type,proto3,enum=upload.ServerResponse_MessageType" json:"type,omitempty"`
+	Type     ServerResponse_MessageType `protobuf:"varint,1,opt,name=type,proto3,enum=upload.ServerResponse_MessageType" json:"type,omitempty"`
+	Response []byte                     `protobuf:"bytes,2,opt,name=response,proto3" json:"response,omitempty"`
 }
 
 func (x *ServerResponse) Reset() {
@@ -161,18 +465,31 @@ func (x *ServerResponse) GetType() ServerResponse_MessageType {
 	return ServerResponse_AUTHENTICATED
 }
 
-// Client message container
-type ClientRequest struct {
+func (x *ServerResponse) GetResponse() []byte {
+	if x != nil {
+		return x.Response
+	}
+	return nil
+}
+
+// Server registration acceptance with negotiated protocol
+type Authenticated struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
-	Type    ClientRequest_RequestType `protobuf:"varint,1,opt,name=type,proto3,enum=upload.ClientRequest_RequestType" json:"type,omitempty"`
-	Request []byte                    `protobuf:"bytes,2,opt,name=request,proto3" json:"request,omitempty"`
+	ProtocolVersion     uint32      `protobuf:"varint,1,opt,name=protocol_version,json=protocolVersion,proto3" json:"protocol_version,omitempty"`
+	Capabilities        []string    `protobuf:"bytes,2,rep,name=capabilities,proto3" json:"capabilities,omitempty"`
+	Credential          *Credential `protobuf:"bytes,3,opt,name=credential,proto3" json:"credential,omitempty"`
+	Codec               string      `protobuf:"bytes,4,opt,name=codec,proto3" json:"codec,omitempty"`
+	Quality             uint32      `protobuf:"varint,5,opt,name=quality,proto3" json:"quality,omitempty"`
+	OnDemand            bool        `protobuf:"varint,6,opt,name=on_demand,json=onDemand,proto3" json:"on_demand,omitempty"`
+	BackgroundFps       float32     `protobuf:"fixed32,7,opt,name=background_fps,json=backgroundFps,proto3" json:"background_fps,omitempty"`
+	HeartbeatIntervalMs uint32      `protobuf:"varint,8,opt,name=heartbeat_interval_ms,json=heartbeatIntervalMs,proto3" json:"heartbeat_interval_ms,omitempty"`
 }
 
-func (x *ClientRequest) Reset() {
-	*x = ClientRequest{}
+func (x *Authenticated) Reset() {
+	*x = Authenticated{}
 	if protoimpl.UnsafeEnabled {
 		mi := &file_upload_proto_msgTypes[1]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
@@ -180,13 +497,13 @@ func (x *ClientRequest) Reset() {
 	}
 }
 
-func (x *ClientRequest) String() string {
+func (x *Authenticated) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*ClientRequest) ProtoMessage() {}
+func (*Authenticated) ProtoMessage() {}
 
-func (x *ClientRequest) ProtoReflect() protoreflect.Message {
+func (x *Authenticated) ProtoReflect() protoreflect.Message {
 	mi := &file_upload_proto_msgTypes[1]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
@@ -198,37 +515,79 @@ func (x *ClientRequest) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use ClientRequest.ProtoReflect.Descriptor instead.
-func (*ClientRequest) Descriptor() ([]byte, []int) {
+// Deprecated: Use Authenticated.ProtoReflect.Descriptor instead.
+func (*Authenticated) Descriptor() ([]byte, []int) {
 	return file_upload_proto_rawDescGZIP(), []int{1}
 }
 
-func (x *ClientRequest) GetType() ClientRequest_RequestType {
+func (x *Authenticated) GetProtocolVersion() uint32 {
 	if x != nil {
-		return x.Type
+		return x.ProtocolVersion
 	}
-	return ClientRequest_REGISTER
+	return 0
 }
 
-func (x *ClientRequest) GetRequest() []byte {
+func (x *Authenticated) GetCapabilities() []string {
 	if x != nil {
-		return x.Request
+		return x.Capabilities
 	}
 	return nil
 }
 
-// Client registration command
-type Register struct {
+func (x *Authenticated) GetCredential() *Credential {
+	if x != nil {
+		return x.Credential
+	}
+	return nil
+}
+
+func (x *Authenticated) GetCodec() string {
+	if x != nil {
+		return x.Codec
+	}
+	return ""
+}
+
+func (x *Authenticated) GetQuality() uint32 {
+	if x != nil {
+		return x.Quality
+	}
+	return 0
+}
+
+func (x *Authenticated) GetOnDemand() bool {
+	if x != nil {
+		return x.OnDemand
+	}
+	return false
+}
+
+func (x *Authenticated) GetBackgroundFps() float32 {
+	if x != nil {
+		return x.BackgroundFps
+	}
+	return 0
+}
+
+func (x *Authenticated) GetHeartbeatIntervalMs() uint32 {
+	if x != nil {
+		return x.HeartbeatIntervalMs
+	}
+	return 0
+}
+
+// Keepalive ping from the agent, echoed back by the server as a pong
+type Heartbeat struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
-	Host string `protobuf:"bytes,1,opt,name=host,proto3" json:"host,omitempty"`
-	User string `protobuf:"bytes,2,opt,name=user,proto3" json:"user,omitempty"`
+	Sequence uint64                 `protobuf:"varint,1,opt,name=sequence,proto3" json:"sequence,omitempty"`
+	Sent     *timestamppb.Timestamp `protobuf:"bytes,2,opt,name=sent,proto3" json:"sent,omitempty"`
 }
 
-func (x *Register) Reset() {
-	*x = Register{}
+func (x *Heartbeat) Reset() {
+	*x = Heartbeat{}
 	if protoimpl.UnsafeEnabled {
 		mi := &file_upload_proto_msgTypes[2]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
@@ -236,13 +595,13 @@ func (x *Register) Reset() {
 	}
 }
 
-func (x *Register) String() string {
+func (x *Heartbeat) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*Register) ProtoMessage() {}
+func (*Heartbeat) ProtoMessage() {}
 
-func (x *Register) ProtoReflect() protoreflect.Message {
+func (x *Heartbeat) ProtoReflect() protoreflect.Message {
 	mi := &file_upload_proto_msgTypes[2]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
@@ -254,37 +613,36 @@ func (x *Register) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use Register.ProtoReflect.Descriptor instead.
-func (*Register) Descriptor() ([]byte, []int) {
+// Deprecated: Use Heartbeat.ProtoReflect.Descriptor instead.
+func (*Heartbeat) Descriptor() ([]byte, []int) {
 	return file_upload_proto_rawDescGZIP(), []int{2}
 }
 
-func (x *Register) GetHost() string {
+func (x *Heartbeat) GetSequence() uint64 {
 	if x != nil {
-		return x.Host
+		return x.Sequence
 	}
-	return ""
+	return 0
 }
 
-func (x *Register) GetUser() string {
+func (x *Heartbeat) GetSent() *timestamppb.Timestamp {
 	if x != nil {
-		return x.User
+		return x.Sent
 	}
-	return ""
+	return nil
 }
 
-// Client image upload
-type ImageUpload struct {
+// Stop streaming, optionally sampling at a low rate for recording
+type StreamControl struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
-	Images    [][]byte               `protobuf:"bytes,1,rep,name=images,proto3" json:"images,omitempty"`
-	Timestamp *timestamppb.Timestamp `protobuf:"bytes,2,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	BackgroundFps float32 `protobuf:"fixed32,1,opt,name=background_fps,json=backgroundFps,proto3" json:"background_fps,omitempty"`
 }
 
-func (x *ImageUpload) Reset() {
-	*x = ImageUpload{}
+func (x *StreamControl) Reset() {
+	*x = StreamControl{}
 	if protoimpl.UnsafeEnabled {
 		mi := &file_upload_proto_msgTypes[3]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
@@ -292,13 +650,13 @@ func (x *ImageUpload) Reset() {
 	}
 }
 
-func (x *ImageUpload) String() string {
+func (x *StreamControl) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*ImageUpload) ProtoMessage() {}
+func (*StreamControl) ProtoMessage() {}
 
-func (x *ImageUpload) ProtoReflect() protoreflect.Message {
+func (x *StreamControl) ProtoReflect() protoreflect.Message {
 	mi := &file_upload_proto_msgTypes[3]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
@@ -310,102 +668,1283 @@ func (x *ImageUpload) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use ImageUpload.ProtoReflect.Descriptor instead.
-func (*ImageUpload) Descriptor() ([]byte, []int) {
+// Deprecated: Use StreamControl.ProtoReflect.Descriptor instead.
+func (*StreamControl) Descriptor() ([]byte, []int) {
 	return file_upload_proto_rawDescGZIP(), []int{3}
 }
 
-func (x *ImageUpload) GetImages() [][]byte {
+func (x *StreamControl) GetBackgroundFps() float32 {
 	if x != nil {
-		return x.Images
+		return x.BackgroundFps
 	}
-	return nil
+	return 0
 }
 
-func (x *ImageUpload) GetTimestamp() *timestamppb.Timestamp {
-	if x != nil {
-		return x.Timestamp
-	}
-	return nil
+// Long lived agent credential issued on enrollment
+type Credential struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Id     string `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Secret string `protobuf:"bytes,2,opt,name=secret,proto3" json:"secret,omitempty"`
 }
 
-var File_upload_proto protoreflect.FileDescriptor
+func (x *Credential) Reset() {
+	*x = Credential{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_upload_proto_msgTypes[4]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
 
-var file_upload_proto_rawDesc = []byte{
-	0x0a, 0x0c, 0x75, 0x70, 0x6c, 0x6f, 0x61, 0x64, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12, 0x06,
-	0x75, 0x70, 0x6c, 0x6f, 0x61, 0x64, 0x1a, 0x1f, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2f, 0x70,
-	0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2f, 0x74, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d,
-	0x70, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x22, 0x74, 0x0a, 0x0e, 0x53, 0x65, 0x72, 0x76, 0x65,
-	0x72, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x36, 0x0a, 0x04, 0x74, 0x79, 0x70,
-	0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0e, 0x32, 0x22, 0x2e, 0x75, 0x70, 0x6c, 0x6f, 0x61, 0x64,
-	0x2e, 0x53, 0x65, 0x72, 0x76, 0x65, 0x72, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x2e,
-	0x4d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x54, 0x79, 0x70, 0x65, 0x52, 0x04, 0x74, 0x79, 0x70,
-	0x65, 0x22, 0x2a, 0x0a, 0x0b, 0x4d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x54, 0x79, 0x70, 0x65,
-	0x12, 0x11, 0x0a, 0x0d, 0x41, 0x55, 0x54, 0x48, 0x45, 0x4e, 0x54, 0x49, 0x43, 0x41, 0x54, 0x45,
-	0x44, 0x10, 0x00, 0x12, 0x08, 0x0a, 0x04, 0x51, 0x55, 0x49, 0x54, 0x10, 0x01, 0x22, 0x89, 0x01,
-	0x0a, 0x0d, 0x43, 0x6c, 0x69, 0x65, 0x6e, 0x74, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12,
-	0x35, 0x0a, 0x04, 0x74, 0x79, 0x70, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0e, 0x32, 0x21, 0x2e,
-	0x75, 0x70, 0x6c, 0x6f, 0x61, 0x64, 0x2e, 0x43, 0x6c, 0x69, 0x65, 0x6e, 0x74, 0x52, 0x65, 0x71,
-	0x75, 0x65, 0x73, 0x74, 0x2e, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x54, 0x79, 0x70, 0x65,
-	0x52, 0x04, 0x74, 0x79, 0x70, 0x65, 0x12, 0x18, 0x0a, 0x07, 0x72, 0x65, 0x71, 0x75, 0x65, 0x73,
-	0x74, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x07, 0x72, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74,
-	0x22, 0x27, 0x0a, 0x0b, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x54, 0x79, 0x70, 0x65, 0x12,
-	0x0c, 0x0a, 0x08, 0x52, 0x45, 0x47, 0x49, 0x53, 0x54, 0x45, 0x52, 0x10, 0x00, 0x12, 0x0a, 0x0a,
-	0x06, 0x55, 0x50, 0x4c, 0x4f, 0x41, 0x44, 0x10, 0x01, 0x22, 0x32, 0x0a, 0x08, 0x52, 0x65, 0x67,
-	0x69, 0x73, 0x74, 0x65, 0x72, 0x12, 0x12, 0x0a, 0x04, 0x68, 0x6f, 0x73, 0x74, 0x18, 0x01, 0x20,
-	0x01, 0x28, 0x09, 0x52, 0x04, 0x68, 0x6f, 0x73, 0x74, 0x12, 0x12, 0x0a, 0x04, 0x75, 0x73, 0x65,
-	0x72, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x75, 0x73, 0x65, 0x72, 0x22, 0x5f, 0x0a,
-	0x0b, 0x49, 0x6d, 0x61, 0x67, 0x65, 0x55, 0x70, 0x6c, 0x6f, 0x61, 0x64, 0x12, 0x16, 0x0a, 0x06,
-	0x69, 0x6d, 0x61, 0x67, 0x65, 0x73, 0x18, 0x01, 0x20, 0x03, 0x28, 0x0c, 0x52, 0x06, 0x69, 0x6d,
-	0x61, 0x67, 0x65, 0x73, 0x12, 0x38, 0x0a, 0x09, 0x74, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d,
-	0x70, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1a, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65,
-	0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x54, 0x69, 0x6d, 0x65, 0x73, 0x74,
-	0x61, 0x6d, 0x70, 0x52, 0x09, 0x74, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x42, 0x0c,
-	0x5a, 0x0a, 0x2e, 0x2f, 0x75, 0x70, 0x6c, 0x6f, 0x61, 0x64, 0x70, 0x62, 0x62, 0x06, 0x70, 0x72,
-	0x6f, 0x74, 0x6f, 0x33,
+func (x *Credential) String() string {
+	return protoimpl.X.MessageStringOf(x)
 }
 
-var (
-	file_upload_proto_rawDescOnce sync.Once
-	file_upload_proto_rawDescData = file_upload_proto_rawDesc
-)
+func (*Credential) ProtoMessage() {}
 
-func file_upload_proto_rawDescGZIP() []byte {
-	file_upload_proto_rawDescOnce.Do(func() {
-		file_upload_proto_rawDescData = protoimpl.X.CompressGZIP(file_upload_proto_rawDescData)
-	})
-	return file_upload_proto_rawDescData
+func (x *Credential) ProtoReflect() protoreflect.Message {
+	mi := &file_upload_proto_msgTypes[4]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
 }
 
-var file_upload_proto_enumTypes = make([]protoimpl.EnumInfo, 2)
-var file_upload_proto_msgTypes = make([]protoimpl.MessageInfo, 4)
-var file_upload_proto_goTypes = []interface{}{
-	(ServerResponse_MessageType)(0), // 0: upload.ServerResponse.MessageType
-	(ClientRequest_RequestType)(0),  // 1: upload.ClientRequest.RequestType
-	(*ServerResponse)(nil),          // 2: upload.ServerResponse
-	(*ClientRequest)(nil),           // 3: upload.ClientRequest
-	(*Register)(nil),                // 4: upload.Register
-	(*ImageUpload)(nil),             // 5: upload.ImageUpload
-	(*timestamppb.Timestamp)(nil),   // 6: google.protobuf.Timestamp
-}
-var file_upload_proto_depIdxs = []int32{
-	0, // 0: upload.ServerResponse.type:type_name -> upload.ServerResponse.MessageType
-	1, // 1: upload.ClientRequest.type:type_name -> upload.ClientRequest.RequestType
-	6, // 2: upload.ImageUpload.timestamp:type_name -> google.protobuf.Timestamp
-	3, // [3:3] is the sub-list for method output_type
-	3, // [3:3] is the sub-list for method input_type
-	3, // [3:3] is the sub-list for extension type_name
-	3, // [3:3] is the sub-list for extension extendee
-	0, // [0:3] is the sub-list for field type_name
+// Deprecated: Use Credential.ProtoReflect.Descriptor instead.
+func (*Credential) Descriptor() ([]byte, []int) {
+	return file_upload_proto_rawDescGZIP(), []int{4}
 }
 
-func init() { file_upload_proto_init() }
-func file_upload_proto_init() {
-	if File_upload_proto != nil {
-		return
+func (x *Credential) GetId() string {
+	if x != nil {
+		return x.Id
 	}
-	if !protoimpl.UnsafeEnabled {
-		file_upload_proto_msgTypes[0].Exporter = func(v interface{}, i int) interface{} {
+	return ""
+}
+
+func (x *Credential) GetSecret() string {
+	if x != nil {
+		return x.Secret
+	}
+	return ""
+}
+
+// Operator command sent to an agent
+type Command struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Id      uint64         `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	Action  Command_Action `protobuf:"varint,2,opt,name=action,proto3,enum=upload.Command_Action" json:"action,omitempty"`
+	Fps     uint32         `protobuf:"varint,3,opt,name=fps,proto3" json:"fps,omitempty"`
+	Codec   string         `protobuf:"bytes,4,opt,name=codec,proto3" json:"codec,omitempty"`
+	Quality uint32         `protobuf:"varint,5,opt,name=quality,proto3" json:"quality,omitempty"`
+}
+
+func (x *Command) Reset() {
+	*x = Command{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_upload_proto_msgTypes[5]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Command) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Command) ProtoMessage() {}
+
+func (x *Command) ProtoReflect() protoreflect.Message {
+	mi := &file_upload_proto_msgTypes[5]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Command.ProtoReflect.Descriptor instead.
+func (*Command) Descriptor() ([]byte, []int) {
+	return file_upload_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *Command) GetId() uint64 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+func (x *Command) GetAction() Command_Action {
+	if x != nil {
+		return x.Action
+	}
+	return Command_SET_FPS
+}
+
+func (x *Command) GetFps() uint32 {
+	if x != nil {
+		return x.Fps
+	}
+	return 0
+}
+
+func (x *Command) GetCodec() string {
+	if x != nil {
+		return x.Codec
+	}
+	return ""
+}
+
+func (x *Command) GetQuality() uint32 {
+	if x != nil {
+		return x.Quality
+	}
+	return 0
+}
+
+// Server registration rejection
+type Rejected struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Reason             string `protobuf:"bytes,1,opt,name=reason,proto3" json:"reason,omitempty"`
+	MinProtocolVersion uint32 `protobuf:"varint,2,opt,name=min_protocol_version,json=minProtocolVersion,proto3" json:"min_protocol_version,omitempty"`
+	MaxProtocolVersion uint32 `protobuf:"varint,3,opt,name=max_protocol_version,json=maxProtocolVersion,proto3" json:"max_protocol_version,omitempty"`
+}
+
+func (x *Rejected) Reset() {
+	*x = Rejected{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_upload_proto_msgTypes[6]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Rejected) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Rejected) ProtoMessage() {}
+
+func (x *Rejected) ProtoReflect() protoreflect.Message {
+	mi := &file_upload_proto_msgTypes[6]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Rejected.ProtoReflect.Descriptor instead.
+func (*Rejected) Descriptor() ([]byte, []int) {
+	return file_upload_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *Rejected) GetReason() string {
+	if x != nil {
+		return x.Reason
+	}
+	return ""
+}
+
+func (x *Rejected) GetMinProtocolVersion() uint32 {
+	if x != nil {
+		return x.MinProtocolVersion
+	}
+	return 0
+}
+
+func (x *Rejected) GetMaxProtocolVersion() uint32 {
+	if x != nil {
+		return x.MaxProtocolVersion
+	}
+	return 0
+}
+
+// Server error telling the agent why its request failed and how to react
+type Error struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Code         Error_Code `protobuf:"varint,1,opt,name=code,proto3,enum=upload.Error_Code" json:"code,omitempty"`
+	Message      string     `protobuf:"bytes,2,opt,name=message,proto3" json:"message,omitempty"`
+	RetryAfterMs uint32     `protobuf:"varint,3,opt,name=retry_after_ms,json=retryAfterMs,proto3" json:"retry_after_ms,omitempty"`
+}
+
+func (x *Error) Reset() {
+	*x = Error{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_upload_proto_msgTypes[7]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Error) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Error) ProtoMessage() {}
+
+func (x *Error) ProtoReflect() protoreflect.Message {
+	mi := &file_upload_proto_msgTypes[7]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Error.ProtoReflect.Descriptor instead.
+func (*Error) Descriptor() ([]byte, []int) {
+	return file_upload_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *Error) GetCode() Error_Code {
+	if x != nil {
+		return x.Code
+	}
+	return Error_UNKNOWN
+}
+
+func (x *Error) GetMessage() string {
+	if x != nil {
+		return x.Message
+	}
+	return ""
+}
+
+func (x *Error) GetRetryAfterMs() uint32 {
+	if x != nil {
+		return x.RetryAfterMs
+	}
+	return 0
+}
+
+// Client message container
+type ClientRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Type    ClientRequest_RequestType `protobuf:"varint,1,opt,name=type,proto3,enum=upload.ClientRequest_RequestType" json:"type,omitempty"`
+	Request []byte                    `protobuf:"bytes,2,opt,name=request,proto3" json:"request,omitempty"`
+}
+
+func (x *ClientRequest) Reset() {
+	*x = ClientRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_upload_proto_msgTypes[8]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ClientRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ClientRequest) ProtoMessage() {}
+
+func (x *ClientRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_upload_proto_msgTypes[8]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ClientRequest.ProtoReflect.Descriptor instead.
+func (*ClientRequest) Descriptor() ([]byte, []int) {
+	return file_upload_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *ClientRequest) GetType() ClientRequest_RequestType {
+	if x != nil {
+		return x.Type
+	}
+	return ClientRequest_REGISTER
+}
+
+func (x *ClientRequest) GetRequest() []byte {
+	if x != nil {
+		return x.Request
+	}
+	return nil
+}
+
+// Client registration command
+type Register struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Host            string      `protobuf:"bytes,1,opt,name=host,proto3" json:"host,omitempty"`
+	User            string      `protobuf:"bytes,2,opt,name=user,proto3" json:"user,omitempty"`
+	ProtocolVersion uint32      `protobuf:"varint,3,opt,name=protocol_version,json=protocolVersion,proto3" json:"protocol_version,omitempty"`
+	AgentVersion    string      `protobuf:"bytes,4,opt,name=agent_version,json=agentVersion,proto3" json:"agent_version,omitempty"`
+	Capabilities    []string    `protobuf:"bytes,5,rep,name=capabilities,proto3" json:"capabilities,omitempty"`
+	EnrollmentToken string      `protobuf:"bytes,6,opt,name=enrollment_token,json=enrollmentToken,proto3" json:"enrollment_token,omitempty"`
+	Credential      *Credential `protobuf:"bytes,7,opt,name=credential,proto3" json:"credential,omitempty"`
+	AgentId         string      `protobuf:"bytes,8,opt,name=agent_id,json=agentId,proto3" json:"agent_id,omitempty"`
+	Codecs          []string    `protobuf:"bytes,9,rep,name=codecs,proto3" json:"codecs,omitempty"`
+}
+
+func (x *Register) Reset() {
+	*x = Register{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_upload_proto_msgTypes[9]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Register) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Register) ProtoMessage() {}
+
+func (x *Register) ProtoReflect() protoreflect.Message {
+	mi := &file_upload_proto_msgTypes[9]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Register.ProtoReflect.Descriptor instead.
+func (*Register) Descriptor() ([]byte, []int) {
+	return file_upload_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *Register) GetHost() string {
+	if x != nil {
+		return x.Host
+	}
+	return ""
+}
+
+func (x *Register) GetUser() string {
+	if x != nil {
+		return x.User
+	}
+	return ""
+}
+
+func (x *Register) GetProtocolVersion() uint32 {
+	if x != nil {
+		return x.ProtocolVersion
+	}
+	return 0
+}
+
+func (x *Register) GetAgentVersion() string {
+	if x != nil {
+		return x.AgentVersion
+	}
+	return ""
+}
+
+func (x *Register) GetCapabilities() []string {
+	if x != nil {
+		return x.Capabilities
+	}
+	return nil
+}
+
+func (x *Register) GetEnrollmentToken() string {
+	if x != nil {
+		return x.EnrollmentToken
+	}
+	return ""
+}
+
+func (x *Register) GetCredential() *Credential {
+	if x != nil {
+		return x.Credential
+	}
+	return nil
+}
+
+func (x *Register) GetAgentId() string {
+	if x != nil {
+		return x.AgentId
+	}
+	return ""
+}
+
+func (x *Register) GetCodecs() []string {
+	if x != nil {
+		return x.Codecs
+	}
+	return nil
+}
+
+// Agent acknowledgement of a command
+type CommandAck struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Id    uint64 `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	Ok    bool   `protobuf:"varint,2,opt,name=ok,proto3" json:"ok,omitempty"`
+	Error string `protobuf:"bytes,3,opt,name=error,proto3" json:"error,omitempty"`
+}
+
+func (x *CommandAck) Reset() {
+	*x = CommandAck{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_upload_proto_msgTypes[10]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *CommandAck) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CommandAck) ProtoMessage() {}
+
+func (x *CommandAck) ProtoReflect() protoreflect.Message {
+	mi := &file_upload_proto_msgTypes[10]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CommandAck.ProtoReflect.Descriptor instead.
+func (*CommandAck) Descriptor() ([]byte, []int) {
+	return file_upload_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *CommandAck) GetId() uint64 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+func (x *CommandAck) GetOk() bool {
+	if x != nil {
+		return x.Ok
+	}
+	return false
+}
+
+func (x *CommandAck) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+// Client image upload
+type ImageUpload struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Images       [][]byte               `protobuf:"bytes,1,rep,name=images,proto3" json:"images,omitempty"`
+	Timestamp    *timestamppb.Timestamp `protobuf:"bytes,2,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	FrameType    ImageUpload_FrameType  `protobuf:"varint,3,opt,name=frame_type,json=frameType,proto3,enum=upload.ImageUpload_FrameType" json:"frame_type,omitempty"`
+	Sequence     uint64                 `protobuf:"varint,4,opt,name=sequence,proto3" json:"sequence,omitempty"`
+	Deltas       []*DisplayDelta        `protobuf:"bytes,5,rep,name=deltas,proto3" json:"deltas,omitempty"`
+	Formats      []ImageFormat          `protobuf:"varint,6,rep,packed,name=formats,proto3,enum=upload.ImageFormat" json:"formats,omitempty"`
+	Displays     []*DisplayInfo         `protobuf:"bytes,7,rep,name=displays,proto3" json:"displays,omitempty"`
+	EffectiveFps float32                `protobuf:"fixed32,8,opt,name=effective_fps,json=effectiveFps,proto3" json:"effective_fps,omitempty"`
+	Settings     *CaptureSettings       `protobuf:"bytes,9,opt,name=settings,proto3" json:"settings,omitempty"`
+	Snapshot     bool                   `protobuf:"varint,10,opt,name=snapshot,proto3" json:"snapshot,omitempty"`
+	Backfill     bool                   `protobuf:"varint,11,opt,name=backfill,proto3" json:"backfill,omitempty"`
+}
+
+func (x *ImageUpload) Reset() {
+	*x = ImageUpload{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_upload_proto_msgTypes[11]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ImageUpload) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ImageUpload) ProtoMessage() {}
+
+func (x *ImageUpload) ProtoReflect() protoreflect.Message {
+	mi := &file_upload_proto_msgTypes[11]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ImageUpload.ProtoReflect.Descriptor instead.
+func (*ImageUpload) Descriptor() ([]byte, []int) {
+	return file_upload_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *ImageUpload) GetImages() [][]byte {
+	if x != nil {
+		return x.Images
+	}
+	return nil
+}
+
+func (x *ImageUpload) GetTimestamp() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Timestamp
+	}
+	return nil
+}
+
+func (x *ImageUpload) GetFrameType() ImageUpload_FrameType {
+	if x != nil {
+		return x.FrameType
+	}
+	return ImageUpload_FULL
+}
+
+func (x *ImageUpload) GetSequence() uint64 {
+	if x != nil {
+		return x.Sequence
+	}
+	return 0
+}
+
+func (x *ImageUpload) GetDeltas() []*DisplayDelta {
+	if x != nil {
+		return x.Deltas
+	}
+	return nil
+}
+
+func (x *ImageUpload) GetFormats() []ImageFormat {
+	if x != nil {
+		return x.Formats
+	}
+	return nil
+}
+
+func (x *ImageUpload) GetDisplays() []*DisplayInfo {
+	if x != nil {
+		return x.Displays
+	}
+	return nil
+}
+
+func (x *ImageUpload) GetEffectiveFps() float32 {
+	if x != nil {
+		return x.EffectiveFps
+	}
+	return 0
+}
+
+func (x *ImageUpload) GetSettings() *CaptureSettings {
+	if x != nil {
+		return x.Settings
+	}
+	return nil
+}
+
+func (x *ImageUpload) GetSnapshot() bool {
+	if x != nil {
+		return x.Snapshot
+	}
+	return false
+}
+
+func (x *ImageUpload) GetBackfill() bool {
+	if x != nil {
+		return x.Backfill
+	}
+	return false
+}
+
+// Capture settings an agent is currently using
+type CaptureSettings struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Fps            float32 `protobuf:"fixed32,1,opt,name=fps,proto3" json:"fps,omitempty"`
+	Scale          float32 `protobuf:"fixed32,2,opt,name=scale,proto3" json:"scale,omitempty"`
+	Quality        uint32  `protobuf:"varint,3,opt,name=quality,proto3" json:"quality,omitempty"`
+	BandwidthLimit uint64  `protobuf:"varint,4,opt,name=bandwidth_limit,json=bandwidthLimit,proto3" json:"bandwidth_limit,omitempty"`
+	Throughput     uint64  `protobuf:"varint,5,opt,name=throughput,proto3" json:"throughput,omitempty"`
+	SendLatencyMs  uint32  `protobuf:"varint,6,opt,name=send_latency_ms,json=sendLatencyMs,proto3" json:"send_latency_ms,omitempty"`
+}
+
+func (x *CaptureSettings) Reset() {
+	*x = CaptureSettings{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_upload_proto_msgTypes[12]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *CaptureSettings) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CaptureSettings) ProtoMessage() {}
+
+func (x *CaptureSettings) ProtoReflect() protoreflect.Message {
+	mi := &file_upload_proto_msgTypes[12]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CaptureSettings.ProtoReflect.Descriptor instead.
+func (*CaptureSettings) Descriptor() ([]byte, []int) {
+	return file_upload_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *CaptureSettings) GetFps() float32 {
+	if x != nil {
+		return x.Fps
+	}
+	return 0
+}
+
+func (x *CaptureSettings) GetScale() float32 {
+	if x != nil {
+		return x.Scale
+	}
+	return 0
+}
+
+func (x *CaptureSettings) GetQuality() uint32 {
+	if x != nil {
+		return x.Quality
+	}
+	return 0
+}
+
+func (x *CaptureSettings) GetBandwidthLimit() uint64 {
+	if x != nil {
+		return x.BandwidthLimit
+	}
+	return 0
+}
+
+func (x *CaptureSettings) GetThroughput() uint64 {
+	if x != nil {
+		return x.Throughput
+	}
+	return 0
+}
+
+func (x *CaptureSettings) GetSendLatencyMs() uint32 {
+	if x != nil {
+		return x.SendLatencyMs
+	}
+	return 0
+}
+
+// Capture metadata for the display at the same index in an upload
+type DisplayInfo struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Index  uint32                    `protobuf:"varint,1,opt,name=index,proto3" json:"index,omitempty"`
+	X      int32                     `protobuf:"varint,2,opt,name=x,proto3" json:"x,omitempty"`
+	Y      int32                     `protobuf:"varint,3,opt,name=y,proto3" json:"y,omitempty"`
+	Width  uint32                    `protobuf:"varint,4,opt,name=width,proto3" json:"width,omitempty"`
+	Height uint32                    `protobuf:"varint,5,opt,name=height,proto3" json:"height,omitempty"`
+	Scale  float32                   `protobuf:"fixed32,6,opt,name=scale,proto3" json:"scale,omitempty"`
+	Status DisplayInfo_CaptureStatus `protobuf:"varint,7,opt,name=status,proto3,enum=upload.DisplayInfo_CaptureStatus" json:"status,omitempty"`
+	Error  string                    `protobuf:"bytes,8,opt,name=error,proto3" json:"error,omitempty"`
+}
+
+func (x *DisplayInfo) Reset() {
+	*x = DisplayInfo{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_upload_proto_msgTypes[13]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *DisplayInfo) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DisplayInfo) ProtoMessage() {}
+
+func (x *DisplayInfo) ProtoReflect() protoreflect.Message {
+	mi := &file_upload_proto_msgTypes[13]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DisplayInfo.ProtoReflect.Descriptor instead.
+func (*DisplayInfo) Descriptor() ([]byte, []int) {
+	return file_upload_proto_rawDescGZIP(), []int{13}
+}
+
+func (x *DisplayInfo) GetIndex() uint32 {
+	if x != nil {
+		return x.Index
+	}
+	return 0
+}
+
+func (x *DisplayInfo) GetX() int32 {
+	if x != nil {
+		return x.X
+	}
+	return 0
+}
+
+func (x *DisplayInfo) GetY() int32 {
+	if x != nil {
+		return x.Y
+	}
+	return 0
+}
+
+func (x *DisplayInfo) GetWidth() uint32 {
+	if x != nil {
+		return x.Width
+	}
+	return 0
+}
+
+func (x *DisplayInfo) GetHeight() uint32 {
+	if x != nil {
+		return x.Height
+	}
+	return 0
+}
+
+func (x *DisplayInfo) GetScale() float32 {
+	if x != nil {
+		return x.Scale
+	}
+	return 0
+}
+
+func (x *DisplayInfo) GetStatus() DisplayInfo_CaptureStatus {
+	if x != nil {
+		return x.Status
+	}
+	return DisplayInfo_OK
+}
+
+func (x *DisplayInfo) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+// Changed tiles of one display since the previous frame
+type DisplayDelta struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Display uint32  `protobuf:"varint,1,opt,name=display,proto3" json:"display,omitempty"`
+	Width   uint32  `protobuf:"varint,2,opt,name=width,proto3" json:"width,omitempty"`
+	Height  uint32  `protobuf:"varint,3,opt,name=height,proto3" json:"height,omitempty"`
+	Tiles   []*Tile `protobuf:"bytes,4,rep,name=tiles,proto3" json:"tiles,omitempty"`
+}
+
+func (x *DisplayDelta) Reset() {
+	*x = DisplayDelta{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_upload_proto_msgTypes[14]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *DisplayDelta) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DisplayDelta) ProtoMessage() {}
+
+func (x *DisplayDelta) ProtoReflect() protoreflect.Message {
+	mi := &file_upload_proto_msgTypes[14]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DisplayDelta.ProtoReflect.Descriptor instead.
+func (*DisplayDelta) Descriptor() ([]byte, []int) {
+	return file_upload_proto_rawDescGZIP(), []int{14}
+}
+
+func (x *DisplayDelta) GetDisplay() uint32 {
+	if x != nil {
+		return x.Display
+	}
+	return 0
+}
+
+func (x *DisplayDelta) GetWidth() uint32 {
+	if x != nil {
+		return x.Width
+	}
+	return 0
+}
+
+func (x *DisplayDelta) GetHeight() uint32 {
+	if x != nil {
+		return x.Height
+	}
+	return 0
+}
+
+func (x *DisplayDelta) GetTiles() []*Tile {
+	if x != nil {
+		return x.Tiles
+	}
+	return nil
+}
+
+// Encoded image of a changed region
+type Tile struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	X      uint32      `protobuf:"varint,1,opt,name=x,proto3" json:"x,omitempty"`
+	Y      uint32      `protobuf:"varint,2,opt,name=y,proto3" json:"y,omitempty"`
+	Width  uint32      `protobuf:"varint,3,opt,name=width,proto3" json:"width,omitempty"`
+	Height uint32      `protobuf:"varint,4,opt,name=height,proto3" json:"height,omitempty"`
+	Data   []byte      `protobuf:"bytes,5,opt,name=data,proto3" json:"data,omitempty"`
+	Format ImageFormat `protobuf:"varint,6,opt,name=format,proto3,enum=upload.ImageFormat" json:"format,omitempty"`
+}
+
+func (x *Tile) Reset() {
+	*x = Tile{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_upload_proto_msgTypes[15]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Tile) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Tile) ProtoMessage() {}
+
+func (x *Tile) ProtoReflect() protoreflect.Message {
+	mi := &file_upload_proto_msgTypes[15]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Tile.ProtoReflect.Descriptor instead.
+func (*Tile) Descriptor() ([]byte, []int) {
+	return file_upload_proto_rawDescGZIP(), []int{15}
+}
+
+func (x *Tile) GetX() uint32 {
+	if x != nil {
+		return x.X
+	}
+	return 0
+}
+
+func (x *Tile) GetY() uint32 {
+	if x != nil {
+		return x.Y
+	}
+	return 0
+}
+
+func (x *Tile) GetWidth() uint32 {
+	if x != nil {
+		return x.Width
+	}
+	return 0
+}
+
+func (x *Tile) GetHeight() uint32 {
+	if x != nil {
+		return x.Height
+	}
+	return 0
+}
+
+func (x *Tile) GetData() []byte {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
+func (x *Tile) GetFormat() ImageFormat {
+	if x != nil {
+		return x.Format
+	}
+	return ImageFormat_PNG_ZLIB
+}
+
+var File_upload_proto protoreflect.FileDescriptor
+
+var file_upload_proto_rawDesc = []byte{
+	0x0a, 0x0c, 0x75, 0x70, 0x6c, 0x6f, 0x61, 0x64, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12, 0x06,
+	0x75, 0x70, 0x6c, 0x6f, 0x61, 0x64, 0x1a, 0x1f, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2f, 0x70,
+	0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2f, 0x74, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d,
+	0x70, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x22, 0xfa, 0x01, 0x0a, 0x0e, 0x53, 0x65, 0x72, 0x76,
+	0x65, 0x72, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x36, 0x0a, 0x04, 0x74, 0x79,
+	0x70, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0e, 0x32, 0x22, 0x2e, 0x75, 0x70, 0x6c, 0x6f, 0x61,
+	0x64, 0x2e, 0x53, 0x65, 0x72, 0x76, 0x65, 0x72, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65,
+	0x2e, 0x4d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x54, 0x79, 0x70, 0x65, 0x52, 0x04, 0x74, 0x79,
+	0x70, 0x65, 0x12, 0x1a, 0x0a, 0x08, 0x72, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x18, 0x02,
+	0x20, 0x01, 0x28, 0x0c, 0x52, 0x08, 0x72, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x22, 0x93,
+	0x01, 0x0a, 0x0b, 0x4d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x54, 0x79, 0x70, 0x65, 0x12, 0x11,
+	0x0a, 0x0d, 0x41, 0x55, 0x54, 0x48, 0x45, 0x4e, 0x54, 0x49, 0x43, 0x41, 0x54, 0x45, 0x44, 0x10,
+	0x00, 0x12, 0x08, 0x0a, 0x04, 0x51, 0x55, 0x49, 0x54, 0x10, 0x01, 0x12, 0x0c, 0x0a, 0x08, 0x52,
+	0x45, 0x4a, 0x45, 0x43, 0x54, 0x45, 0x44, 0x10, 0x02, 0x12, 0x14, 0x0a, 0x10, 0x52, 0x45, 0x51,
+	0x55, 0x45, 0x53, 0x54, 0x5f, 0x4b, 0x45, 0x59, 0x46, 0x52, 0x41, 0x4d, 0x45, 0x10, 0x03, 0x12,
+	0x0b, 0x0a, 0x07, 0x43, 0x4f, 0x4d, 0x4d, 0x41, 0x4e, 0x44, 0x10, 0x04, 0x12, 0x10, 0x0a, 0x0c,
+	0x53, 0x54, 0x41, 0x52, 0x54, 0x5f, 0x53, 0x54, 0x52, 0x45, 0x41, 0x4d, 0x10, 0x05, 0x12, 0x0f,
+	0x0a, 0x0b, 0x53, 0x54, 0x4f, 0x50, 0x5f, 0x53, 0x54, 0x52, 0x45, 0x41, 0x4d, 0x10, 0x06, 0x12,
+	0x08, 0x0a, 0x04, 0x50, 0x4f, 0x4e, 0x47, 0x10, 0x07, 0x12, 0x09, 0x0a, 0x05, 0x45, 0x52, 0x52,
+	0x4f, 0x52, 0x10, 0x08, 0x22, 0xba, 0x02, 0x0a, 0x0d, 0x41, 0x75, 0x74, 0x68, 0x65, 0x6e, 0x74,
+	0x69, 0x63, 0x61, 0x74, 0x65, 0x64, 0x12, 0x29, 0x0a, 0x10, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x63,
+	0x6f, 0x6c, 0x5f, 0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0d,
+	0x52, 0x0f, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x63, 0x6f, 0x6c, 0x56, 0x65, 0x72, 0x73, 0x69, 0x6f,
+	0x6e, 0x12, 0x22, 0x0a, 0x0c, 0x63, 0x61, 0x70, 0x61, 0x62, 0x69, 0x6c, 0x69, 0x74, 0x69, 0x65,
+	0x73, 0x18, 0x02, 0x20, 0x03, 0x28, 0x09, 0x52, 0x0c, 0x63, 0x61, 0x70, 0x61, 0x62, 0x69, 0x6c,
+	0x69, 0x74, 0x69, 0x65, 0x73, 0x12, 0x32, 0x0a, 0x0a, 0x63, 0x72, 0x65, 0x64, 0x65, 0x6e, 0x74,
+	0x69, 0x61, 0x6c, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x12, 0x2e, 0x75, 0x70, 0x6c, 0x6f,
+	0x61, 0x64, 0x2e, 0x43, 0x72, 0x65, 0x64, 0x65, 0x6e, 0x74, 0x69, 0x61, 0x6c, 0x52, 0x0a, 0x63,
+	0x72, 0x65, 0x64, 0x65, 0x6e, 0x74, 0x69, 0x61, 0x6c, 0x12, 0x14, 0x0a, 0x05, 0x63, 0x6f, 0x64,
+	0x65, 0x63, 0x18, 0x04, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x63, 0x6f, 0x64, 0x65, 0x63, 0x12,
+	0x18, 0x0a, 0x07, 0x71, 0x75, 0x61, 0x6c, 0x69, 0x74, 0x79, 0x18, 0x05, 0x20, 0x01, 0x28, 0x0d,
+	0x52, 0x07, 0x71, 0x75, 0x61, 0x6c, 0x69, 0x74, 0x79, 0x12, 0x1b, 0x0a, 0x09, 0x6f, 0x6e, 0x5f,
+	0x64, 0x65, 0x6d, 0x61, 0x6e, 0x64, 0x18, 0x06, 0x20, 0x01, 0x28, 0x08, 0x52, 0x08, 0x6f, 0x6e,
+	0x44, 0x65, 0x6d, 0x61, 0x6e, 0x64, 0x12, 0x25, 0x0a, 0x0e, 0x62, 0x61, 0x63, 0x6b, 0x67, 0x72,
+	0x6f, 0x75, 0x6e, 0x64, 0x5f, 0x66, 0x70, 0x73, 0x18, 0x07, 0x20, 0x01, 0x28, 0x02, 0x52, 0x0d,
+	0x62, 0x61, 0x63, 0x6b, 0x67, 0x72, 0x6f, 0x75, 0x6e, 0x64, 0x46, 0x70, 0x73, 0x12, 0x32, 0x0a,
+	0x15, 0x68, 0x65, 0x61, 0x72, 0x74, 0x62, 0x65, 0x61, 0x74, 0x5f, 0x69, 0x6e, 0x74, 0x65, 0x72,
+	0x76, 0x61, 0x6c, 0x5f, 0x6d, 0x73, 0x18, 0x08, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x13, 0x68, 0x65,
+	0x61, 0x72, 0x74, 0x62, 0x65, 0x61, 0x74, 0x49, 0x6e, 0x74, 0x65, 0x72, 0x76, 0x61, 0x6c, 0x4d,
+	0x73, 0x22, 0x57, 0x0a, 0x09, 0x48, 0x65, 0x61, 0x72, 0x74, 0x62, 0x65, 0x61, 0x74, 0x12, 0x1a,
+	0x0a, 0x08, 0x73, 0x65, 0x71, 0x75, 0x65, 0x6e, 0x63, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x04,
+	0x52, 0x08, 0x73, 0x65, 0x71, 0x75, 0x65, 0x6e, 0x63, 0x65, 0x12, 0x2e, 0x0a, 0x04, 0x73, 0x65,
+	0x6e, 0x74, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1a, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c,
+	0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x54, 0x69, 0x6d, 0x65, 0x73,
+	0x74, 0x61, 0x6d, 0x70, 0x52, 0x04, 0x73, 0x65, 0x6e, 0x74, 0x22, 0x36, 0x0a, 0x0d, 0x53, 0x74,
+	0x72, 0x65, 0x61, 0x6d, 0x43, 0x6f, 0x6e, 0x74, 0x72, 0x6f, 0x6c, 0x12, 0x25, 0x0a, 0x0e, 0x62,
+	0x61, 0x63, 0x6b, 0x67, 0x72, 0x6f, 0x75, 0x6e, 0x64, 0x5f, 0x66, 0x70, 0x73, 0x18, 0x01, 0x20,
+	0x01, 0x28, 0x02, 0x52, 0x0d, 0x62, 0x61, 0x63, 0x6b, 0x67, 0x72, 0x6f, 0x75, 0x6e, 0x64, 0x46,
+	0x70, 0x73, 0x22, 0x34, 0x0a, 0x0a, 0x43, 0x72, 0x65, 0x64, 0x65, 0x6e, 0x74, 0x69, 0x61, 0x6c,
+	0x12, 0x0e, 0x0a, 0x02, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x02, 0x69, 0x64,
+	0x12, 0x16, 0x0a, 0x06, 0x73, 0x65, 0x63, 0x72, 0x65, 0x74, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09,
+	0x52, 0x06, 0x73, 0x65, 0x63, 0x72, 0x65, 0x74, 0x22, 0xe5, 0x01, 0x0a, 0x07, 0x43, 0x6f, 0x6d,
+	0x6d, 0x61, 0x6e, 0x64, 0x12, 0x0e, 0x0a, 0x02, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x04,
+	0x52, 0x02, 0x69, 0x64, 0x12, 0x2e, 0x0a, 0x06, 0x61, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x18, 0x02,
+	0x20, 0x01, 0x28, 0x0e, 0x32, 0x16, 0x2e, 0x75, 0x70, 0x6c, 0x6f, 0x61, 0x64, 0x2e, 0x43, 0x6f,
+	0x6d, 0x6d, 0x61, 0x6e, 0x64, 0x2e, 0x41, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x52, 0x06, 0x61, 0x63,
+	0x74, 0x69, 0x6f, 0x6e, 0x12, 0x10, 0x0a, 0x03, 0x66, 0x70, 0x73, 0x18, 0x03, 0x20, 0x01, 0x28,
+	0x0d, 0x52, 0x03, 0x66, 0x70, 0x73, 0x12, 0x14, 0x0a, 0x05, 0x63, 0x6f, 0x64, 0x65, 0x63, 0x18,
+	0x04, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x63, 0x6f, 0x64, 0x65, 0x63, 0x12, 0x18, 0x0a, 0x07,
+	0x71, 0x75, 0x61, 0x6c, 0x69, 0x74, 0x79, 0x18, 0x05, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x07, 0x71,
+	0x75, 0x61, 0x6c, 0x69, 0x74, 0x79, 0x22, 0x58, 0x0a, 0x06, 0x41, 0x63, 0x74, 0x69, 0x6f, 0x6e,
+	0x12, 0x0b, 0x0a, 0x07, 0x53, 0x45, 0x54, 0x5f, 0x46, 0x50, 0x53, 0x10, 0x00, 0x12, 0x09, 0x0a,
+	0x05, 0x50, 0x41, 0x55, 0x53, 0x45, 0x10, 0x01, 0x12, 0x0a, 0x0a, 0x06, 0x52, 0x45, 0x53, 0x55,
+	0x4d, 0x45, 0x10, 0x02, 0x12, 0x0c, 0x0a, 0x08, 0x53, 0x4e, 0x41, 0x50, 0x53, 0x48, 0x4f, 0x54,
+	0x10, 0x03, 0x12, 0x0d, 0x0a, 0x09, 0x53, 0x45, 0x54, 0x5f, 0x43, 0x4f, 0x44, 0x45, 0x43, 0x10,
+	0x04, 0x12, 0x0d, 0x0a, 0x09, 0x52, 0x45, 0x43, 0x4f, 0x4e, 0x4e, 0x45, 0x43, 0x54, 0x10, 0x05,
+	0x22, 0x86, 0x01, 0x0a, 0x08, 0x52, 0x65, 0x6a, 0x65, 0x63, 0x74, 0x65, 0x64, 0x12, 0x16, 0x0a,
+	0x06, 0x72, 0x65, 0x61, 0x73, 0x6f, 0x6e, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x72,
+	0x65, 0x61, 0x73, 0x6f, 0x6e, 0x12, 0x30, 0x0a, 0x14, 0x6d, 0x69, 0x6e, 0x5f, 0x70, 0x72, 0x6f,
+	0x74, 0x6f, 0x63, 0x6f, 0x6c, 0x5f, 0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x18, 0x02, 0x20,
+	0x01, 0x28, 0x0d, 0x52, 0x12, 0x6d, 0x69, 0x6e, 0x50, 0x72, 0x6f, 0x74, 0x6f, 0x63, 0x6f, 0x6c,
+	0x56, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x12, 0x30, 0x0a, 0x14, 0x6d, 0x61, 0x78, 0x5f, 0x70,
+	0x72, 0x6f, 0x74, 0x6f, 0x63, 0x6f, 0x6c, 0x5f, 0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x18,
+	0x03, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x12, 0x6d, 0x61, 0x78, 0x50, 0x72, 0x6f, 0x74, 0x6f, 0x63,
+	0x6f, 0x6c, 0x56, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x22, 0xbe, 0x02, 0x0a, 0x05, 0x45, 0x72,
+	0x72, 0x6f, 0x72, 0x12, 0x26, 0x0a, 0x04, 0x63, 0x6f, 0x64, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28,
+	0x0e, 0x32, 0x12, 0x2e, 0x75, 0x70, 0x6c, 0x6f, 0x61, 0x64, 0x2e, 0x45, 0x72, 0x72, 0x6f, 0x72,
+	0x2e, 0x43, 0x6f, 0x64, 0x65, 0x52, 0x04, 0x63, 0x6f, 0x64, 0x65, 0x12, 0x18, 0x0a, 0x07, 0x6d,
+	0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x07, 0x6d, 0x65,
+	0x73, 0x73, 0x61, 0x67, 0x65, 0x12, 0x24, 0x0a, 0x0e, 0x72, 0x65, 0x74, 0x72, 0x79, 0x5f, 0x61,
+	0x66, 0x74, 0x65, 0x72, 0x5f, 0x6d, 0x73, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x0c, 0x72,
+	0x65, 0x74, 0x72, 0x79, 0x41, 0x66, 0x74, 0x65, 0x72, 0x4d, 0x73, 0x22, 0xcc, 0x01, 0x0a, 0x04,
+	0x43, 0x6f, 0x64, 0x65, 0x12, 0x0b, 0x0a, 0x07, 0x55, 0x4e, 0x4b, 0x4e, 0x4f, 0x57, 0x4e, 0x10,
+	0x00, 0x12, 0x0c, 0x0a, 0x08, 0x49, 0x4e, 0x54, 0x45, 0x52, 0x4e, 0x41, 0x4c, 0x10, 0x01, 0x12,
+	0x16, 0x0a, 0x12, 0x41, 0x4c, 0x52, 0x45, 0x41, 0x44, 0x59, 0x5f, 0x52, 0x45, 0x47, 0x49, 0x53,
+	0x54, 0x45, 0x52, 0x45, 0x44, 0x10, 0x02, 0x12, 0x12, 0x0a, 0x0e, 0x4e, 0x4f, 0x54, 0x5f, 0x52,
+	0x45, 0x47, 0x49, 0x53, 0x54, 0x45, 0x52, 0x45, 0x44, 0x10, 0x03, 0x12, 0x13, 0x0a, 0x0f, 0x44,
+	0x55, 0x50, 0x4c, 0x49, 0x43, 0x41, 0x54, 0x45, 0x5f, 0x41, 0x47, 0x45, 0x4e, 0x54, 0x10, 0x04,
+	0x12, 0x17, 0x0a, 0x13, 0x55, 0x4e, 0x53, 0x55, 0x50, 0x50, 0x4f, 0x52, 0x54, 0x45, 0x44, 0x5f,
+	0x56, 0x45, 0x52, 0x53, 0x49, 0x4f, 0x4e, 0x10, 0x05, 0x12, 0x17, 0x0a, 0x13, 0x45, 0x4e, 0x52,
+	0x4f, 0x4c, 0x4c, 0x4d, 0x45, 0x4e, 0x54, 0x5f, 0x52, 0x45, 0x51, 0x55, 0x49, 0x52, 0x45, 0x44,
+	0x10, 0x06, 0x12, 0x11, 0x0a, 0x0d, 0x49, 0x4e, 0x56, 0x41, 0x4c, 0x49, 0x44, 0x5f, 0x54, 0x4f,
+	0x4b, 0x45, 0x4e, 0x10, 0x07, 0x12, 0x16, 0x0a, 0x12, 0x49, 0x4e, 0x56, 0x41, 0x4c, 0x49, 0x44,
+	0x5f, 0x43, 0x52, 0x45, 0x44, 0x45, 0x4e, 0x54, 0x49, 0x41, 0x4c, 0x10, 0x08, 0x12, 0x0b, 0x0a,
+	0x07, 0x52, 0x45, 0x56, 0x4f, 0x4b, 0x45, 0x44, 0x10, 0x09, 0x22, 0xa4, 0x01, 0x0a, 0x0d, 0x43,
+	0x6c, 0x69, 0x65, 0x6e, 0x74, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x35, 0x0a, 0x04,
+	0x74, 0x79, 0x70, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0e, 0x32, 0x21, 0x2e, 0x75, 0x70, 0x6c,
+	0x6f, 0x61, 0x64, 0x2e, 0x43, 0x6c, 0x69, 0x65, 0x6e, 0x74, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73,
+	0x74, 0x2e, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x54, 0x79, 0x70, 0x65, 0x52, 0x04, 0x74,
+	0x79, 0x70, 0x65, 0x12, 0x18, 0x0a, 0x07, 0x72, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x18, 0x02,
+	0x20, 0x01, 0x28, 0x0c, 0x52, 0x07, 0x72, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x22, 0x42, 0x0a,
+	0x0b, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x54, 0x79, 0x70, 0x65, 0x12, 0x0c, 0x0a, 0x08,
+	0x52, 0x45, 0x47, 0x49, 0x53, 0x54, 0x45, 0x52, 0x10, 0x00, 0x12, 0x0a, 0x0a, 0x06, 0x55, 0x50,
+	0x4c, 0x4f, 0x41, 0x44, 0x10, 0x01, 0x12, 0x0f, 0x0a, 0x0b, 0x43, 0x4f, 0x4d, 0x4d, 0x41, 0x4e,
+	0x44, 0x5f, 0x41, 0x43, 0x4b, 0x10, 0x02, 0x12, 0x08, 0x0a, 0x04, 0x50, 0x49, 0x4e, 0x47, 0x10,
+	0x03, 0x22, 0xb8, 0x02, 0x0a, 0x08, 0x52, 0x65, 0x67, 0x69, 0x73, 0x74, 0x65, 0x72, 0x12, 0x12,
+	0x0a, 0x04, 0x68, 0x6f, 0x73, 0x74, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x68, 0x6f,
+	0x73, 0x74, 0x12, 0x12, 0x0a, 0x04, 0x75, 0x73, 0x65, 0x72, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09,
+	0x52, 0x04, 0x75, 0x73, 0x65, 0x72, 0x12, 0x29, 0x0a, 0x10, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x63,
+	0x6f, 0x6c, 0x5f, 0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0d,
+	0x52, 0x0f, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x63, 0x6f, 0x6c, 0x56, 0x65, 0x72, 0x73, 0x69, 0x6f,
+	0x6e, 0x12, 0x23, 0x0a, 0x0d, 0x61, 0x67, 0x65, 0x6e, 0x74, 0x5f, 0x76, 0x65, 0x72, 0x73, 0x69,
+	0x6f, 0x6e, 0x18, 0x04, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0c, 0x61, 0x67, 0x65, 0x6e, 0x74, 0x56,
+	0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x12, 0x22, 0x0a, 0x0c, 0x63, 0x61, 0x70, 0x61, 0x62, 0x69,
+	0x6c, 0x69, 0x74, 0x69, 0x65, 0x73, 0x18, 0x05, 0x20, 0x03, 0x28, 0x09, 0x52, 0x0c, 0x63, 0x61,
+	0x70, 0x61, 0x62, 0x69, 0x6c, 0x69, 0x74, 0x69, 0x65, 0x73, 0x12, 0x29, 0x0a, 0x10, 0x65, 0x6e,
+	0x72, 0x6f, 0x6c, 0x6c, 0x6d, 0x65, 0x6e, 0x74, 0x5f, 0x74, 0x6f, 0x6b, 0x65, 0x6e, 0x18, 0x06,
+	0x20, 0x01, 0x28, 0x09, 0x52, 0x0f, 0x65, 0x6e, 0x72, 0x6f, 0x6c, 0x6c, 0x6d, 0x65, 0x6e, 0x74,
+	0x54, 0x6f, 0x6b, 0x65, 0x6e, 0x12, 0x32, 0x0a, 0x0a, 0x63, 0x72, 0x65, 0x64, 0x65, 0x6e, 0x74,
+	0x69, 0x61, 0x6c, 0x18, 0x07, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x12, 0x2e, 0x75, 0x70, 0x6c, 0x6f,
+	0x61, 0x64, 0x2e, 0x43, 0x72, 0x65, 0x64, 0x65, 0x6e, 0x74, 0x69, 0x61, 0x6c, 0x52, 0x0a, 0x63,
+	0x72, 0x65, 0x64, 0x65, 0x6e, 0x74, 0x69, 0x61, 0x6c, 0x12, 0x19, 0x0a, 0x08, 0x61, 0x67, 0x65,
+	0x6e, 0x74, 0x5f, 0x69, 0x64, 0x18, 0x08, 0x20, 0x01, 0x28, 0x09, 0x52, 0x07, 0x61, 0x67, 0x65,
+	0x6e, 0x74, 0x49, 0x64, 0x12, 0x16, 0x0a, 0x06, 0x63, 0x6f, 0x64, 0x65, 0x63, 0x73, 0x18, 0x09,
+	0x20, 0x03, 0x28, 0x09, 0x52, 0x06, 0x63, 0x6f, 0x64, 0x65, 0x63, 0x73, 0x22, 0x42, 0x0a, 0x0a,
+	0x43, 0x6f, 0x6d, 0x6d, 0x61, 0x6e, 0x64, 0x41, 0x63, 0x6b, 0x12, 0x0e, 0x0a, 0x02, 0x69, 0x64,
+	0x18, 0x01, 0x20, 0x01, 0x28, 0x04, 0x52, 0x02, 0x69, 0x64, 0x12, 0x0e, 0x0a, 0x02, 0x6f, 0x6b,
+	0x18, 0x02, 0x20, 0x01, 0x28, 0x08, 0x52, 0x02, 0x6f, 0x6b, 0x12, 0x14, 0x0a, 0x05, 0x65, 0x72,
+	0x72, 0x6f, 0x72, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x65, 0x72, 0x72, 0x6f, 0x72,
+	0x22, 0x89, 0x04, 0x0a, 0x0b, 0x49, 0x6d, 0x61, 0x67, 0x65, 0x55, 0x70, 0x6c, 0x6f, 0x61, 0x64,
+	0x12, 0x16, 0x0a, 0x06, 0x69, 0x6d, 0x61, 0x67, 0x65, 0x73, 0x18, 0x01, 0x20, 0x03, 0x28, 0x0c,
+	0x52, 0x06, 0x69, 0x6d, 0x61, 0x67, 0x65, 0x73, 0x12, 0x38, 0x0a, 0x09, 0x74, 0x69, 0x6d, 0x65,
+	0x73, 0x74, 0x61, 0x6d, 0x70, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1a, 0x2e, 0x67, 0x6f,
+	0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x54, 0x69,
+	0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x52, 0x09, 0x74, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61,
+	0x6d, 0x70, 0x12, 0x3c, 0x0a, 0x0a, 0x66, 0x72, 0x61, 0x6d, 0x65, 0x5f, 0x74, 0x79, 0x70, 0x65,
+	0x18, 0x03, 0x20, 0x01, 0x28, 0x0e, 0x32, 0x1d, 0x2e, 0x75, 0x70, 0x6c, 0x6f, 0x61, 0x64, 0x2e,
+	0x49, 0x6d, 0x61, 0x67, 0x65, 0x55, 0x70, 0x6c, 0x6f, 0x61, 0x64, 0x2e, 0x46, 0x72, 0x61, 0x6d,
+	0x65, 0x54, 0x79, 0x70, 0x65, 0x52, 0x09, 0x66, 0x72, 0x61, 0x6d, 0x65, 0x54, 0x79, 0x70, 0x65,
+	0x12, 0x1a, 0x0a, 0x08, 0x73, 0x65, 0x71, 0x75, 0x65, 0x6e, 0x63, 0x65, 0x18, 0x04, 0x20, 0x01,
+	0x28, 0x04, 0x52, 0x08, 0x73, 0x65, 0x71, 0x75, 0x65, 0x6e, 0x63, 0x65, 0x12, 0x2c, 0x0a, 0x06,
+	0x64, 0x65, 0x6c, 0x74, 0x61, 0x73, 0x18, 0x05, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x14, 0x2e, 0x75,
+	0x70, 0x6c, 0x6f, 0x61, 0x64, 0x2e, 0x44, 0x69, 0x73, 0x70, 0x6c, 0x61, 0x79, 0x44, 0x65, 0x6c,
+	0x74, 0x61, 0x52, 0x06, 0x64, 0x65, 0x6c, 0x74, 0x61, 0x73, 0x12, 0x2d, 0x0a, 0x07, 0x66, 0x6f,
+	0x72, 0x6d, 0x61, 0x74, 0x73, 0x18, 0x06, 0x20, 0x03, 0x28, 0x0e, 0x32, 0x13, 0x2e, 0x75, 0x70,
+	0x6c, 0x6f, 0x61, 0x64, 0x2e, 0x49, 0x6d, 0x61, 0x67, 0x65, 0x46, 0x6f, 0x72, 0x6d, 0x61, 0x74,
+	0x52, 0x07, 0x66, 0x6f, 0x72, 0x6d, 0x61, 0x74, 0x73, 0x12, 0x2f, 0x0a, 0x08, 0x64, 0x69, 0x73,
+	0x70, 0x6c, 0x61, 0x79, 0x73, 0x18, 0x07, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x13, 0x2e, 0x75, 0x70,
+	0x6c, 0x6f, 0x61, 0x64, 0x2e, 0x44, 0x69, 0x73, 0x70, 0x6c, 0x61, 0x79, 0x49, 0x6e, 0x66, 0x6f,
+	0x52, 0x08, 0x64, 0x69, 0x73, 0x70, 0x6c, 0x61, 0x79, 0x73, 0x12, 0x23, 0x0a, 0x0d, 0x65, 0x66,
+	0x66, 0x65, 0x63, 0x74, 0x69, 0x76, 0x65, 0x5f, 0x66, 0x70, 0x73, 0x18, 0x08, 0x20, 0x01, 0x28,
+	0x02, 0x52, 0x0c, 0x65, 0x66, 0x66, 0x65, 0x63, 0x74, 0x69, 0x76, 0x65, 0x46, 0x70, 0x73, 0x12,
+	0x33, 0x0a, 0x08, 0x73, 0x65, 0x74, 0x74, 0x69, 0x6e, 0x67, 0x73, 0x18, 0x09, 0x20, 0x01, 0x28,
+	0x0b, 0x32, 0x17, 0x2e, 0x75, 0x70, 0x6c, 0x6f, 0x61, 0x64, 0x2e, 0x43, 0x61, 0x70, 0x74, 0x75,
+	0x72, 0x65, 0x53, 0x65, 0x74, 0x74, 0x69, 0x6e, 0x67, 0x73, 0x52, 0x08, 0x73, 0x65, 0x74, 0x74,
+	0x69, 0x6e, 0x67, 0x73, 0x12, 0x1a, 0x0a, 0x08, 0x73, 0x6e, 0x61, 0x70, 0x73, 0x68, 0x6f, 0x74,
+	0x18, 0x0a, 0x20, 0x01, 0x28, 0x08, 0x52, 0x08, 0x73, 0x6e, 0x61, 0x70, 0x73, 0x68, 0x6f, 0x74,
+	0x12, 0x1a, 0x0a, 0x08, 0x62, 0x61, 0x63, 0x6b, 0x66, 0x69, 0x6c, 0x6c, 0x18, 0x0b, 0x20, 0x01,
+	0x28, 0x08, 0x52, 0x08, 0x62, 0x61, 0x63, 0x6b, 0x66, 0x69, 0x6c, 0x6c, 0x22, 0x2e, 0x0a, 0x09,
+	0x46, 0x72, 0x61, 0x6d, 0x65, 0x54, 0x79, 0x70, 0x65, 0x12, 0x08, 0x0a, 0x04, 0x46, 0x55, 0x4c,
+	0x4c, 0x10, 0x00, 0x12, 0x0c, 0x0a, 0x08, 0x4b, 0x45, 0x59, 0x46, 0x52, 0x41, 0x4d, 0x45, 0x10,
+	0x01, 0x12, 0x09, 0x0a, 0x05, 0x44, 0x45, 0x4c, 0x54, 0x41, 0x10, 0x02, 0x22, 0xc4, 0x01, 0x0a,
+	0x0f, 0x43, 0x61, 0x70, 0x74, 0x75, 0x72, 0x65, 0x53, 0x65, 0x74, 0x74, 0x69, 0x6e, 0x67, 0x73,
+	0x12, 0x10, 0x0a, 0x03, 0x66, 0x70, 0x73, 0x18, 0x01, 0x20, 0x01, 0x28, 0x02, 0x52, 0x03, 0x66,
+	0x70, 0x73, 0x12, 0x14, 0x0a, 0x05, 0x73, 0x63, 0x61, 0x6c, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28,
+	0x02, 0x52, 0x05, 0x73, 0x63, 0x61, 0x6c, 0x65, 0x12, 0x18, 0x0a, 0x07, 0x71, 0x75, 0x61, 0x6c,
+	0x69, 0x74, 0x79, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x07, 0x71, 0x75, 0x61, 0x6c, 0x69,
+	0x74, 0x79, 0x12, 0x27, 0x0a, 0x0f, 0x62, 0x61, 0x6e, 0x64, 0x77, 0x69, 0x64, 0x74, 0x68, 0x5f,
+	0x6c, 0x69, 0x6d, 0x69, 0x74, 0x18, 0x04, 0x20, 0x01, 0x28, 0x04, 0x52, 0x0e, 0x62, 0x61, 0x6e,
+	0x64, 0x77, 0x69, 0x64, 0x74, 0x68, 0x4c, 0x69, 0x6d, 0x69, 0x74, 0x12, 0x1e, 0x0a, 0x0a, 0x74,
+	0x68, 0x72, 0x6f, 0x75, 0x67, 0x68, 0x70, 0x75, 0x74, 0x18, 0x05, 0x20, 0x01, 0x28, 0x04, 0x52,
+	0x0a, 0x74, 0x68, 0x72, 0x6f, 0x75, 0x67, 0x68, 0x70, 0x75, 0x74, 0x12, 0x26, 0x0a, 0x0f, 0x73,
+	0x65, 0x6e, 0x64, 0x5f, 0x6c, 0x61, 0x74, 0x65, 0x6e, 0x63, 0x79, 0x5f, 0x6d, 0x73, 0x18, 0x06,
+	0x20, 0x01, 0x28, 0x0d, 0x52, 0x0d, 0x73, 0x65, 0x6e, 0x64, 0x4c, 0x61, 0x74, 0x65, 0x6e, 0x63,
+	0x79, 0x4d, 0x73, 0x22, 0xf9, 0x01, 0x0a, 0x0b, 0x44, 0x69, 0x73, 0x70, 0x6c, 0x61, 0x79, 0x49,
+	0x6e, 0x66, 0x6f, 0x12, 0x14, 0x0a, 0x05, 0x69, 0x6e, 0x64, 0x65, 0x78, 0x18, 0x01, 0x20, 0x01,
+	0x28, 0x0d, 0x52, 0x05, 0x69, 0x6e, 0x64, 0x65, 0x78, 0x12, 0x0c, 0x0a, 0x01, 0x78, 0x18, 0x02,
+	0x20, 0x01, 0x28, 0x05, 0x52, 0x01, 0x78, 0x12, 0x0c, 0x0a, 0x01, 0x79, 0x18, 0x03, 0x20, 0x01,
+	0x28, 0x05, 0x52, 0x01, 0x79, 0x12, 0x14, 0x0a, 0x05, 0x77, 0x69, 0x64, 0x74, 0x68, 0x18, 0x04,
+	0x20, 0x01, 0x28, 0x0d, 0x52, 0x05, 0x77, 0x69, 0x64, 0x74, 0x68, 0x12, 0x16, 0x0a, 0x06, 0x68,
+	0x65, 0x69, 0x67, 0x68, 0x74, 0x18, 0x05, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x06, 0x68, 0x65, 0x69,
+	0x67, 0x68, 0x74, 0x12, 0x14, 0x0a, 0x05, 0x73, 0x63, 0x61, 0x6c, 0x65, 0x18, 0x06, 0x20, 0x01,
+	0x28, 0x02, 0x52, 0x05, 0x73, 0x63, 0x61, 0x6c, 0x65, 0x12, 0x39, 0x0a, 0x06, 0x73, 0x74, 0x61,
+	0x74, 0x75, 0x73, 0x18, 0x07, 0x20, 0x01, 0x28, 0x0e, 0x32, 0x21, 0x2e, 0x75, 0x70, 0x6c, 0x6f,
+	0x61, 0x64, 0x2e, 0x44, 0x69, 0x73, 0x70, 0x6c, 0x61, 0x79, 0x49, 0x6e, 0x66, 0x6f, 0x2e, 0x43,
+	0x61, 0x70, 0x74, 0x75, 0x72, 0x65, 0x53, 0x74, 0x61, 0x74, 0x75, 0x73, 0x52, 0x06, 0x73, 0x74,
+	0x61, 0x74, 0x75, 0x73, 0x12, 0x14, 0x0a, 0x05, 0x65, 0x72, 0x72, 0x6f, 0x72, 0x18, 0x08, 0x20,
+	0x01, 0x28, 0x09, 0x52, 0x05, 0x65, 0x72, 0x72, 0x6f, 0x72, 0x22, 0x23, 0x0a, 0x0d, 0x43, 0x61,
+	0x70, 0x74, 0x75, 0x72, 0x65, 0x53, 0x74, 0x61, 0x74, 0x75, 0x73, 0x12, 0x06, 0x0a, 0x02, 0x4f,
+	0x4b, 0x10, 0x00, 0x12, 0x0a, 0x0a, 0x06, 0x46, 0x41, 0x49, 0x4c, 0x45, 0x44, 0x10, 0x01, 0x22,
+	0x7a, 0x0a, 0x0c, 0x44, 0x69, 0x73, 0x70, 0x6c, 0x61, 0x79, 0x44, 0x65, 0x6c, 0x74, 0x61, 0x12,
+	0x18, 0x0a, 0x07, 0x64, 0x69, 0x73, 0x70, 0x6c, 0x61, 0x79, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0d,
+	0x52, 0x07, 0x64, 0x69, 0x73, 0x70, 0x6c, 0x61, 0x79, 0x12, 0x14, 0x0a, 0x05, 0x77, 0x69, 0x64,
+	0x74, 0x68, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x05, 0x77, 0x69, 0x64, 0x74, 0x68, 0x12,
+	0x16, 0x0a, 0x06, 0x68, 0x65, 0x69, 0x67, 0x68, 0x74, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0d, 0x52,
+	0x06, 0x68, 0x65, 0x69, 0x67, 0x68, 0x74, 0x12, 0x22, 0x0a, 0x05, 0x74, 0x69, 0x6c, 0x65, 0x73,
+	0x18, 0x04, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x0c, 0x2e, 0x75, 0x70, 0x6c, 0x6f, 0x61, 0x64, 0x2e,
+	0x54, 0x69, 0x6c, 0x65, 0x52, 0x05, 0x74, 0x69, 0x6c, 0x65, 0x73, 0x22, 0x91, 0x01, 0x0a, 0x04,
+	0x54, 0x69, 0x6c, 0x65, 0x12, 0x0c, 0x0a, 0x01, 0x78, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0d, 0x52,
+	0x01, 0x78, 0x12, 0x0c, 0x0a, 0x01, 0x79, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x01, 0x79,
+	0x12, 0x14, 0x0a, 0x05, 0x77, 0x69, 0x64, 0x74, 0x68, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0d, 0x52,
+	0x05, 0x77, 0x69, 0x64, 0x74, 0x68, 0x12, 0x16, 0x0a, 0x06, 0x68, 0x65, 0x69, 0x67, 0x68, 0x74,
+	0x18, 0x04, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x06, 0x68, 0x65, 0x69, 0x67, 0x68, 0x74, 0x12, 0x12,
+	0x0a, 0x04, 0x64, 0x61, 0x74, 0x61, 0x18, 0x05, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x04, 0x64, 0x61,
+	0x74, 0x61, 0x12, 0x2b, 0x0a, 0x06, 0x66, 0x6f, 0x72, 0x6d, 0x61, 0x74, 0x18, 0x06, 0x20, 0x01,
+	0x28, 0x0e, 0x32, 0x13, 0x2e, 0x75, 0x70, 0x6c, 0x6f, 0x61, 0x64, 0x2e, 0x49, 0x6d, 0x61, 0x67,
+	0x65, 0x46, 0x6f, 0x72, 0x6d, 0x61, 0x74, 0x52, 0x06, 0x66, 0x6f, 0x72, 0x6d, 0x61, 0x74, 0x2a,
+	0x3c, 0x0a, 0x0b, 0x49, 0x6d, 0x61, 0x67, 0x65, 0x46, 0x6f, 0x72, 0x6d, 0x61, 0x74, 0x12, 0x0c,
+	0x0a, 0x08, 0x50, 0x4e, 0x47, 0x5f, 0x5a, 0x4c, 0x49, 0x42, 0x10, 0x00, 0x12, 0x07, 0x0a, 0x03,
+	0x50, 0x4e, 0x47, 0x10, 0x01, 0x12, 0x08, 0x0a, 0x04, 0x4a, 0x50, 0x45, 0x47, 0x10, 0x02, 0x12,
+	0x0c, 0x0a, 0x08, 0x52, 0x41, 0x57, 0x5f, 0x5a, 0x53, 0x54, 0x44, 0x10, 0x03, 0x42, 0x0c, 0x5a,
+	0x0a, 0x2e, 0x2f, 0x75, 0x70, 0x6c, 0x6f, 0x61, 0x64, 0x70, 0x62, 0x62, 0x06, 0x70, 0x72, 0x6f,
+	0x74, 0x6f, 0x33,
+}
+
+var (
+	file_upload_proto_rawDescOnce sync.Once
+	file_upload_proto_rawDescData = file_upload_proto_rawDesc
+)
+
+func file_upload_proto_rawDescGZIP() []byte {
+	file_upload_proto_rawDescOnce.Do(func() {
+		file_upload_proto_rawDescData = protoimpl.X.CompressGZIP(file_upload_proto_rawDescData)
+	})
+	return file_upload_proto_rawDescData
+}
+
+var file_upload_proto_enumTypes = make([]protoimpl.EnumInfo, 7)
+var file_upload_proto_msgTypes = make([]protoimpl.MessageInfo, 16)
+var file_upload_proto_goTypes = []interface{}{
+	(ImageFormat)(0),                // 0: upload.ImageFormat
+	(ServerResponse_MessageType)(0), // 1: upload.ServerResponse.MessageType
+	(Command_Action)(0),             // 2: upload.Command.Action
+	(Error_Code)(0),                 // 3: upload.Error.Code
+	(ClientRequest_RequestType)(0),  // 4: upload.ClientRequest.RequestType
+	(ImageUpload_FrameType)(0),      // 5: upload.ImageUpload.FrameType
+	(DisplayInfo_CaptureStatus)(0),  // 6: upload.DisplayInfo.CaptureStatus
+	(*ServerResponse)(nil),          // 7: upload.ServerResponse
+	(*Authenticated)(nil),           // 8: upload.Authenticated
+	(*Heartbeat)(nil),               // 9: upload.Heartbeat
+	(*StreamControl)(nil),           // 10: upload.StreamControl
+	(*Credential)(nil),              // 11: upload.Credential
+	(*Command)(nil),                 // 12: upload.Command
+	(*Rejected)(nil),                // 13: upload.Rejected
+	(*Error)(nil),                   // 14: upload.Error
+	(*ClientRequest)(nil),           // 15: upload.ClientRequest
+	(*Register)(nil),                // 16: upload.Register
+	(*CommandAck)(nil),              // 17: upload.CommandAck
+	(*ImageUpload)(nil),             // 18: upload.ImageUpload
+	(*CaptureSettings)(nil),         // 19: upload.CaptureSettings
+	(*DisplayInfo)(nil),             // 20: upload.DisplayInfo
+	(*DisplayDelta)(nil),            // 21: upload.DisplayDelta
+	(*Tile)(nil),                    // 22: upload.Tile
+	(*timestamppb.Timestamp)(nil),   // 23: google.protobuf.Timestamp
+}
+var file_upload_proto_depIdxs = []int32{
+	1,  // 0: upload.ServerResponse.type:type_name -> upload.ServerResponse.MessageType
+	11, // 1: upload.Authenticated.credential:type_name -> upload.Credential
+	23, // 2: upload.Heartbeat.sent:type_name -> google.protobuf.Timestamp
+	2,  // 3: upload.Command.action:type_name -> upload.Command.Action
+	3,  // 4: upload.Error.code:type_name -> upload.Error.Code
+	4,  // 5: upload.ClientRequest.type:type_name -> upload.ClientRequest.RequestType
+	11, // 6: upload.Register.credential:type_name -> upload.Credential
+	23, // 7: upload.ImageUpload.timestamp:type_name -> google.protobuf.Timestamp
+	5,  // 8: upload.ImageUpload.frame_type:type_name -> upload.ImageUpload.FrameType
+	21, // 9: upload.ImageUpload.deltas:type_name -> upload.DisplayDelta
+	0,  // 10: upload.ImageUpload.formats:type_name -> upload.ImageFormat
+	20, // 11: upload.ImageUpload.displays:type_name -> upload.DisplayInfo
+	19, // 12: upload.ImageUpload.settings:type_name -> upload.CaptureSettings
+	6,  // 13: upload.DisplayInfo.status:type_name -> upload.DisplayInfo.CaptureStatus
+	22, // 14: upload.DisplayDelta.tiles:type_name -> upload.Tile
+	0,  // 15: upload.Tile.format:type_name -> upload.ImageFormat
+	16, // [16:16] is the sub-list for method output_type
+	16, // [16:16] is the sub-list for method input_type
+	16, // [16:16] is the sub-list for extension type_name
+	16, // [16:16] is the sub-list for extension extendee
+	0,  // [0:16] is the sub-list for field type_name
+}
+
+func init() { file_upload_proto_init() }
+func file_upload_proto_init() {
+	if File_upload_proto != nil {
+		return
+	}
+	if !protoimpl.UnsafeEnabled {
+		file_upload_proto_msgTypes[0].Exporter = func(v interface{}, i int) interface{} {
 			switch v := v.(*ServerResponse); i {
 			case 0:
 				return &v.state
@@ -418,7 +1957,7 @@ func file_upload_proto_init() {
 			}
 		}
 		file_upload_proto_msgTypes[1].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*ClientRequest); i {
+			switch v := v.(*Authenticated); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -430,7 +1969,7 @@ func file_upload_proto_init() {
 			}
 		}
 		file_upload_proto_msgTypes[2].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*Register); i {
+			switch v := v.(*Heartbeat); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -442,6 +1981,102 @@ func file_upload_proto_init() {
 			}
 		}
 		file_upload_proto_msgTypes[3].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*StreamControl); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_upload_proto_msgTypes[4].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Credential); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_upload_proto_msgTypes[5].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Command); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_upload_proto_msgTypes[6].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Rejected); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_upload_proto_msgTypes[7].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Error); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_upload_proto_msgTypes[8].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*ClientRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_upload_proto_msgTypes[9].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Register); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_upload_proto_msgTypes[10].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*CommandAck); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_upload_proto_msgTypes[11].Exporter = func(v interface{}, i int) interface{} {
 			switch v := v.(*ImageUpload); i {
 			case 0:
 				return &v.state
@@ -453,14 +2088,62 @@ func file_upload_proto_init() {
 				return nil
 			}
 		}
+		file_upload_proto_msgTypes[12].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*CaptureSettings); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_upload_proto_msgTypes[13].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*DisplayInfo); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_upload_proto_msgTypes[14].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*DisplayDelta); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_upload_proto_msgTypes[15].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Tile); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
 	}
 	type x struct{}
 	out := protoimpl.TypeBuilder{
 		File: protoimpl.DescBuilder{
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: file_upload_proto_rawDesc,
-			NumEnums:      2,
-			NumMessages:   4,
+			NumEnums:      7,
+			NumMessages:   16,
 			NumExtensions: 0,
 			NumServices:   0,
 		},
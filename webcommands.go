@@ -0,0 +1,101 @@
+package goscreenmonit
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/micaiahwallace/goscreenmonit/uploadpb"
+)
+
+// Request body for sending a command to an agent
+type commandRequest struct {
+	Action  string `json:"action"`
+	FPS     uint32 `json:"fps"`
+	Codec   string `json:"codec"`
+	Quality uint32 `json:"quality"`
+}
+
+// Handle sending a command to an agent, responding once the agent acknowledges it
+func (server *WebServer) handleSendCommand(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	// Parse the command, actions are named like set_fps or snapshot
+	req := commandRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	action, ok := uploadpb.Command_Action_value[strings.ToUpper(req.Action)]
+	if !ok {
+		http.Error(w, "Unknown Action", http.StatusBadRequest)
+		return
+	}
+	cmd := &uploadpb.Command{
+		Action:  uploadpb.Command_Action(action),
+		Fps:     req.FPS,
+		Codec:   req.Codec,
+		Quality: req.Quality,
+	}
+
+	ack, err := server.mserver.SendCommand(id, cmd, DefaultCommandTimeout)
+	switch {
+	case err == ErrAgentNotConnected:
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	case err == ErrCommandsUnsupported:
+		http.Error(w, "Agent Does Not Support Commands", http.StatusNotImplemented)
+		return
+	case errors.Is(err, ErrInvalidCommand):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case err == ErrCommandTimeout:
+		http.Error(w, "Agent Timeout", http.StatusGatewayTimeout)
+		return
+	case err != nil:
+		log.Printf("Unable to send command to %s: %v\n", id, err)
+		http.Error(w, "Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, map[string]interface{}{
+		"id":     ack.GetId(),
+		"action": strings.ToLower(cmd.GetAction().String()),
+		"ok":     ack.GetOk(),
+		"error":  ack.GetError(),
+	})
+}
+
+// Handle fetching a display of the agent's latest snapshot
+func (server *WebServer) handleGetSnapshot(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	screennum, converr := strconv.Atoi(vars["screen"])
+	if converr != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	// Snapshots are only kept while the agent is connected
+	client := server.mserver.GetClient(vars["id"])
+	if client == nil {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	snapshot := client.GetSnapshot()
+	images := snapshot.GetImages()
+	if screennum < 0 || screennum >= len(images) || len(images[screennum]) == 0 {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+
+	im := images[screennum]
+	w.Header().Set("Content-Type", ImageContentType(imageFormat(snapshot.GetFormats(), screennum)))
+	w.Header().Set("Content-Length", strconv.Itoa(len(im)))
+	w.Header().Set("X-Frame-Timestamp", snapshot.GetTimestamp().AsTime().Format(time.RFC3339Nano))
+	w.Write(im)
+}
@@ -0,0 +1,132 @@
+package goscreenmonit
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Request body for creating an enrollment token
+type createTokenRequest struct {
+	Name      string `json:"name"`
+	MaxUses   int    `json:"maxUses"`
+	ExpiresIn string `json:"expiresIn"`
+}
+
+// Get the enrollment store or respond with an error when disabled
+func (server *WebServer) enrollmentStore(w http.ResponseWriter) *EnrollmentStore {
+	store := server.mserver.GetEnrollment()
+	if store == nil {
+		http.Error(w, "Enrollment Disabled", http.StatusNotFound)
+	}
+	return store
+}
+
+// Handle listing enrollment tokens
+func (server *WebServer) handleListTokens(w http.ResponseWriter, r *http.Request) {
+	store := server.enrollmentStore(w)
+	if store == nil {
+		return
+	}
+	writeJSON(w, store.ListTokens())
+}
+
+// Handle creating an enrollment token, the plaintext token is only returned here
+func (server *WebServer) handleCreateToken(w http.ResponseWriter, r *http.Request) {
+	store := server.enrollmentStore(w)
+	if store == nil {
+		return
+	}
+
+	// Parse token options
+	req := createTokenRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	var ttl time.Duration
+	if req.ExpiresIn != "" {
+		d, err := time.ParseDuration(req.ExpiresIn)
+		if err != nil {
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+		ttl = d
+	}
+
+	token, secret, err := store.CreateToken(req.Name, req.MaxUses, ttl)
+	if err != nil {
+		log.Printf("Unable to create enrollment token: %v\n", err)
+		http.Error(w, "Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Created enrollment token %s (%s)\n", token.ID, token.Name)
+	writeJSON(w, map[string]interface{}{
+		"token":  secret,
+		"record": token,
+	})
+}
+
+// Handle revoking an enrollment token
+func (server *WebServer) handleRevokeToken(w http.ResponseWriter, r *http.Request) {
+	store := server.enrollmentStore(w)
+	if store == nil {
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+	if err := store.RevokeToken(id); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	log.Printf("Revoked enrollment token %s\n", id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Handle listing enrolled agents
+func (server *WebServer) handleListAgents(w http.ResponseWriter, r *http.Request) {
+	store := server.enrollmentStore(w)
+	if store == nil {
+		return
+	}
+	writeJSON(w, store.ListCredentials())
+}
+
+// Handle revoking an enrolled agent, disconnecting it if connected
+func (server *WebServer) handleRevokeAgent(w http.ResponseWriter, r *http.Request) {
+	if server.enrollmentStore(w) == nil {
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+	if err := server.mserver.RevokeAgent(id); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	log.Printf("Revoked agent credential %s\n", id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Write a value as a json response
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		http.Error(w, "Server Error", http.StatusInternalServerError)
+	}
+}
+
+// Map store errors to http errors
+func writeStoreError(w http.ResponseWriter, err error) {
+	if err == ErrNotFound {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	log.Printf("Enrollment store error: %v\n", err)
+	http.Error(w, "Server Error", http.StatusInternalServerError)
+}
@@ -0,0 +1,327 @@
+package goscreenmonit
+
+import (
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsutil"
+	"github.com/gorilla/mux"
+)
+
+// Playback speeds viewers may request
+var PlaybackSpeeds = []float64{1, 2, 8}
+
+// Default gap between frames that splits recording ranges
+const defaultRangeGap = 30 * time.Second
+
+// Longest real time wait between two played back frames, so recording gaps don't stall playback
+const maxPlaybackWait = 2 * time.Second
+
+// Control message a playback viewer sends as websocket text
+type playbackControl struct {
+	Seek  string  `json:"seek"`
+	Speed float64 `json:"speed"`
+}
+
+// Metadata sent as websocket text before each played back frame
+type playbackFrame struct {
+	Timestamp time.Time `json:"timestamp"`
+	Index     int       `json:"index"`
+	Total     int       `json:"total"`
+	Speed     float64   `json:"speed"`
+	End       bool      `json:"end,omitempty"`
+}
+
+// Get the frame store or respond with an error when recording is disabled
+func (server *WebServer) recordings(w http.ResponseWriter) *FrameStore {
+	if server.frames == nil {
+		http.Error(w, "Recording Disabled", http.StatusNotFound)
+	}
+	return server.frames
+}
+
+// Handle listing recording ranges for each display of an agent
+func (server *WebServer) handleGetRecordings(w http.ResponseWriter, r *http.Request) {
+	store := server.recordings(w)
+	if store == nil {
+		return
+	}
+	id := mux.Vars(r)["id"]
+
+	// Optional gap that splits ranges
+	gap := defaultRangeGap
+	if g := r.URL.Query().Get("gap"); g != "" {
+		d, err := time.ParseDuration(g)
+		if err != nil {
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+		gap = d
+	}
+
+	displays, err := store.Displays(id)
+	if err != nil {
+		log.Printf("Unable to list recordings for %s: %v\n", id, err)
+		http.Error(w, "Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	result := make([]map[string]interface{}, 0, len(displays))
+	for _, d := range displays {
+		ranges, rerr := store.Ranges(id, d, gap)
+		if rerr != nil {
+			log.Printf("Unable to list recording ranges for %s: %v\n", id, rerr)
+			http.Error(w, "Server Error", http.StatusInternalServerError)
+			return
+		}
+		result = append(result, map[string]interface{}{
+			"display": d,
+			"ranges":  ranges,
+		})
+	}
+	writeJSON(w, result)
+}
+
+// Handle fetching the recorded frame nearest a timestamp
+func (server *WebServer) handleGetRecordedFrame(w http.ResponseWriter, r *http.Request) {
+	store := server.recordings(w)
+	if store == nil {
+		return
+	}
+
+	vars := mux.Vars(r)
+	screennum, converr := strconv.Atoi(vars["screen"])
+	if converr != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	t, terr := parseTimeParam(r.URL.Query().Get("t"))
+	if terr != nil || t.IsZero() {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	frame, err := store.Nearest(vars["id"], screennum, t)
+	if err == ErrNotFound {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Unable to find recorded frame: %v\n", err)
+		http.Error(w, "Server Error", http.StatusInternalServerError)
+		return
+	}
+	im, err := store.Read(frame)
+	if err != nil {
+		log.Printf("Unable to read recorded frame: %v\n", err)
+		http.Error(w, "Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", ImageContentType(frame.Format))
+	w.Header().Set("Content-Length", strconv.Itoa(len(im)))
+	w.Header().Set("X-Frame-Timestamp", frame.Timestamp.Format(time.RFC3339Nano))
+	w.Write(im)
+}
+
+// Handle a playback websocket streaming recorded frames for a time range
+func (server *WebServer) handlePlayback(w http.ResponseWriter, r *http.Request, id string, screennum int, authUser string) {
+	store := server.recordings(w)
+	if store == nil {
+		return
+	}
+
+	// Parse playback range and speed
+	query := r.URL.Query()
+	from, ferr := parseTimeParam(query.Get("from"))
+	to, terr := parseTimeParam(query.Get("to"))
+	if ferr != nil || terr != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	speed := 1.0
+	if s := query.Get("speed"); s != "" {
+		parsed, err := strconv.ParseFloat(s, 64)
+		if err != nil || !validSpeed(parsed) {
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+		speed = parsed
+	}
+
+	frames, err := store.Between(id, screennum, from, to)
+	if err != nil {
+		log.Printf("Unable to load recording for %s: %v\n", id, err)
+		http.Error(w, "Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	// Upgrade request to a websocket
+	conn, _, _, err := ws.UpgradeHTTP(r, w)
+	if err != nil {
+		http.Error(w, "Upgrade Error", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Started playback for %s of %s (%d frames)\n", authUser, id, len(frames))
+
+	// Read control messages until the browser goes away or playback stops
+	controls := make(chan playbackControl)
+	done := make(chan struct{})
+	stop := make(chan struct{})
+	go func(conn net.Conn) {
+		defer close(done)
+		for {
+			msg, op, err := wsutil.ReadClientData(conn)
+			if err != nil {
+				return
+			}
+			if op != ws.OpText {
+				continue
+			}
+			ctl := playbackControl{}
+			if err := json.Unmarshal(msg, &ctl); err != nil {
+				log.Printf("Invalid playback control from %s: %v\n", authUser, err)
+				continue
+			}
+			select {
+			case controls <- ctl:
+			case <-stop:
+				return
+			}
+		}
+	}(conn)
+
+	go func(conn net.Conn) {
+		defer func() {
+			close(stop)
+			conn.Close()
+			log.Printf("Stopped playback for %s of %s\n", authUser, id)
+		}()
+		server.playFrames(conn, store, frames, speed, controls, done)
+	}(conn)
+}
+
+// Stream frames in time order, honoring seek and speed changes
+func (server *WebServer) playFrames(conn net.Conn, store *FrameStore, frames []StoredFrame, speed float64, controls chan playbackControl, done chan struct{}) {
+	stall := server.hub.StallTimeout()
+	index := 0
+
+	for {
+		var wait <-chan time.Time
+		var timer *time.Timer
+		var delay time.Duration
+
+		if index < len(frames) {
+			frame := frames[index]
+
+			// Send frame metadata then the image
+			im, err := store.Read(frame)
+			if err != nil {
+				log.Printf("Unable to read recorded frame: %v\n", err)
+				index++
+				continue
+			}
+			meta, _ := json.Marshal(playbackFrame{Timestamp: frame.Timestamp, Index: index, Total: len(frames), Speed: speed})
+			conn.SetWriteDeadline(time.Now().Add(stall))
+			if err := wsutil.WriteServerText(conn, meta); err != nil {
+				return
+			}
+			if err := wsutil.WriteServerBinary(conn, im); err != nil {
+				return
+			}
+
+			// Wait for the recorded interval scaled by speed
+			index++
+			if index < len(frames) {
+				delay = playbackDelay(frames[index].Timestamp.Sub(frame.Timestamp), speed)
+			}
+			timer = time.NewTimer(delay)
+			wait = timer.C
+		} else if index == len(frames) {
+
+			// Tell the viewer the range ended, then idle until a seek
+			meta, _ := json.Marshal(playbackFrame{Index: index, Total: len(frames), Speed: speed, End: true})
+			conn.SetWriteDeadline(time.Now().Add(stall))
+			if err := wsutil.WriteServerText(conn, meta); err != nil {
+				return
+			}
+			index++
+		}
+
+		// A speed change rescales what is left of the wait, a seek plays the new position at once
+		started := time.Now()
+		for waiting := true; waiting; {
+			select {
+			case <-done:
+				if timer != nil {
+					timer.Stop()
+				}
+				return
+			case <-wait:
+				waiting = false
+			case ctl := <-controls:
+				if ctl.Speed != 0 && validSpeed(ctl.Speed) && ctl.Speed != speed {
+					if timer != nil {
+						remaining := delay - time.Since(started)
+						if remaining < 0 {
+							remaining = 0
+						}
+						delay = playbackDelay(time.Duration(float64(remaining)*speed), ctl.Speed)
+						started = time.Now()
+						timer.Stop()
+						timer = time.NewTimer(delay)
+						wait = timer.C
+					}
+					speed = ctl.Speed
+				}
+				if ctl.Seek != "" {
+					t, err := parseTimeParam(ctl.Seek)
+					if err != nil {
+						continue
+					}
+					index = sort.Search(len(frames), func(i int) bool { return !frames[i].Timestamp.Before(t) })
+					waiting = false
+				}
+			}
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+// Scale a recorded interval by the playback speed, capping long gaps in the recording
+func playbackDelay(gap time.Duration, speed float64) time.Duration {
+	delay := time.Duration(float64(gap) / speed)
+	if delay > maxPlaybackWait {
+		delay = maxPlaybackWait
+	}
+	return delay
+}
+
+// Check if a playback speed is supported
+func validSpeed(speed float64) bool {
+	for _, s := range PlaybackSpeeds {
+		if s == speed {
+			return true
+		}
+	}
+	return false
+}
+
+// Parse a time given as RFC3339 or unix milliseconds, empty is the zero time
+func parseTimeParam(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if ms, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(0, ms*int64(time.Millisecond)), nil
+	}
+	return time.Parse(time.RFC3339Nano, value)
+}
@@ -1,17 +1,23 @@
 package goscreenmonit
 
 import (
+	"bytes"
+	"crypto/tls"
 	"encoding/json"
+	"image"
 	"log"
 	"net"
 	"net/http"
 	"os"
 	"path"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gobwas/ws"
 	"github.com/gobwas/ws/wsutil"
 	"github.com/gorilla/mux"
+	"github.com/micaiahwallace/goscreenmonit/uploadpb"
 )
 
 // Runs a web server front-end for the monitor server backend
@@ -19,24 +25,73 @@ type WebServer struct {
 	address  string
 	certPath string
 	keyPath  string
+	root     *mux.Router
 	router   *mux.Router
 	mserver  *Server
+	hub      *FrameHub
+	frames   *FrameStore
+	listener net.Listener
 }
 
 // Create a web server
 func NewWebServer(address, cert, key string, monitorsrv *Server) *WebServer {
 	return &WebServer{
 		mserver:  monitorsrv,
+		hub:      NewFrameHub(monitorsrv),
 		address:  address,
 		certPath: cert,
 		keyPath:  key,
 	}
 }
 
+// Configure per-viewer frame queue length and how long a stuck viewer is tolerated
+func (server *WebServer) SetViewerLimits(queueSize int, stall time.Duration) {
+	server.hub.SetLimits(queueSize, stall)
+}
+
+// Serve recorded frames for timeline and playback
+func (server *WebServer) SetFrameStore(store *FrameStore) {
+	server.frames = store
+}
+
+// Serve from a listener that already terminates tls instead of listening on the address
+func (server *WebServer) SetListener(listener net.Listener) {
+	server.listener = listener
+}
+
 // Start running the web server
 func (server *WebServer) Start() {
 	server.setupRoutes()
-	http.ListenAndServeTLS(server.address, server.certPath, server.keyPath, server.router)
+	var err error
+	if server.listener != nil {
+		httpServer := &http.Server{
+			Handler:     restoreSniffedTLS(server.root),
+			ConnContext: sniffedConnContext,
+		}
+		err = httpServer.Serve(server.listener)
+	} else {
+		err = server.listenAndServeTLS()
+	}
+	log.Printf("Web server stopped: %v\n", err)
+}
+
+// Listen on the web address. In mtls mode client certificates are requested so websocket
+// agents can be verified, but browsers may still connect without one.
+func (server *WebServer) listenAndServeTLS() error {
+	pool, err := server.mserver.clientCAs()
+	if err != nil {
+		return err
+	}
+	httpServer := &http.Server{
+		Addr:      server.address,
+		Handler:   server.root,
+		TLSConfig: &tls.Config{},
+	}
+	if pool != nil {
+		httpServer.TLSConfig.ClientCAs = pool
+		httpServer.TLSConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return httpServer.ListenAndServeTLS(server.certPath, server.keyPath)
 }
 
 // Configure router and all routes
@@ -50,12 +105,26 @@ func (server *WebServer) setupRoutes() {
 		return
 	}
 
+	// Agents authenticate within the protocol, everything else needs credentials
+	server.root = mux.NewRouter()
+	server.root.HandleFunc(AgentWebSocketPath, server.handleAgent)
+
 	// Setup routes
-	server.router = mux.NewRouter()
+	server.router = server.root.PathPrefix("/").Subrouter()
 	authMiddleware := basicAuth(creds)
 	server.router.Use(authMiddleware)
 	server.router.HandleFunc("/monitors", server.handleGetMonitors)
-	server.router.HandleFunc("/ws/{address}/{screen}", server.handleWebsocket)
+	server.router.HandleFunc("/monitors/{id}/commands", server.handleSendCommand).Methods(http.MethodPost)
+	server.router.HandleFunc("/monitors/{id}/snapshot/{screen}", server.handleGetSnapshot).Methods(http.MethodGet)
+	server.router.HandleFunc("/ws/{id}/{screen}", server.handleWebsocket)
+	server.router.HandleFunc("/viewers", server.handleGetViewers)
+	server.router.HandleFunc("/recordings/{id}", server.handleGetRecordings)
+	server.router.HandleFunc("/recordings/{id}/{screen}/frame", server.handleGetRecordedFrame)
+	server.router.HandleFunc("/enrollment/tokens", server.handleListTokens).Methods(http.MethodGet)
+	server.router.HandleFunc("/enrollment/tokens", server.handleCreateToken).Methods(http.MethodPost)
+	server.router.HandleFunc("/enrollment/tokens/{id}", server.handleRevokeToken).Methods(http.MethodDelete)
+	server.router.HandleFunc("/enrollment/agents", server.handleListAgents).Methods(http.MethodGet)
+	server.router.HandleFunc("/enrollment/agents/{id}", server.handleRevokeAgent).Methods(http.MethodDelete)
 	// server.router.HandleFunc("/monitors/{address}/{screen}", server.handleScreenshot)
 	server.router.PathPrefix("/").Handler(http.StripPrefix("/", http.FileServer(http.Dir("./ui/build"))))
 }
@@ -110,15 +179,48 @@ func (server *WebServer) handleGetMonitors(w http.ResponseWriter, r *http.Reques
 	clients := server.mserver.GetClients()
 
 	// Convert to the format we want for json
-	monitors := []map[string]string{}
+	monitors := []map[string]interface{}{}
 
 	for _, client := range clients {
-		monitors = append(monitors, map[string]string{
-			"address":     client.Address,
-			"user":        client.Register.GetUser(),
-			"host":        client.Register.GetHost(),
-			"screenCount": strconv.Itoa(len(client.LatestUpload.GetImages())),
-		})
+		monitor := map[string]interface{}{
+			"id":           client.ID,
+			"address":      client.Address,
+			"user":         client.Register.GetUser(),
+			"host":         client.Register.GetHost(),
+			"agentVersion": client.Register.GetAgentVersion(),
+			"protocol":     strconv.Itoa(int(client.Version)),
+			"displays":     uploadDisplays(client.GetLatestUpload()),
+			"codec":        client.GetCodec(),
+		}
+		monitor["viewers"] = strconv.Itoa(server.hub.ViewerCount(client.ID))
+		monitor["streaming"] = strconv.FormatBool(server.mserver.IsStreaming(client.ID))
+		stats := client.GetStats()
+		monitor["frames"] = strconv.FormatUint(stats.Frames, 10)
+		monitor["bytes"] = strconv.FormatUint(stats.Bytes, 10)
+		monitor["fps"] = strconv.FormatFloat(stats.AgentFPS, 'f', 2, 64)
+		monitor["backfilled"] = strconv.FormatUint(stats.Backfilled, 10)
+		if !stats.LastFrameAt.IsZero() {
+			monitor["lastFrame"] = stats.LastFrameAt.Format(time.RFC3339)
+		}
+		if heartbeat := client.GetLastHeartbeat(); !heartbeat.IsZero() {
+			monitor["lastHeartbeat"] = heartbeat.Format(time.RFC3339)
+		}
+		if settings := client.GetLatestUpload().GetSettings(); settings != nil {
+			monitor["targetFps"] = strconv.FormatFloat(float64(settings.GetFps()), 'f', 2, 64)
+			monitor["scale"] = strconv.FormatFloat(float64(settings.GetScale()), 'f', 2, 64)
+			monitor["quality"] = strconv.Itoa(int(settings.GetQuality()))
+			monitor["bandwidthLimit"] = strconv.FormatUint(settings.GetBandwidthLimit(), 10)
+			monitor["throughput"] = strconv.FormatUint(settings.GetThroughput(), 10)
+			monitor["sendLatencyMs"] = strconv.Itoa(int(settings.GetSendLatencyMs()))
+		}
+		if client.CredentialID != "" {
+			monitor["credentialId"] = client.CredentialID
+		}
+		if client.Identity != nil {
+			monitor["certIdentity"] = client.Identity.CommonName
+			monitor["certFingerprint"] = client.Identity.Fingerprint
+		}
+		monitors = append(monitors, monitor)
 	}
 
 	// Send json back to user
@@ -127,12 +229,55 @@ func (server *WebServer) handleGetMonitors(w http.ResponseWriter, r *http.Reques
 	}
 }
 
+// Per-display info reported by /monitors
+type displayStatus struct {
+	Index  int     `json:"index"`
+	X      int     `json:"x"`
+	Y      int     `json:"y"`
+	Width  int     `json:"width"`
+	Height int     `json:"height"`
+	Scale  float32 `json:"scale"`
+	Status string  `json:"status"`
+	Error  string  `json:"error,omitempty"`
+}
+
+// Describe each display of an upload, reading sizes from the images when older agents sent no metadata
+func uploadDisplays(upload *uploadpb.ImageUpload) []displayStatus {
+	displays := make([]displayStatus, 0, len(upload.GetImages()))
+	for i, im := range upload.GetImages() {
+		if i < len(upload.GetDisplays()) {
+			info := upload.GetDisplays()[i]
+			displays = append(displays, displayStatus{
+				Index:  int(info.GetIndex()),
+				X:      int(info.GetX()),
+				Y:      int(info.GetY()),
+				Width:  int(info.GetWidth()),
+				Height: int(info.GetHeight()),
+				Scale:  info.GetScale(),
+				Status: strings.ToLower(info.GetStatus().String()),
+				Error:  info.GetError(),
+			})
+			continue
+		}
+
+		display := displayStatus{Index: i, Scale: 1, Status: "ok"}
+		if config, _, err := image.DecodeConfig(bytes.NewReader(im)); err == nil {
+			display.Width = config.Width
+			display.Height = config.Height
+		} else {
+			display.Status = "failed"
+		}
+		displays = append(displays, display)
+	}
+	return displays
+}
+
 // Handle websocket connections
 func (server *WebServer) handleWebsocket(w http.ResponseWriter, r *http.Request) {
 
-	// Get address to retrieve screenshot for
+	// Get agent id to retrieve screenshot for
 	vars := mux.Vars(r)
-	address := vars["address"]
+	id := vars["id"]
 	screennum, converr := strconv.Atoi(vars["screen"])
 	if converr != nil {
 		http.Error(w, "Bad Request", http.StatusBadRequest)
@@ -146,8 +291,14 @@ func (server *WebServer) handleWebsocket(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	// Get client connection for address
-	client := server.mserver.GetClient(address)
+	// Recorded playback doesn't need the agent to be connected
+	if r.URL.Query().Get("mode") == "playback" {
+		server.handlePlayback(w, r, id, screennum, authUser)
+		return
+	}
+
+	// Get client connection for agent id
+	client := server.mserver.GetClient(id)
 	if client == nil {
 		http.Error(w, "Not Found", http.StatusNotFound)
 		return
@@ -160,51 +311,53 @@ func (server *WebServer) handleWebsocket(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	// Handle sending images to client
-	go func(conn net.Conn) {
-
-		// Handle image updates from the client
-		handler := func() {
-
-			// Get list of images
-			images := client.LatestUpload.GetImages()
+	// Register as a viewer, frames follow the agent id across reconnects
+	viewer := server.hub.AddViewer(authUser, id, screennum)
+	release := server.mserver.Watch(id)
+	log.Printf("Added listener for %s to %s -> %s\n", authUser, client.Register.GetUser(), client.ID)
 
-			// Verify image index is valid
-			if screennum > len(images)-1 || screennum < 0 {
-				log.Printf("invalid screen number: %d\n", screennum)
+	// Listen for client messages until the browser goes away
+	go func(conn net.Conn) {
+		defer server.hub.RemoveViewer(viewer)
+		for {
+			if _, _, err := wsutil.ReadClientData(conn); err != nil {
 				return
 			}
-
-			// Send requested image to websocket
-			im := images[screennum]
-			if err := wsutil.WriteServerBinary(conn, im); err != nil {
-				log.Printf("Unable to write server binary: %v\n", err)
-			}
 		}
+	}(conn)
+
+	// Write queued frames to the viewer
+	go func(conn net.Conn) {
 
 		// Cleanup after function ends
 		defer func() {
+			server.hub.RemoveViewer(viewer)
+			release()
 			conn.Close()
-			if err := server.mserver.RemoveClientListener(address, &handler); err != nil {
-				log.Printf("Unable to remove listener: %v (%s->%s)\n", err, client.Register.GetUser(), client.Address)
-			} else {
-				log.Printf("Removed listener for user %s to %s -> %s\n", authUser, client.Register.GetUser(), client.Address)
-			}
+			stats := viewer.Stats()
+			log.Printf("Removed listener for user %s to %s -> %s (sent %d, dropped %d)\n", authUser, client.Register.GetUser(), client.ID, stats.Sent, stats.Dropped)
 		}()
 
-		// Add client listener
-		if err := server.mserver.AddClientListener(address, &handler); err != nil {
-			log.Printf("Unable to add client listener: %v\n", err)
-		} else {
-			log.Printf("Added listener for %s to %s -> %s\n", authUser, client.Register.GetUser(), client.Address)
-		}
-
-		// Listen for client messages
+		stall := server.hub.StallTimeout()
 		for {
-			_, _, err := wsutil.ReadClientData(conn)
-			if err != nil {
+			select {
+			case <-viewer.Done():
 				return
+			case im := <-viewer.Frames():
+
+				// Viewers stuck longer than the stall threshold are disconnected
+				conn.SetWriteDeadline(time.Now().Add(stall))
+				if err := wsutil.WriteServerBinary(conn, im); err != nil {
+					log.Printf("Unable to write server binary, disconnecting viewer %s: %v\n", authUser, err)
+					return
+				}
+				viewer.MarkSent()
 			}
 		}
 	}(conn)
 }
+
+// Handle listing viewers and their delivery counters
+func (server *WebServer) handleGetViewers(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, server.hub.Stats())
+}
@@ -0,0 +1,263 @@
+package goscreenmonit
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsutil"
+)
+
+// Web server path agents connect to when raw tls can't get through
+const AgentWebSocketPath = "/agent"
+
+// Stream over websocket binary messages, so the framed protocol runs unchanged inside a
+// websocket. Each write is sent as one message.
+type wsConn struct {
+	net.Conn
+	state      ws.State
+	reader     *wsutil.Reader
+	reading    bool
+	writeMutex sync.Mutex
+	tlsState   *tls.ConnectionState
+}
+
+// Wrap an upgraded websocket, reading from br first when the handshake left data buffered
+func newWSConn(conn net.Conn, br *bufio.Reader, state ws.State, tlsState *tls.ConnectionState) *wsConn {
+	wsc := &wsConn{
+		Conn:     conn,
+		state:    state,
+		tlsState: tlsState,
+	}
+	var src io.Reader = conn
+	if br != nil && br.Buffered() > 0 {
+		src = io.MultiReader(io.LimitReader(br, int64(br.Buffered())), conn)
+	}
+	wsc.reader = &wsutil.Reader{
+		Source:         src,
+		State:          state,
+		OnIntermediate: wsc.handleControl,
+	}
+	return wsc
+}
+
+// Read message payloads as one stream, answering control frames along the way
+func (wsc *wsConn) Read(b []byte) (int, error) {
+	for {
+		if wsc.reading {
+			n, err := wsc.reader.Read(b)
+			if err == io.EOF {
+				wsc.reading = false
+				if n == 0 {
+					continue
+				}
+				err = nil
+			}
+			return n, err
+		}
+
+		hdr, err := wsc.reader.NextFrame()
+		if err != nil {
+			return 0, err
+		}
+		if hdr.OpCode.IsControl() {
+			if err := wsc.handleControl(hdr, wsc.reader); err != nil {
+				var closed wsutil.ClosedError
+				if errors.As(err, &closed) {
+					return 0, io.EOF
+				}
+				return 0, err
+			}
+			continue
+		}
+
+		// The protocol is binary, skip anything else
+		if hdr.OpCode != ws.OpBinary {
+			if err := wsc.reader.Discard(); err != nil {
+				return 0, err
+			}
+			continue
+		}
+		wsc.reading = true
+	}
+}
+
+// Send b as a single binary message
+func (wsc *wsConn) Write(b []byte) (int, error) {
+	wsc.writeMutex.Lock()
+	defer wsc.writeMutex.Unlock()
+	if err := wsutil.WriteMessage(wsc.Conn, wsc.state, ws.OpBinary, b); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Get the tls state of the http request the websocket was upgraded from
+func (wsc *wsConn) ConnectionState() tls.ConnectionState {
+	if wsc.tlsState == nil {
+		return tls.ConnectionState{}
+	}
+	return *wsc.tlsState
+}
+
+// Answer a ping or close frame, writing the reply whole so it can't split a message
+func (wsc *wsConn) handleControl(hdr ws.Header, r io.Reader) error {
+	var reply bytes.Buffer
+	err := wsutil.ControlHandler{
+		Src:                 r,
+		Dst:                 &reply,
+		State:               wsc.state,
+		DisableSrcCiphering: true,
+	}.Handle(hdr)
+	if reply.Len() > 0 {
+		wsc.writeMutex.Lock()
+		_, werr := wsc.Conn.Write(reply.Bytes())
+		wsc.writeMutex.Unlock()
+		if err == nil {
+			err = werr
+		}
+	}
+	return err
+}
+
+// Handle an agent connecting over a websocket, serving it like a direct connection
+func (server *WebServer) handleAgent(w http.ResponseWriter, r *http.Request) {
+	conn, rw, _, err := ws.UpgradeHTTP(r, w)
+	if err != nil {
+		http.Error(w, "Upgrade Error", http.StatusBadRequest)
+		return
+	}
+	server.mserver.ServeConn(newWSConn(conn, rw.Reader, ws.StateServerSide, r.TLS))
+}
+
+// Dial a server's websocket endpoint, going through the http proxy from the environment if set
+func (session *Session) dialWebSocket(ctx context.Context, address string) (net.Conn, error) {
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "wss" {
+		return nil, fmt.Errorf("unsupported server scheme %q, use wss", u.Scheme)
+	}
+
+	// Proxies are chosen like https requests to the same host, honoring NO_PROXY
+	proxy, err := http.ProxyFromEnvironment(&http.Request{URL: &url.URL{Scheme: "https", Host: u.Host}})
+	if err != nil {
+		return nil, err
+	}
+
+	tlsconf := session.tlsConfig()
+	if tlsconf.ServerName == "" {
+		tlsconf.ServerName = u.Hostname()
+	}
+
+	// The websocket is an http request, even on a port shared with direct agents
+	tlsconf.NextProtos = []string{"http/1.1"}
+	dialer := ws.Dialer{
+		Timeout:   session.heartbeatTimeout,
+		TLSConfig: tlsconf,
+		NetDial: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			if proxy != nil {
+				return dialProxy(ctx, proxy, addr, session.heartbeatTimeout)
+			}
+			dialer := &net.Dialer{Timeout: session.heartbeatTimeout}
+			return dialer.DialContext(ctx, network, addr)
+		},
+	}
+	conn, br, _, err := dialer.Dial(ctx, address)
+	if err != nil {
+		return nil, err
+	}
+	return newWSConn(conn, br, ws.StateClientSide, nil), nil
+}
+
+// Open a tunnel to addr through an http or https proxy with CONNECT
+func dialProxy(ctx context.Context, proxy *url.URL, addr string, timeout time.Duration) (net.Conn, error) {
+	proxyAddr := proxy.Host
+	if proxy.Port() == "" {
+		port := "80"
+		if proxy.Scheme == "https" {
+			port = "443"
+		}
+		proxyAddr = net.JoinHostPort(proxy.Hostname(), port)
+	}
+	dialer := &net.Dialer{Timeout: timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", proxyAddr)
+	if err != nil {
+		return nil, fmt.Errorf("proxy %s: %w", proxyAddr, err)
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	// Abandon the exchange with the proxy when the dial is cancelled
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	// Talk to https proxies over tls
+	if proxy.Scheme == "https" {
+		tlsconn := tls.Client(conn, &tls.Config{ServerName: proxy.Hostname()})
+		if err := tlsconn.Handshake(); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("proxy %s: %w", proxyAddr, err)
+		}
+		conn = tlsconn
+	}
+
+	// Ask for a tunnel, authenticating with credentials from the proxy url
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if proxy.User != nil {
+		password, _ := proxy.User.Password()
+		auth := base64.StdEncoding.EncodeToString([]byte(proxy.User.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+auth)
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("proxy %s: %w", proxyAddr, err)
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("proxy %s: %w", proxyAddr, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("proxy %s refused tunnel to %s: %s", proxyAddr, addr, resp.Status)
+	}
+
+	// Nothing may arrive before our tls handshake, anything buffered means the tunnel is broken
+	if br.Buffered() > 0 {
+		conn.Close()
+		return nil, fmt.Errorf("proxy %s sent unexpected data after CONNECT", proxyAddr)
+	}
+	conn.SetDeadline(time.Time{})
+	log.Printf("Tunneling to %s through proxy %s.\n", addr, proxyAddr)
+	return conn, nil
+}